@@ -3,7 +3,11 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -70,6 +74,85 @@ func BenchmarkWordCountVariations(b *testing.B) {
 	})
 }
 
+// Auto-tuning settings: how long each trial runs, how many trials each worker
+// count gets, and how close to the best throughput a smaller count must get to be preferred
+const (
+	autoTuneTrial     = 50 * time.Millisecond
+	autoTuneRounds    = 4
+	autoTuneTolerance = 0.90
+)
+
+// AutoTuneWorkers times job fanned out over several worker counts between minW
+// and maxW (doubling from minW, plus maxW and NumCPU) and returns the knee: the
+// fewest workers reaching within 10% of the best measured throughput, since extra
+// workers past that point only add contention. Trials run round-robin over the
+// counts, alternating direction each round, and each count's throughputs are
+// averaged: a noisy moment on the machine neither sinks nor crowns any one count,
+// and a machine that speeds up or slows down during tuning favors none of them.
+// Expect it to take one to two seconds.
+func AutoTuneWorkers(job func(), minW, maxW int) int {
+	if minW < 1 {
+		minW = 1
+	}
+	if maxW < minW {
+		maxW = minW
+	}
+
+	candidates := []int{}
+	for w := minW; w < maxW; w *= 2 {
+		candidates = append(candidates, w)
+	}
+	candidates = append(candidates, maxW)
+	if cpus := runtime.NumCPU(); cpus > minW && cpus < maxW {
+		candidates = append(candidates, cpus)
+	}
+	sort.Ints(candidates)
+
+	throughputs := make([]float64, len(candidates)) // Summed over rounds
+	for round := 0; round < autoTuneRounds; round++ {
+		for k := range candidates {
+			i := k
+			if round%2 == 1 {
+				i = len(candidates) - 1 - k // Backwards, so drift cancels out
+			}
+			throughputs[i] += measureThroughput(job, candidates[i], autoTuneTrial)
+		}
+	}
+	best := 0.0
+	for _, t := range throughputs {
+		best = max(best, t)
+	}
+
+	for i, workers := range candidates {
+		if throughputs[i] >= best*autoTuneTolerance {
+			return workers
+		}
+	}
+	return maxW
+}
+
+// measureThroughput runs job on workers goroutines for duration and returns completed jobs per second
+func measureThroughput(job func(), workers int, duration time.Duration) float64 {
+	var completed atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(duration)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				job()
+				completed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return float64(completed.Load()) / time.Since(start).Seconds()
+}
+
 // Main function to demonstrate normal execution
 func main() {
 	fmt.Println("Word Count Demonstration:")
@@ -82,6 +165,9 @@ func main() {
 
 	fmt.Println("\nTotal Words in Sample Texts:")
 	fmt.Println(WordCount(textSamples))
+
+	workers := AutoTuneWorkers(func() { WordCount(sampleTexts) }, 1, 4*runtime.NumCPU())
+	fmt.Printf("\nBest worker count for WordCount: %d (NumCPU is %d)\n", workers, runtime.NumCPU())
 }
 
 // generateTextSamples creates a random slice of text samples
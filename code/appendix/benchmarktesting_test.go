@@ -0,0 +1,48 @@
+package main
+
+// Run with: go test benchmarktesting.go benchmarktesting_test.go
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// spin is a CPU-bound job with no shared state, so it scales until the CPUs run out
+func spin() {
+	x := 0
+	for i := 0; i < 20000; i++ {
+		x += i * i
+	}
+	_ = x
+}
+
+func TestAutoTuneWorkersCPUBound(t *testing.T) {
+	// Past NumCPU a CPU-bound job gains nothing, so the knee sits at or below it;
+	// allow one doubling above for timing noise. A busy machine can still swing a
+	// whole run, so the choice gets a few attempts.
+	const attempts = 3
+	cpus := runtime.NumCPU()
+	var chosen []int
+	for i := 0; i < attempts; i++ {
+		start := time.Now()
+		workers := AutoTuneWorkers(spin, 1, 64)
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Fatalf("auto-tuning took %v, want a few short trials", elapsed)
+		}
+		if workers >= 1 && workers <= 2*cpus {
+			return
+		}
+		chosen = append(chosen, workers)
+	}
+	t.Errorf("chose %v workers for a CPU-bound job on %d CPUs", chosen, cpus)
+}
+
+func TestAutoTuneWorkersBounds(t *testing.T) {
+	if w := AutoTuneWorkers(spin, 3, 3); w != 3 {
+		t.Errorf("minW = maxW = 3 chose %d", w)
+	}
+	if w := AutoTuneWorkers(spin, 0, -1); w != 1 {
+		t.Errorf("invalid bounds chose %d, want them clamped to 1", w)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +12,33 @@ import (
 
 var reqID int64
 
+// Stats is the latest runtime sample served on /stats
+type Stats struct {
+	Goroutines int    `json:"goroutines"`
+	Alloc      uint64 `json:"alloc_bytes"`
+	Requests   int64  `json:"requests"`
+}
+
+// latestStats holds the most recent sample; handlers only ever load it
+var latestStats atomic.Pointer[Stats]
+
+// sampleStats reads memory and goroutine stats every interval, keeping
+// runtime.ReadMemStats (which stops the world briefly) off the request path
+func sampleStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		latestStats.Store(&Stats{
+			Goroutines: runtime.NumGoroutine(),
+			Alloc:      mem.Alloc,
+		})
+		<-ticker.C
+	}
+}
+
 func handler(w http.ResponseWriter, r *http.Request) {
 	id := atomic.AddInt64(&reqID, 1)
 
@@ -18,15 +46,20 @@ func handler(w http.ResponseWriter, r *http.Request) {
 
 	time.Sleep(2 * time.Second) // Simulate some work
 
-	var mem runtime.MemStats
-	runtime.ReadMemStats(&mem)
-
 	fmt.Fprintf(w, "Request #%d handled\n", id)
-	fmt.Printf("[#%d] Done — Goroutines: %d, Alloc: %.2fMB\n",
-		id,
-		runtime.NumGoroutine(),
-		float64(mem.Alloc)/1024/1024,
-	)
+	fmt.Printf("[#%d] Done\n", id)
+}
+
+// statsHandler returns the latest sample plus the live request counter
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	var stats Stats
+	if s := latestStats.Load(); s != nil {
+		stats = *s
+	}
+	stats.Requests = atomic.LoadInt64(&reqID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
 }
 
 // run with: go run concurrent-http-server.go
@@ -35,8 +68,12 @@ func handler(w http.ResponseWriter, r *http.Request) {
 // $ curl http://localhost:8080 &
 // $ curl http://localhost:8080 &
 // $ curl http://localhost:8080 &
+// $ curl http://localhost:8080/stats
 func main() {
+	go sampleStats(1 * time.Second)
+
 	http.HandleFunc("/", handler)
+	http.HandleFunc("/stats", statsHandler)
 	fmt.Println("Server running at http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
@@ -0,0 +1,47 @@
+package main
+
+// Run with: go test concurrent-http-server.go concurrent-http-server_test.go
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// getStats calls /stats and decodes the fields it returned
+func getStats(t *testing.T) map[string]float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest("GET", "/stats", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q, want application/json", ct)
+	}
+	var fields map[string]float64
+	if err := json.NewDecoder(rec.Body).Decode(&fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestStatsEndpoint(t *testing.T) {
+	go sampleStats(time.Hour) // The first sample is taken right away
+	for latestStats.Load() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	before := getStats(t)
+	for _, field := range []string{"goroutines", "alloc_bytes", "requests"} {
+		if _, ok := before[field]; !ok {
+			t.Errorf("/stats is missing %q: %v", field, before)
+		}
+	}
+	if before["goroutines"] < 1 || before["alloc_bytes"] <= 0 {
+		t.Errorf("sample looks empty: %v", before)
+	}
+
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if after := getStats(t); after["requests"] != before["requests"]+1 {
+		t.Errorf("requests went from %v to %v, want one more", before["requests"], after["requests"])
+	}
+}
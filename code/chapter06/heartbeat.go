@@ -16,7 +16,14 @@ func monitor(ctx context.Context, hb chan<- struct{}) {
 			fmt.Println("Monitor stopped")
 			return
 		case <-ticker.C:
-			hb <- struct{}{}
+			// The send must watch ctx too, or a receiver that has already
+			// stopped listening would leave this goroutine blocked forever
+			select {
+			case hb <- struct{}{}:
+			case <-ctx.Done():
+				fmt.Println("Monitor stopped")
+				return
+			}
 		}
 	}
 }
@@ -27,11 +34,16 @@ func main() {
 
 	hb := make(chan struct{})
 
-	go monitor(ctx, hb)
+	stopped := make(chan struct{})
+	go func() {
+		monitor(ctx, hb)
+		close(stopped)
+	}()
 
 	for {
 		select {
 		case <-ctx.Done():
+			<-stopped // The monitor exits promptly, even mid-heartbeat
 			fmt.Println("Shutting down main")
 			return
 		case <-hb:
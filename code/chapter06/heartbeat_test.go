@@ -0,0 +1,32 @@
+package main
+
+// Run with: go test heartbeat.go heartbeat_test.go
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMonitorExitsWhileSending(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hb := make(chan struct{}) // Nobody receives, so the first heartbeat blocks
+	go monitor(ctx, hb)
+
+	time.Sleep(1200 * time.Millisecond) // Past the first tick, stuck on the send
+	if n := runtime.NumGoroutine(); n != before+1 {
+		t.Fatalf("%d goroutines while monitoring, want %d", n, before+1)
+	}
+	cancel()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n != before {
+		t.Errorf("%d goroutines after cancel, want %d: the monitor leaked", n, before)
+	}
+}
@@ -12,17 +12,43 @@ type key string
 
 const traceIDKey key = "traceID"
 
-func handler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	// Extract metadata from headers (e.g., trace ID)
-	traceID := r.Header.Get("X-Trace-ID")
-	if traceID == "" {
-		traceID = "unknown"
+// Chain composes middlewares into one, the first listed being the outermost:
+// Chain(a, b)(h) runs a, then b, then h.
+func Chain(middlewares ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](h)
+		}
+		return h
 	}
+}
+
+// withTraceID stores the request's trace ID in its context for the handlers below
+func withTraceID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Extract metadata from headers (e.g., trace ID)
+		traceID := r.Header.Get("X-Trace-ID")
+		if traceID == "" {
+			traceID = "unknown"
+		}
 
-	// Store trace ID in context
-	ctx = context.WithValue(ctx, traceIDKey, traceID)
+		// Store trace ID in context
+		ctx := context.WithValue(r.Context(), traceIDKey, traceID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withLogging logs each request with its trace ID and duration
+func withLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		log.Printf("%s %s trace=%v took %v", r.Method, r.URL.Path, r.Context().Value(traceIDKey), time.Since(start))
+	})
+}
+
+func handler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 
 	// Simulate work with cancellation awareness
 	select {
@@ -46,7 +72,8 @@ $ curl -m 1 localhost:8080
 */
 
 func main() {
-	http.HandleFunc("/", handler)
+	// Trace first, so the logger sees the trace ID
+	http.Handle("/", Chain(withTraceID, withLogging)(http.HandlerFunc(handler)))
 
 	srv := &http.Server{
 		Addr: ":8080",
@@ -0,0 +1,58 @@
+package main
+
+// Run with: go test http-context-cancellation.go http-context-cancellation_test.go
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordOrder returns a middleware noting when it enters and leaves
+func recordOrder(name string, events *[]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*events = append(*events, name+" in")
+			next.ServeHTTP(w, r)
+			*events = append(*events, name+" out")
+		})
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var events []string
+	h := Chain(recordOrder("a", &events), recordOrder("b", &events))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events = append(events, "handler")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	want := "a in,b in,handler,b out,a out"
+	if got := strings.Join(events, ","); got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+func TestTraceThenLogging(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	var seen any
+	h := Chain(withTraceID, withLogging)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Context().Value(traceIDKey)
+	}))
+	req := httptest.NewRequest("GET", "/orders", nil)
+	req.Header.Set("X-Trace-ID", "abc123")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if seen != "abc123" {
+		t.Errorf("handler saw trace ID %v, want abc123", seen)
+	}
+	// The logger sits inside the trace middleware, so it logs the same ID
+	if !strings.Contains(logs.String(), "GET /orders trace=abc123") {
+		t.Errorf("log = %q, want the request with its trace ID", logs.String())
+	}
+}
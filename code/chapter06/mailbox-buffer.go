@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"time"
+
+	"github.com/spaolacci/murmur3"
 )
 
 type Message struct {
@@ -11,48 +15,220 @@ type Message struct {
 	Data string
 }
 
-func mailbox(ctx context.Context, in <-chan Message, flush func([]Message)) {
-	const maxBatch = 3
+// RetryPolicy controls what happens when flush fails: the batch is retried
+// up to MaxRetries times, then handed to DeadLetter so it isn't silently lost
+type RetryPolicy struct {
+	MaxRetries int
+	DeadLetter func([]Message)
+}
+
+// RecentIDs remembers message IDs seen within a window, in two Bloom filter
+// generations: IDs go into the current one and are looked up in both, and every
+// window the previous generation is dropped and the current one takes its place.
+// An ID is remembered for between one and two windows, in bounded memory.
+// A false positive drops a message that was never seen, so size expectedIDs for
+// the IDs arriving in one window.
+type RecentIDs struct {
+	current, previous *BloomFilter
+	expectedIDs       int
+	window            time.Duration
+}
+
+func NewRecentIDs(expectedIDs int, window time.Duration) *RecentIDs {
+	return &RecentIDs{
+		current:     NewBloomFilter(expectedIDs, 0.001),
+		previous:    NewBloomFilter(expectedIDs, 0.001),
+		expectedIDs: expectedIDs,
+		window:      window,
+	}
+}
+
+// SeenOrAdd reports whether id was seen recently, remembering it if not
+func (r *RecentIDs) SeenOrAdd(id string) bool {
+	if r.current.Contains([]byte(id)) || r.previous.Contains([]byte(id)) {
+		return true
+	}
+	r.current.Add([]byte(id))
+	return false
+}
+
+// Rotate starts a new generation, forgetting IDs older than the last one
+func (r *RecentIDs) Rotate() {
+	r.previous = r.current
+	r.current = NewBloomFilter(r.expectedIDs, 0.001)
+}
+
+// FlushPolicy declares when the mailbox flushes a batch: once it holds MaxCount
+// messages, once its IDs and payloads add up to MaxBytes, or once its oldest
+// message has waited MaxAge. Whichever trips first wins, and the batch starts
+// over empty for all three. A zero field disables that trigger; with none set,
+// batches only go out on shutdown.
+type FlushPolicy struct {
+	MaxCount int
+	MaxBytes int
+	MaxAge   time.Duration
+}
+
+// full reports whether a batch of count messages totaling size bytes must flush
+func (p FlushPolicy) full(count, size int) bool {
+	return (p.MaxCount > 0 && count >= p.MaxCount) || (p.MaxBytes > 0 && size >= p.MaxBytes)
+}
+
+// mailbox batches messages from in and hands them to flush as policy dictates.
+// Batches are flushed in arrival order and every message goes out in exactly one
+// batch: all flushes run on this goroutine and go through flushPending, which takes
+// the batch before delivering it, so a timer and a shutdown can never flush the same messages.
+// With a non-nil dedup, messages whose ID was seen recently are dropped; it is
+// rotated every window on this goroutine too, so it needs no locking.
+func mailbox(ctx context.Context, in <-chan Message, flush func([]Message) error, policy FlushPolicy, retry RetryPolicy, dedup *RecentIDs) {
 	var batch []Message
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+	batchBytes := 0
+
+	// Runs from the first message of a batch; nil, never fires, otherwise
+	var age *time.Timer
+	var ageC <-chan time.Time
+
+	var rotate <-chan time.Time // nil, never fires, without dedup
+	if dedup != nil {
+		rotateTicker := time.NewTicker(dedup.window)
+		defer rotateTicker.Stop()
+		rotate = rotateTicker.C
+	}
+
+	accept := func(msg Message) {
+		if dedup != nil && dedup.SeenOrAdd(msg.ID) {
+			return // At-least-once delivery sent it again
+		}
+		batch = append(batch, msg)
+		batchBytes += len(msg.ID) + len(msg.Data)
+		if len(batch) == 1 && policy.MaxAge > 0 {
+			age = time.NewTimer(policy.MaxAge)
+			ageC = age.C
+		}
+	}
+
+	flushPending := func() {
+		if age != nil {
+			age.Stop()
+			age, ageC = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+		pending := batch
+		batch, batchBytes = nil, 0
+		deliver(pending, flush, retry)
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			if len(batch) > 0 {
-				flush(batch)
+			// Pick up messages already waiting to be sent, keeping their order
+			for drained := false; !drained; {
+				select {
+				case msg, ok := <-in:
+					if !ok {
+						drained = true
+						continue
+					}
+					accept(msg)
+				default:
+					drained = true
+				}
 			}
+			flushPending()
 			return
 		case msg := <-in:
-			batch = append(batch, msg)
-			if len(batch) >= maxBatch {
-				flush(batch)
-				batch = nil
-			}
-		case <-ticker.C:
-			if len(batch) > 0 {
-				flush(batch)
-				batch = nil
+			accept(msg)
+			if policy.full(len(batch), batchBytes) {
+				flushPending()
 			}
+		case <-ageC:
+			flushPending()
+		case <-rotate:
+			dedup.Rotate()
 		}
 	}
 }
 
+// deliver flushes a batch, retrying on error and dead-lettering it if every attempt fails
+func deliver(batch []Message, flush func([]Message) error, retry RetryPolicy) {
+	for attempt := 0; attempt <= retry.MaxRetries; attempt++ {
+		if err := flush(batch); err == nil {
+			return
+		}
+	}
+	if retry.DeadLetter != nil {
+		retry.DeadLetter(batch)
+	}
+}
+
+// BloomFilter is the smallest filter RecentIDs needs: Add and Contains, with
+// the i-th bit position taken from murmur3 seeded by i. The hashing is unkeyed,
+// so only feed it IDs your own producers assign.
+type BloomFilter struct {
+	bitset []uint64
+	size   uint
+	k      uint
+}
+
+func NewBloomFilter(expectedElements int, falsePositiveRate float64) *BloomFilter {
+	size := uint(math.Ceil(-float64(expectedElements) * math.Log(falsePositiveRate) / math.Pow(math.Log(2), 2)))
+	k := uint(math.Max(1, math.Round(float64(size)/float64(expectedElements)*math.Log(2))))
+	return &BloomFilter{
+		bitset: make([]uint64, (size+63)/64),
+		size:   size,
+		k:      k,
+	}
+}
+
+func (bf *BloomFilter) Add(data []byte) {
+	for i := uint(0); i < bf.k; i++ {
+		position := uint(murmur3.Sum64WithSeed(data, uint32(i)) % uint64(bf.size))
+		bf.bitset[position/64] |= 1 << (position % 64)
+	}
+}
+
+func (bf *BloomFilter) Contains(data []byte) bool {
+	for i := uint(0); i < bf.k; i++ {
+		position := uint(murmur3.Sum64WithSeed(data, uint32(i)) % uint64(bf.size))
+		if bf.bitset[position/64]&(1<<(position%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 7*time.Second)
 	defer cancel()
 
 	in := make(chan Message)
-	go mailbox(ctx, in, func(msgs []Message) {
+	failures := 0
+	go mailbox(ctx, in, func(msgs []Message) error {
+		// Simulate a flaky downstream that fails every other write
+		failures++
+		if failures%2 == 1 {
+			fmt.Println("Flush failed, retrying")
+			return errors.New("downstream unavailable")
+		}
 		fmt.Println("Flushing batch:")
 		for _, m := range msgs {
 			fmt.Printf(" - %s: %s\n", m.ID, m.Data)
 		}
-	})
+		return nil
+	}, FlushPolicy{MaxCount: 3, MaxBytes: 64, MaxAge: 2 * time.Second}, RetryPolicy{
+		MaxRetries: 2,
+		DeadLetter: func(msgs []Message) {
+			fmt.Printf("Dead-lettered %d messages\n", len(msgs))
+		},
+	}, NewRecentIDs(1000, 5*time.Second))
 
 	for i := 1; i <= 7; i++ {
 		in <- Message{ID: fmt.Sprintf("msg%d", i), Data: "payload"}
+		if i == 2 {
+			in <- Message{ID: "msg2", Data: "redelivered"} // Dropped as a duplicate
+		}
 		time.Sleep(800 * time.Millisecond)
 	}
 }
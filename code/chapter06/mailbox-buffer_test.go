@@ -0,0 +1,221 @@
+package main
+
+// Run with: go test mailbox-buffer.go mailbox-buffer_test.go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeliverRetriesThenSucceeds(t *testing.T) {
+	calls := 0
+	flush := func([]Message) error {
+		calls++
+		if calls <= 2 {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	}
+	deadLettered := false
+	deliver([]Message{{ID: "a"}}, flush, RetryPolicy{
+		MaxRetries: 2,
+		DeadLetter: func([]Message) { deadLettered = true },
+	})
+
+	if calls != 3 {
+		t.Errorf("flush called %d times, want 3", calls)
+	}
+	if deadLettered {
+		t.Error("batch was dead-lettered although the third attempt succeeded")
+	}
+}
+
+func TestMailboxDeadLettersFailedBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan Message)
+	dead := make(chan []Message, 1)
+	calls := 0
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		mailbox(ctx, in, func([]Message) error {
+			calls++
+			return errors.New("downstream unavailable")
+		}, FlushPolicy{MaxCount: 2}, RetryPolicy{
+			MaxRetries: 2,
+			DeadLetter: func(msgs []Message) { dead <- msgs },
+		}, nil)
+	}()
+
+	in <- Message{ID: "a", Data: "1"}
+	in <- Message{ID: "b", Data: "2"}
+
+	select {
+	case msgs := <-dead:
+		if len(msgs) != 2 || msgs[0].ID != "a" || msgs[1].ID != "b" {
+			t.Errorf("dead-lettered %v, want messages a and b", msgs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failed batch was never dead-lettered")
+	}
+
+	cancel()
+	<-done
+	if calls != 3 {
+		t.Errorf("flush called %d times, want 3 (1 try + 2 retries)", calls)
+	}
+}
+
+func TestMailboxShutdownAtTickKeepsOrder(t *testing.T) {
+	const maxAge = 10 * time.Millisecond
+	for run := 0; run < 30; run++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		in := make(chan Message)
+		var mu sync.Mutex
+		var flushed []string
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			mailbox(ctx, in, func(batch []Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				for _, m := range batch {
+					flushed = append(flushed, m.ID)
+				}
+				return nil
+			}, FlushPolicy{MaxAge: maxAge}, RetryPolicy{}, nil)
+		}()
+
+		// Send until just past the age timer of the first message, then cancel
+		// right as it fires, with messages still pending
+		sent := 0
+		start := time.Now()
+		for time.Since(start) < maxAge+time.Duration(run%5)*time.Millisecond/5 {
+			in <- Message{ID: fmt.Sprint(sent), Data: "x"}
+			sent++
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		<-done
+
+		if len(flushed) != sent {
+			t.Fatalf("run %d: flushed %d messages, sent %d", run, len(flushed), sent)
+		}
+		for i, id := range flushed {
+			if id != fmt.Sprint(i) {
+				t.Fatalf("run %d: position %d holds message %s; want each message once, in order", run, i, id)
+			}
+		}
+	}
+}
+
+func TestRecentIDsGenerations(t *testing.T) {
+	r := NewRecentIDs(100, time.Minute)
+	if r.SeenOrAdd("m1") {
+		t.Fatal("m1 seen before it was added")
+	}
+	if !r.SeenOrAdd("m1") {
+		t.Error("m1 not seen right after it was added")
+	}
+	r.Rotate()
+	if !r.SeenOrAdd("m1") {
+		t.Error("m1 forgotten after one rotation, want it kept in the previous generation")
+	}
+	r.Rotate()
+	if r.SeenOrAdd("m1") {
+		t.Error("m1 still seen after two rotations")
+	}
+}
+
+func TestMailboxDropsDuplicatesWithinWindow(t *testing.T) {
+	const window = 20 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan Message)
+	flushed := make(chan string, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mailbox(ctx, in, func(msgs []Message) error {
+			for _, m := range msgs {
+				flushed <- m.ID
+			}
+			return nil
+		}, FlushPolicy{MaxCount: 1}, RetryPolicy{}, NewRecentIDs(100, window))
+	}()
+
+	in <- Message{ID: "x", Data: "1"}
+	in <- Message{ID: "x", Data: "1"} // Redelivered within the window
+	in <- Message{ID: "y", Data: "2"}
+	time.Sleep(5 * window) // At least two rotations, so x is forgotten
+	in <- Message{ID: "x", Data: "1"}
+	cancel()
+	<-done
+	close(flushed)
+
+	var got []string
+	for id := range flushed {
+		got = append(got, id)
+	}
+	if fmt.Sprint(got) != "[x y x]" {
+		t.Errorf("flushed %v, want [x y x]: the duplicate dropped, the late resend processed", got)
+	}
+}
+
+func TestFlushPolicyTriggers(t *testing.T) {
+	// Five messages of 5 bytes each (1-byte ID, 4-byte payload), sent back to back
+	tests := []struct {
+		name        string
+		policy      FlushPolicy
+		wantBatches string // Batch sizes, the last one flushed on shutdown if not before
+		wantBefore  int    // Batches flushed before shutdown
+	}{
+		{"count", FlushPolicy{MaxCount: 2}, "[2 2 1]", 2},
+		{"bytes", FlushPolicy{MaxBytes: 12}, "[3 2]", 1},
+		{"age", FlushPolicy{MaxAge: 20 * time.Millisecond}, "[5]", 1},
+		{"count before bytes", FlushPolicy{MaxCount: 2, MaxBytes: 100}, "[2 2 1]", 2},
+		{"bytes before count", FlushPolicy{MaxCount: 4, MaxBytes: 10}, "[2 2 1]", 2},
+		{"count then age", FlushPolicy{MaxCount: 3, MaxAge: 20 * time.Millisecond}, "[3 2]", 2},
+		{"none", FlushPolicy{}, "[5]", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			in := make(chan Message)
+			var mu sync.Mutex
+			var sizes []int
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				mailbox(ctx, in, func(batch []Message) error {
+					mu.Lock()
+					defer mu.Unlock()
+					sizes = append(sizes, len(batch))
+					return nil
+				}, tt.policy, RetryPolicy{}, nil)
+			}()
+
+			for _, id := range []string{"a", "b", "c", "d", "e"} {
+				in <- Message{ID: id, Data: "data"}
+			}
+			time.Sleep(60 * time.Millisecond) // Long enough for any age trigger
+			mu.Lock()
+			before := len(sizes)
+			mu.Unlock()
+			cancel()
+			<-done
+
+			if before != tt.wantBefore {
+				t.Errorf("%d batches flushed before shutdown, want %d", before, tt.wantBefore)
+			}
+			if got := fmt.Sprint(sizes); got != tt.wantBatches {
+				t.Errorf("batch sizes %s, want %s", got, tt.wantBatches)
+			}
+		})
+	}
+}
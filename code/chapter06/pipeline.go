@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -37,9 +38,44 @@ func stage3(in <-chan int) <-chan string {
 	return out
 }
 
+// GenerateBuffered sends items in order on a channel buffered to lookahead, so the
+// producer can run up to lookahead items ahead of a slow consumer. The channel is
+// closed once every item is sent, or early if ctx is canceled.
+func GenerateBuffered[T any](ctx context.Context, items []T, lookahead int) <-chan T {
+	out := make(chan T, lookahead)
+	go func() {
+		defer close(out)
+		for _, item := range items {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- item:
+			}
+		}
+	}()
+	return out
+}
+
+// Collect drains ch into a slice until it's closed. If ctx is canceled first,
+// it returns what it has collected so far.
+func Collect[T any](ctx context.Context, ch <-chan T) []T {
+	var results []T
+	for {
+		select {
+		case <-ctx.Done():
+			return results
+		case v, ok := <-ch:
+			if !ok {
+				return results
+			}
+			results = append(results, v)
+		}
+	}
+}
+
 func main() {
 	c := stage3(stage2(stage1())) // the pipeline
-	for msg := range c {
+	for _, msg := range Collect(context.Background(), c) {
 		fmt.Println(msg)
 	}
 }
@@ -0,0 +1,98 @@
+package main
+
+// Run with: go test pipeline.go pipeline_test.go
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCollectClosedChannel(t *testing.T) {
+	ch := make(chan int, 5)
+	for i := 1; i <= 5; i++ {
+		ch <- i
+	}
+	close(ch)
+
+	got := Collect(context.Background(), ch)
+	if len(got) != 5 {
+		t.Fatalf("collected %v, want 1..5", got)
+	}
+	for i, v := range got {
+		if v != i+1 {
+			t.Fatalf("collected %v, want 1..5", got)
+		}
+	}
+}
+
+func TestCollectCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan int) // Never closed
+
+	go func() {
+		ch <- 1
+		ch <- 2
+		cancel() // Both values were taken, so the partial result holds them
+	}()
+
+	got := Collect(ctx, ch)
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("collected %v, want [1 2]", got)
+	}
+}
+
+func TestGenerateBufferedOrder(t *testing.T) {
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+	got := Collect(context.Background(), GenerateBuffered(context.Background(), items, 8))
+	if len(got) != len(items) {
+		t.Fatalf("got %d items, want %d", len(got), len(items))
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("item %d = %d, out of order", i, v)
+		}
+	}
+}
+
+func TestGenerateBufferedRunsAhead(t *testing.T) {
+	ch := GenerateBuffered(context.Background(), []int{1, 2, 3, 4, 5, 6}, 4)
+
+	// Before the slow consumer reads anything, the producer should fill the lookahead
+	deadline := time.Now().Add(time.Second)
+	for len(ch) < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if len(ch) != 4 {
+		t.Fatalf("%d items queued ahead of the consumer, want 4", len(ch))
+	}
+	for want := 1; want <= 6; want++ {
+		time.Sleep(5 * time.Millisecond)
+		if v := <-ch; v != want {
+			t.Fatalf("got %d, want %d", v, want)
+		}
+	}
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after every item was sent")
+	}
+}
+
+func TestGenerateBufferedCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := GenerateBuffered(ctx, make([]int, 1000), 2)
+	<-ch
+	cancel()
+
+	// Drain until closed. Once canceled, each send races ctx.Done() in the select,
+	// so a few more items may get through, but never the rest of the 1000
+	n := 0
+	for range ch {
+		n++
+	}
+	if n > 50 {
+		t.Errorf("received %d more items after cancel, the producer ignored it", n)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"sync/atomic"
+	"time"
 )
 
 type Request struct {
@@ -18,6 +20,76 @@ func responder(reqs <-chan Request) {
 	}
 }
 
+// Responder answers requests like responder, but runs at most MaxConcurrency
+// handlers at once. Requests beyond that wait their turn, and Submit blocks once
+// the queue is full too, pushing back on callers. InFlight and Queued show how
+// saturated it is.
+type Responder struct {
+	MaxConcurrency int
+	reqs           chan Request
+	sem            chan struct{}
+	handle         func(payload string) string
+	inFlight       atomic.Int64
+	queued         atomic.Int64
+}
+
+// NewResponder starts a responder running handle on up to maxConcurrency requests
+// at once, with room for queueSize more to wait. A maxConcurrency below 1 is
+// treated as 1, since with no slots no request would ever be handled.
+func NewResponder(maxConcurrency, queueSize int, handle func(payload string) string) *Responder {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	r := &Responder{
+		MaxConcurrency: maxConcurrency,
+		reqs:           make(chan Request, queueSize),
+		sem:            make(chan struct{}, maxConcurrency),
+		handle:         handle,
+	}
+	go r.run()
+	return r
+}
+
+func (r *Responder) run() {
+	for req := range r.reqs {
+		r.sem <- struct{}{} // Wait for a free slot
+		r.queued.Add(-1)
+		r.inFlight.Add(1)
+		go func(req Request) {
+			result := r.handle(req.Payload)
+
+			// Free the slot before replying, so a slow reader doesn't hold it
+			r.inFlight.Add(-1)
+			<-r.sem
+			req.ReplyTo <- result
+		}(req)
+	}
+}
+
+// Submit queues a request, blocking while the queue is full
+func (r *Responder) Submit(req Request) {
+	r.queued.Add(1)
+	r.reqs <- req
+}
+
+// InFlight returns the number of requests being handled
+func (r *Responder) InFlight() int {
+	return int(r.inFlight.Load())
+}
+
+// Queued returns the number of submitted requests not yet started
+func (r *Responder) Queued() int {
+	return int(r.queued.Load())
+}
+
+// Close stops accepting requests; those already queued are still handled
+func (r *Responder) Close() {
+	close(r.reqs)
+}
+
 func main() {
 	reqs := make(chan Request)
 	go responder(reqs)
@@ -26,4 +98,23 @@ func main() {
 	reqs <- Request{Payload: "data", ReplyTo: reply}
 
 	fmt.Println("Response:", <-reply)
+
+	// A bounded responder under load
+	bounded := NewResponder(2, 10, func(payload string) string {
+		time.Sleep(100 * time.Millisecond)
+		return "Processed: " + payload
+	})
+	defer bounded.Close()
+
+	replies := make(chan string, 6)
+	for i := 1; i <= 6; i++ {
+		bounded.Submit(Request{Payload: fmt.Sprintf("job%d", i), ReplyTo: replies})
+	}
+	time.Sleep(10 * time.Millisecond)
+	fmt.Printf("In flight: %d, queued: %d\n", bounded.InFlight(), bounded.Queued())
+
+	for i := 0; i < 6; i++ {
+		fmt.Println("Response:", <-replies)
+	}
+	fmt.Printf("In flight: %d, queued: %d\n", bounded.InFlight(), bounded.Queued())
 }
@@ -0,0 +1,85 @@
+package main
+
+// Run with: go test request-reply.go request-reply_test.go
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func TestResponderSaturation(t *testing.T) {
+	const bound, requests = 2, 7
+	release := make(chan struct{})
+	var mu sync.Mutex
+	running, peak := 0, 0
+	r := NewResponder(bound, 10, func(payload string) string {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return "done " + payload
+	})
+	defer r.Close()
+
+	replies := make(chan string, requests)
+	for i := 0; i < requests; i++ {
+		r.Submit(Request{Payload: fmt.Sprint(i), ReplyTo: replies})
+	}
+
+	// The bound is reached and the rest wait
+	if !waitFor(func() bool { return r.InFlight() == bound && r.Queued() == requests-bound }) {
+		t.Fatalf("in flight %d, queued %d; want %d and %d", r.InFlight(), r.Queued(), bound, requests-bound)
+	}
+
+	close(release)
+	for i := 0; i < requests; i++ {
+		<-replies
+	}
+	if !waitFor(func() bool { return r.InFlight() == 0 && r.Queued() == 0 }) {
+		t.Errorf("after draining: in flight %d, queued %d; want 0 and 0", r.InFlight(), r.Queued())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if peak > bound {
+		t.Errorf("%d handlers ran at once, bound is %d", peak, bound)
+	}
+}
+
+func TestResponderZeroConcurrency(t *testing.T) {
+	r := NewResponder(0, 0, func(payload string) string { return payload })
+	defer r.Close()
+	if r.MaxConcurrency != 1 {
+		t.Errorf("MaxConcurrency = %d, want 0 raised to 1", r.MaxConcurrency)
+	}
+
+	replies := make(chan string, 1)
+	r.Submit(Request{Payload: "ping", ReplyTo: replies})
+	select {
+	case got := <-replies:
+		if got != "ping" {
+			t.Errorf("reply = %q, want ping", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply with maxConcurrency 0; the request was never handled")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -9,17 +10,30 @@ import (
 	"time"
 )
 
+// Cancellation causes, so canceled tasks can say *why* they stopped
+var (
+	errBatchTimeout = errors.New("batch timeout: tasks took longer than 500ms")
+	errShutdown     = errors.New("shutdown requested")
+)
+
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
 	tasks := []string{"task1", "task2", "task3", "task4", "task5"}
 
 	// Set a timeout shorter than total work time to trigger cancellation
-	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 500*time.Millisecond, errBatchTimeout)
 	defer cancel() // Important to release resources
 
 	fmt.Println("Running tasks with context cancellation...")
 	runWithContext(ctx, tasks)
+
+	// Cancel explicitly, recording the reason
+	shutdownCtx, shutdown := context.WithCancelCause(context.Background())
+	shutdown(errShutdown)
+
+	fmt.Println("Running tasks after shutdown...")
+	runWithContext(shutdownCtx, tasks)
 	fmt.Println("All done.")
 }
 
@@ -40,7 +54,9 @@ func runWithContext(ctx context.Context, tasks []string) {
 			defer wg.Done()
 			select {
 			case <-ctx.Done():
-				log.Printf("⚠️ Task %s canceled: %v\n", task, ctx.Err())
+				// ctx.Err() only says "deadline exceeded" or "canceled";
+				// the cause carries the reason set by whoever canceled
+				log.Printf("⚠️ Task %s canceled: %v\n", task, context.Cause(ctx))
 			default:
 				process(task)
 			}
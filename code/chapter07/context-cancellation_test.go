@@ -0,0 +1,47 @@
+package main
+
+// Run with: go test context-cancellation.go context-cancellation_test.go
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureLog runs fn with the standard logger writing into a buffer
+func captureLog(t *testing.T, fn func()) string {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	fn()
+	return buf.String()
+}
+
+func TestRunWithContextLogsCause(t *testing.T) {
+	shutdownCtx, shutdown := context.WithCancelCause(context.Background())
+	shutdown(errShutdown)
+
+	// A deadline that has already passed cancels immediately with its cause
+	timeoutCtx, cancel := context.WithTimeoutCause(context.Background(), 0, errBatchTimeout)
+	defer cancel()
+
+	for _, tc := range []struct {
+		ctx   context.Context
+		cause error
+	}{
+		{shutdownCtx, errShutdown},
+		{timeoutCtx, errBatchTimeout},
+	} {
+		out := captureLog(t, func() { runWithContext(tc.ctx, []string{"task1", "task2"}) })
+		if got := strings.Count(out, tc.cause.Error()); got != 2 {
+			t.Errorf("cause %q logged %d times, want 2; log:\n%s", tc.cause, got, out)
+		}
+		if strings.Contains(out, tc.ctx.Err().Error()) {
+			t.Errorf("log has the generic %q instead of the cause:\n%s", tc.ctx.Err(), out)
+		}
+	}
+}
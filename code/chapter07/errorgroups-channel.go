@@ -18,6 +18,14 @@ func main() {
 	} else {
 		fmt.Println("All tasks completed successfully.")
 	}
+
+	fmt.Println("\nRunning tasks with progress reporting...")
+	err := runWithErrorsProgress(tasks, func(completed, total int) {
+		fmt.Printf("Progress: %d/%d\n", completed, total)
+	})
+	if err != nil {
+		fmt.Printf("Finished with error: %v\n", err)
+	}
 }
 
 // Simulated task processor with random failure
@@ -59,3 +67,41 @@ func runWithErrors(tasks []string) error {
 	}
 	return nil
 }
+
+// Same pattern, plus a progress callback invoked once per finished task.
+// The callback runs under a mutex, so completed counts arrive in order
+// and the callback itself doesn't need to be thread-safe.
+func runWithErrorsProgress(tasks []string, progress func(completed, total int)) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	completed := 0
+	errCh := make(chan error, len(tasks)) // Buffered to avoid blocking
+
+	for _, task := range tasks {
+		task := task // Capture range variable
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := processTask(task); err != nil {
+				errCh <- err
+			}
+
+			// Failed tasks still count as finished
+			mu.Lock()
+			completed++
+			if progress != nil {
+				progress(completed, len(tasks))
+			}
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	// Return the first error encountered, if any
+	for err := range errCh {
+		return err
+	}
+	return nil
+}
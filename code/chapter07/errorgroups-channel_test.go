@@ -0,0 +1,37 @@
+package main
+
+// Run with: go test errorgroups-channel.go errorgroups-channel_test.go
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRunWithErrorsProgress(t *testing.T) {
+	// processTask fails 30% of the time, so with 40 tasks some fail
+	// (all succeeding has odds below one in a million)
+	tasks := make([]string, 40)
+	for i := range tasks {
+		tasks[i] = fmt.Sprintf("task%d", i)
+	}
+
+	var calls []int
+	err := runWithErrorsProgress(tasks, func(completed, total int) {
+		if total != len(tasks) {
+			t.Errorf("total = %d, want %d", total, len(tasks))
+		}
+		calls = append(calls, completed) // Safe: progress runs under the mutex
+	})
+	if err == nil {
+		t.Fatal("expected some tasks to fail")
+	}
+
+	if len(calls) != len(tasks) {
+		t.Fatalf("progress called %d times, want %d", len(calls), len(tasks))
+	}
+	for i, completed := range calls {
+		if completed != i+1 {
+			t.Fatalf("call %d reported %d completed, want %d", i, completed, i+1)
+		}
+	}
+}
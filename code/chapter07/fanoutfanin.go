@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"runtime/debug"
 	"sync"
 	"time"
 )
@@ -51,6 +53,253 @@ func fanOutFanIn(jobs []int, workerCount int) []int {
 	return results
 }
 
+// StreamPool is fanOutFanIn for jobs that arrive over time: Submit jobs as
+// they come, Close when there are no more, then Wait for the results.
+type StreamPool[T, R any] struct {
+	jobCh   chan T
+	results []R
+	done    chan struct{}
+}
+
+// NewStreamPool starts workerCount workers applying fn to submitted jobs.
+// Fewer than one worker is treated as one, or Submit would block forever.
+func NewStreamPool[T, R any](workerCount int, fn func(T) R) *StreamPool[T, R] {
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	p := &StreamPool[T, R]{
+		jobCh: make(chan T),
+		done:  make(chan struct{}),
+	}
+	resultCh := make(chan R)
+	var wg sync.WaitGroup
+
+	// Fan-Out: Start workers
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range p.jobCh {
+				resultCh <- fn(job)
+			}
+		}()
+	}
+
+	// Close the result channel once all workers are done
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	// Fan-In: collect results until the workers are done
+	go func() {
+		for res := range resultCh {
+			p.results = append(p.results, res)
+		}
+		close(p.done)
+	}()
+
+	return p
+}
+
+// Submit hands a job to the next free worker, blocking until one takes it
+func (p *StreamPool[T, R]) Submit(job T) {
+	p.jobCh <- job
+}
+
+// Close signals that no more jobs are coming; Submit must not be called after it
+func (p *StreamPool[T, R]) Close() {
+	close(p.jobCh)
+}
+
+// Wait blocks until every submitted job has finished and returns their results.
+// Call it after Close, otherwise it waits forever.
+func (p *StreamPool[T, R]) Wait() []R {
+	<-p.done
+	return p.results
+}
+
+// PanicError reports a job whose function panicked, with the stack at the panic
+type PanicError struct {
+	Job   int
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("job %d panicked: %v", e.Job, e.Value)
+}
+
+// fanOutFanInRecover works like fanOutFanIn with a caller supplied job function,
+// but a panicking job is reported as a *PanicError instead of crashing the program.
+// Use it when one bad input shouldn't cost the rest of the batch.
+// Fewer than one worker is treated as one, so every job still runs.
+func fanOutFanInRecover(jobs []int, workerCount int, fn func(int) int) ([]int, []error) {
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	type outcome struct {
+		result int
+		err    error
+	}
+
+	jobCh := make(chan int)
+	resultCh := make(chan outcome)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				result, err := safeRun(job, fn)
+				resultCh <- outcome{result, err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, job := range jobs {
+			jobCh <- job
+		}
+		close(jobCh)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	var results []int
+	var errs []error
+	for o := range resultCh {
+		if o.err != nil {
+			errs = append(errs, o.err)
+			continue
+		}
+		results = append(results, o.result)
+	}
+
+	return results, errs
+}
+
+// safeRun calls fn for a single job, recovering from a panic inside it
+func safeRun(job int, fn func(int) int) (result int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Job: job, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return fn(job), nil
+}
+
+// FailedJob is a job that failed every attempt, with the last error it returned
+type FailedJob[T any] struct {
+	Job      T
+	Err      error
+	Attempts int
+}
+
+// retryBaseDelay is the first backoff delay, doubled after every failed attempt
+const retryBaseDelay = 10 * time.Millisecond
+
+// FanOutFanInRetry runs jobs on a pool of workers, retrying each failing job up
+// to maxAttempts times with exponential backoff. Successful results come back in
+// completion order; jobs that never succeeded are returned separately as failures.
+// If ctx is canceled, jobs that hadn't started yet fail with ctx's error and 0 attempts.
+// Fewer than one worker is treated as one; otherwise the feeder would wait forever.
+func FanOutFanInRetry[T, R any](ctx context.Context, jobs []T, workers, maxAttempts int, fn func(context.Context, T) (R, error)) ([]R, []FailedJob[T]) {
+	if workers < 1 {
+		workers = 1
+	}
+	type outcome struct {
+		result R
+		failed *FailedJob[T]
+	}
+
+	jobCh := make(chan T)
+	outCh := make(chan outcome)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				result, attempts, err := withRetry(ctx, job, maxAttempts, fn)
+				if err != nil {
+					outCh <- outcome{failed: &FailedJob[T]{Job: job, Err: err, Attempts: attempts}}
+					continue
+				}
+				outCh <- outcome{result: result}
+			}
+		}()
+	}
+
+	// The feeder counts in wg too, since once ctx is done it reports the jobs
+	// it never handed out as failures
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobCh)
+		for i, job := range jobs {
+			select {
+			case jobCh <- job:
+			case <-ctx.Done():
+				for _, job := range jobs[i:] {
+					outCh <- outcome{failed: &FailedJob[T]{Job: job, Err: ctx.Err()}}
+				}
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(outCh)
+	}()
+
+	var results []R
+	var failed []FailedJob[T]
+	for o := range outCh {
+		if o.failed != nil {
+			failed = append(failed, *o.failed)
+			continue
+		}
+		results = append(results, o.result)
+	}
+
+	return results, failed
+}
+
+// withRetry calls fn until it succeeds, maxAttempts is reached or ctx is done
+func withRetry[T, R any](ctx context.Context, job T, maxAttempts int, fn func(context.Context, T) (R, error)) (R, int, error) {
+	var result R
+	var err error
+	delay := retryBaseDelay
+	if maxAttempts < 1 {
+		maxAttempts = 1 // Always try at least once
+	}
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		result, err = fn(ctx, job)
+		if err == nil {
+			return result, attempt, nil
+		}
+		if attempt == maxAttempts {
+			return result, attempt, err
+		}
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return result, attempt, err
+		}
+	}
+	return result, maxAttempts, err
+}
+
 func main() {
 	jobs := make([]int, 20)
 	for i := 0; i < len(jobs); i++ {
@@ -0,0 +1,178 @@
+package main
+
+// Run with: go test fanoutfanin.go fanoutfanin_test.go
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFanOutFanInRecover(t *testing.T) {
+	results, errs := fanOutFanInRecover([]int{1, 2, 3, 4, 5}, 2, func(job int) int {
+		if job == 3 {
+			panic("bad input")
+		}
+		return job * 2
+	})
+
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+	var pe *PanicError
+	if !errors.As(errs[0], &pe) || pe.Job != 3 || len(pe.Stack) == 0 {
+		t.Fatalf("error = %v, want a PanicError with a stack for job 3", errs[0])
+	}
+
+	sort.Ints(results)
+	want := []int{2, 4, 8, 10}
+	if len(results) != len(want) {
+		t.Fatalf("results = %v, want %v", results, want)
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Fatalf("results = %v, want %v", results, want)
+		}
+	}
+}
+
+// within runs fn and fails the test if it hasn't returned after d
+func within(t *testing.T, d time.Duration, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("still running after %v", d)
+	}
+}
+
+func TestFanOutFanInRecoverZeroWorkers(t *testing.T) {
+	var results []int
+	within(t, time.Second, func() {
+		results, _ = fanOutFanInRecover([]int{1, 2, 3}, 0, func(job int) int { return job })
+	})
+	if len(results) != 3 {
+		t.Errorf("results = %v with 0 workers, want all 3 jobs run", results)
+	}
+}
+
+func TestFanOutFanInRetryPartitions(t *testing.T) {
+	errDown := errors.New("service down")
+	var mu sync.Mutex
+	attempts := map[string]int{}
+
+	results, failed := FanOutFanInRetry(context.Background(), []string{"flaky", "broken", "fine"}, 2, 3,
+		func(_ context.Context, job string) (string, error) {
+			mu.Lock()
+			attempts[job]++
+			n := attempts[job]
+			mu.Unlock()
+			switch {
+			case job == "broken", job == "flaky" && n < 2:
+				return "", errDown
+			}
+			return job + " ok", nil
+		})
+
+	sort.Strings(results)
+	if len(results) != 2 || results[0] != "fine ok" || results[1] != "flaky ok" {
+		t.Errorf("results = %v, want fine and flaky", results)
+	}
+	if len(failed) != 1 {
+		t.Fatalf("failed = %v, want only broken", failed)
+	}
+	if f := failed[0]; f.Job != "broken" || !errors.Is(f.Err, errDown) || f.Attempts != 3 {
+		t.Errorf("failure = %+v, want broken after 3 attempts with the last error", f)
+	}
+}
+
+func TestFanOutFanInRetryZeroWorkers(t *testing.T) {
+	var results []int
+	var failed []FailedJob[int]
+	within(t, time.Second, func() {
+		results, failed = FanOutFanInRetry(context.Background(), []int{1, 2}, 0, 1,
+			func(_ context.Context, job int) (int, error) { return job, nil })
+	})
+	if len(results) != 2 || len(failed) != 0 {
+		t.Errorf("results = %v, failed = %v with 0 workers, want both jobs done", results, failed)
+	}
+}
+
+func TestFanOutFanInRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+
+	// The single worker is stuck in job 1 when ctx is canceled, so jobs 2 and 3 never start
+	go func() {
+		<-started
+		cancel()
+	}()
+	results, failed := FanOutFanInRetry(ctx, []int{1, 2, 3}, 1, 1, func(ctx context.Context, job int) (int, error) {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond) // Keep the worker busy while the feeder sees the cancellation
+		return 0, ctx.Err()
+	})
+
+	if len(results) != 0 {
+		t.Errorf("results = %v, want none", results)
+	}
+	if len(failed) != 3 {
+		t.Fatalf("got %d failures, want all 3 jobs: %+v", len(failed), failed)
+	}
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Job < failed[j].Job })
+	for _, f := range failed {
+		if !errors.Is(f.Err, context.Canceled) {
+			t.Errorf("job %d err = %v, want context.Canceled", f.Job, f.Err)
+		}
+	}
+	if failed[0].Attempts != 1 || failed[1].Attempts != 0 || failed[2].Attempts != 0 {
+		t.Errorf("attempts = %d, %d, %d; want 1, 0, 0", failed[0].Attempts, failed[1].Attempts, failed[2].Attempts)
+	}
+}
+
+func TestStreamPoolCollectsEachResultOnce(t *testing.T) {
+	p := NewStreamPool(3, func(n int) int { return n * 10 })
+
+	// Jobs trickle in over time instead of arriving as one slice
+	for i := 1; i <= 50; i++ {
+		p.Submit(i)
+		if i%10 == 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	p.Close()
+
+	results := p.Wait()
+	if len(results) != 50 {
+		t.Fatalf("got %d results, want 50", len(results))
+	}
+	sort.Ints(results)
+	for i, r := range results {
+		if r != (i+1)*10 {
+			t.Fatalf("results = %v, want each of 10..500 exactly once", results)
+		}
+	}
+}
+
+func TestStreamPoolZeroWorkers(t *testing.T) {
+	var results []int
+	within(t, time.Second, func() {
+		p := NewStreamPool(0, func(n int) int { return n })
+		p.Submit(1)
+		p.Submit(2)
+		p.Close()
+		results = p.Wait()
+	})
+	if len(results) != 2 {
+		t.Errorf("results = %v with 0 workers, want both jobs run", results)
+	}
+}
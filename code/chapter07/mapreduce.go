@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"sync"
 )
@@ -32,6 +33,63 @@ func mapReduce(inputs []int, mapper func(int) int, reducer func([]int) int) int
 	return reducer(mapped)
 }
 
+// KeyVal is a single keyed value flowing into ReduceByKey
+type KeyVal[K comparable, V any] struct {
+	Key K
+	Val V
+}
+
+// ReduceByKey aggregates a stream of keyed values in parallel. Each worker
+// folds values into its own partial map, so the hot path takes no locks;
+// the partial maps are merged with combine once the input is drained
+// (or ctx is canceled, in which case the result covers what was consumed).
+// Fewer than one worker is treated as one, so the input is always drained.
+func ReduceByKey[K comparable, V any](ctx context.Context, in <-chan KeyVal[K, V], workers int, combine func(V, V) V) map[K]V {
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	partials := make([]map[K]V, workers)
+
+	for i := 0; i < workers; i++ {
+		partials[i] = make(map[K]V)
+		wg.Add(1)
+		go func(acc map[K]V) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case kv, ok := <-in:
+					if !ok {
+						return
+					}
+					if cur, exists := acc[kv.Key]; exists {
+						acc[kv.Key] = combine(cur, kv.Val)
+					} else {
+						acc[kv.Key] = kv.Val
+					}
+				}
+			}
+		}(partials[i])
+	}
+
+	wg.Wait()
+
+	// Merge partial results
+	result := make(map[K]V)
+	for _, acc := range partials {
+		for k, v := range acc {
+			if cur, exists := result[k]; exists {
+				result[k] = combine(cur, v)
+			} else {
+				result[k] = v
+			}
+		}
+	}
+	return result
+}
+
 func square(n int) int { return n * n }
 
 func sum(nums []int) int {
@@ -46,4 +104,15 @@ func main() {
 	inputs := []int{1, 2, 3, 4, 5}
 	result := mapReduce(inputs, square, sum)
 	fmt.Println("Sum of squares:", result)
+
+	// Count hits per path across 4 workers
+	hits := make(chan KeyVal[string, int])
+	go func() {
+		for _, path := range []string{"/", "/login", "/", "/cart", "/", "/login"} {
+			hits <- KeyVal[string, int]{Key: path, Val: 1}
+		}
+		close(hits)
+	}()
+	counts := ReduceByKey(context.Background(), hits, 4, func(a, b int) int { return a + b })
+	fmt.Println("Hits per path:", counts)
 }
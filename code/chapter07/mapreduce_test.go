@@ -0,0 +1,51 @@
+package main
+
+// Run with: go test mapreduce.go mapreduce_test.go
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// keyedStream sends vals from several goroutines at once, each val keyed by its remainder mod 7
+func keyedStream(vals []int, senders int) <-chan KeyVal[string, int] {
+	ch := make(chan KeyVal[string, int])
+	var wg sync.WaitGroup
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			for i := s; i < len(vals); i += senders {
+				ch <- KeyVal[string, int]{Key: fmt.Sprint("k", vals[i]%7), Val: vals[i]}
+			}
+		}(s)
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+func TestReduceByKeyMatchesSerial(t *testing.T) {
+	vals := make([]int, 10000)
+	want := make(map[string]int)
+	for i := range vals {
+		vals[i] = i
+		want[fmt.Sprint("k", i%7)] += i
+	}
+
+	for _, workers := range []int{-1, 0, 1, 4} {
+		got := ReduceByKey(context.Background(), keyedStream(vals, 3), workers, func(a, b int) int { return a + b })
+		if len(got) != len(want) {
+			t.Fatalf("workers=%d: got %d keys, want %d", workers, len(got), len(want))
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("workers=%d: sum[%s] = %d, want %d", workers, k, got[k], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// raceResult carries one function's outcome back to Race
+type raceResult[T any] struct {
+	value T
+	err   error
+}
+
+// Race runs every fn concurrently and returns the first successful result,
+// canceling the context passed to the others. If all of them fail it returns
+// their errors joined; if ctx ends first it returns ctx's error.
+func Race[T any](ctx context.Context, fns ...func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if len(fns) == 0 {
+		return zero, errors.New("race: no functions to run")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Stop the losers once we return
+
+	results := make(chan raceResult[T], len(fns)) // Buffered so losers never block
+	for _, fn := range fns {
+		fn := fn
+		go func() {
+			value, err := fn(ctx)
+			results <- raceResult[T]{value, err}
+		}()
+	}
+
+	var errs []error
+	for range fns {
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case r := <-results:
+			if r.err == nil {
+				return r.value, nil
+			}
+			errs = append(errs, r.err)
+		}
+	}
+	return zero, errors.Join(errs...)
+}
+
+// backend simulates a replica that answers after delay, or fails if fail is set
+func backend(name string, delay time.Duration, fail bool) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		select {
+		case <-time.After(delay):
+			if fail {
+				return "", fmt.Errorf("%s: unavailable", name)
+			}
+			return "answer from " + name, nil
+		case <-ctx.Done():
+			fmt.Printf("%s canceled\n", name)
+			return "", ctx.Err()
+		}
+	}
+}
+
+func main() {
+	ctx := context.Background()
+
+	result, err := Race(ctx,
+		backend("primary", 300*time.Millisecond, false),
+		backend("replica-1", 50*time.Millisecond, true),
+		backend("replica-2", 100*time.Millisecond, false),
+	)
+	fmt.Println("Result:", result, "Error:", err)
+
+	_, err = Race(ctx,
+		backend("replica-1", 50*time.Millisecond, true),
+		backend("replica-2", 80*time.Millisecond, true),
+	)
+	fmt.Println("All failed:", err)
+
+	time.Sleep(50 * time.Millisecond) // Let the canceled backends report
+}
@@ -0,0 +1,72 @@
+package main
+
+// Run with: go test race.go race_test.go
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRaceFastestSuccessWins(t *testing.T) {
+	canceled := make(chan string, 2)
+	slow := func(name string) func(context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) {
+			select {
+			case <-time.After(5 * time.Second):
+				return name, nil
+			case <-ctx.Done():
+				canceled <- name
+				return "", ctx.Err()
+			}
+		}
+	}
+	fast := func(ctx context.Context) (string, error) { return "fast", nil }
+
+	start := time.Now()
+	got, err := Race(context.Background(), slow("slow1"), fast, slow("slow2"))
+	if err != nil || got != "fast" {
+		t.Fatalf("Race() = %q, %v; want fast", got, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Race took %v, it waited on the slow functions", elapsed)
+	}
+
+	// Both losers see their context canceled
+	for i := 0; i < 2; i++ {
+		select {
+		case <-canceled:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of 2 slow functions were canceled", i)
+		}
+	}
+}
+
+func TestRaceAllFail(t *testing.T) {
+	errA, errB := errors.New("a down"), errors.New("b down")
+	_, err := Race(context.Background(),
+		func(context.Context) (int, error) { return 0, errA },
+		func(context.Context) (int, error) { return 0, errB },
+	)
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("err = %v, want both failures joined", err)
+	}
+}
+
+func TestRaceContextEnds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := Race(ctx, func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond) // Report late, after Race has given up
+		return 0, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the context's deadline error", err)
+	}
+	if _, err := Race[int](context.Background()); err == nil || !strings.Contains(err.Error(), "no functions") {
+		t.Errorf("Race with no functions: err = %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+// Run with: go test safelazyinit.go safelazyinit_test.go
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestGetConfigContextRetriesAfterFailure(t *testing.T) {
+	config.Store(nil) // Start uninitialized, even on a repeated run with -count
+	ctx, cancel := context.WithCancelCause(context.Background())
+	errGaveUp := errors.New("caller gave up")
+	cancel(errGaveUp)
+
+	if cfg, err := GetConfigContext(ctx); !errors.Is(err, errGaveUp) || cfg != nil {
+		t.Fatalf("canceled init = %v, %v; want nil and the cancellation cause", cfg, err)
+	}
+	if config.Load() != nil {
+		t.Fatal("a failed initialization was cached")
+	}
+
+	cfg, err := GetConfigContext(context.Background())
+	if err != nil || cfg == nil || cfg.ConnectionString == "" {
+		t.Fatalf("retry = %+v, %v; want a usable config", cfg, err)
+	}
+	if GetConfig() != cfg {
+		t.Error("GetConfig returned a different config than the successful init")
+	}
+}
+
+func TestReloadConfigConcurrentReaders(t *testing.T) {
+	before := GetConfig()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cfg := GetConfig()
+				if cfg == nil || cfg.ConnectionString == "" || cfg.Timestamp.IsZero() {
+					t.Errorf("reader saw an incomplete config: %+v", cfg)
+					return
+				}
+			}
+		}()
+	}
+
+	after, err := ReloadConfig()
+	close(stop)
+	wg.Wait()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == before || !after.Timestamp.After(before.Timestamp) {
+		t.Error("ReloadConfig didn't swap in a new config")
+	}
+	if GetConfig() != after {
+		t.Error("GetConfig doesn't return the reloaded config")
+	}
+}
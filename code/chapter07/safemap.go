@@ -7,13 +7,15 @@ import (
 )
 
 type SafeMap struct {
-	mu sync.RWMutex
-	m  map[string]string
+	mu   sync.RWMutex
+	m    map[string]string
+	keys *KeyedMutex
 }
 
 func NewSafeMap() *SafeMap {
 	return &SafeMap{
-		m: make(map[string]string),
+		m:    make(map[string]string),
+		keys: NewKeyedMutex(),
 	}
 }
 
@@ -30,6 +32,68 @@ func (s *SafeMap) Set(key, value string) {
 	s.m[key] = value
 }
 
+// GetOrCompute returns the value for key, calling compute to fill it if missing.
+// Concurrent callers for the same key wait for a single compute instead of
+// duplicating the work, while other keys aren't held up.
+func (s *SafeMap) GetOrCompute(key string, compute func() string) string {
+	if val, ok := s.Get(key); ok {
+		return val
+	}
+
+	unlock := s.keys.Lock(key)
+	defer unlock()
+
+	// Someone may have filled it while we waited for the key lock
+	if val, ok := s.Get(key); ok {
+		return val
+	}
+	val := compute()
+	s.Set(key, val)
+	return val
+}
+
+// KeyedMutex hands out one mutex per key, so work on different keys runs
+// in parallel while work on the same key is serialized. Mutexes are
+// reference-counted and dropped once nobody holds or waits for them.
+type KeyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func NewKeyedMutex() *KeyedMutex {
+	return &KeyedMutex{locks: make(map[string]*keyedLock)}
+}
+
+// Lock blocks until key is free and returns the function that unlocks it
+func (km *KeyedMutex) Lock(key string) func() {
+	km.mu.Lock()
+	l, ok := km.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		km.locks[key] = l
+	}
+	l.refs++
+	km.mu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		km.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(km.locks, key)
+		}
+		km.mu.Unlock()
+	}
+}
+
 func main() {
 	sm := NewSafeMap()
 	var wg sync.WaitGroup
@@ -0,0 +1,83 @@
+package main
+
+// Run with: go test safemap.go safemap_test.go
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	km := NewKeyedMutex()
+	unlockA := km.Lock("a")
+
+	// A different key proceeds while "a" is held
+	otherDone := make(chan struct{})
+	go func() {
+		km.Lock("b")()
+		close(otherDone)
+	}()
+	select {
+	case <-otherDone:
+	case <-time.After(time.Second):
+		t.Fatal("locking b blocked while only a was held")
+	}
+
+	// The same key waits for the holder
+	sameAcquired := make(chan struct{})
+	go func() {
+		unlock := km.Lock("a")
+		close(sameAcquired)
+		unlock()
+	}()
+	select {
+	case <-sameAcquired:
+		t.Fatal("a was locked twice at once")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlockA()
+	select {
+	case <-sameAcquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiter never got a after it was unlocked")
+	}
+
+	// Give the waiter's unlock a moment, then every mutex should be released
+	deadline := time.Now().Add(time.Second)
+	for {
+		km.mu.Lock()
+		n := len(km.locks)
+		km.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d key mutexes left after all unlocks", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetOrComputeOnce(t *testing.T) {
+	sm := NewSafeMap()
+	calls := make(chan struct{}, 10)
+	done := make(chan string)
+	for i := 0; i < 10; i++ {
+		go func() {
+			done <- sm.GetOrCompute("k", func() string {
+				calls <- struct{}{}
+				time.Sleep(10 * time.Millisecond)
+				return "v"
+			})
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if v := <-done; v != "v" {
+			t.Errorf("GetOrCompute = %q, want v", v)
+		}
+	}
+	if len(calls) != 1 {
+		t.Errorf("compute ran %d times, want 1", len(calls))
+	}
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
 func runPool(jobs []string, workers int) {
@@ -30,8 +36,527 @@ func process(workerID int, job string) {
 	fmt.Printf("Worker %d processing: %s\n", workerID, job)
 }
 
+// PanicError reports a job whose function panicked, with the stack at the panic
+type PanicError struct {
+	Job   string
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("job %s panicked: %v", e.Job, e.Value)
+}
+
+// runPoolRecover is runPool for jobs that may panic: a panic is turned into a
+// *PanicError for that job and the worker moves on to the next one.
+// It's opt-in so plain runPool still crashes loudly on bugs during development.
+// Fewer than one worker is treated as one, so every job still runs.
+func runPoolRecover(jobs []string, workers int, fn func(workerID int, job string)) []error {
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	jobsCh := make(chan string)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for job := range jobsCh {
+				if err := safeProcess(id, job, fn); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}(i)
+	}
+
+	for _, job := range jobs {
+		jobsCh <- job
+	}
+	close(jobsCh)
+	wg.Wait()
+	return errs
+}
+
+// safeProcess runs a single job, recovering from a panic inside it
+func safeProcess(workerID int, job string, fn func(int, string)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Job: job, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	fn(workerID, job)
+	return nil
+}
+
+// Job is a unit of work with its own deadline, on top of the pool's context
+type Job struct {
+	Name     string
+	Deadline time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// runPoolContext runs jobs on a fixed number of workers. Each job gets a context
+// derived from poolCtx with its own timeout, so it stops when either the job's
+// deadline passes or the whole pool is canceled. errs[i] holds the result of jobs[i].
+// Fewer than one worker is treated as one.
+func runPoolContext(poolCtx context.Context, jobs []Job, workers int) []error {
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(jobs)) // Each worker writes its own index
+	jobsCh := make(chan int)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobsCh {
+				errs[idx] = runJob(poolCtx, jobs[idx])
+			}
+		}()
+	}
+
+	for i := range jobs {
+		select {
+		case jobsCh <- i:
+		case <-poolCtx.Done():
+			errs[i] = context.Cause(poolCtx) // Never started
+		}
+	}
+	close(jobsCh)
+	wg.Wait()
+	return errs
+}
+
+// runJob runs a single job under its own deadline
+func runJob(poolCtx context.Context, job Job) error {
+	jobCtx, cancel := context.WithTimeout(poolCtx, job.Deadline)
+	defer cancel() // Always release the timer, even when the job finishes early
+	return job.Run(jobCtx)
+}
+
+// PartitionedPool routes every job to a worker picked by hashing its key,
+// so jobs sharing a key always land on the same worker and run in the order
+// they were submitted, while different keys are spread across workers.
+type PartitionedPool[T any] struct {
+	queues []chan T
+	key    func(T) string
+	wg     sync.WaitGroup
+}
+
+// NewPartitionedPool starts workers goroutines that run fn on their jobs.
+// Fewer than one worker is treated as one, which runs every key in order.
+func NewPartitionedPool[T any](workers int, key func(T) string, fn func(T)) *PartitionedPool[T] {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &PartitionedPool[T]{
+		queues: make([]chan T, workers),
+		key:    key,
+	}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan T, 16)
+		p.wg.Add(1)
+		go func(jobs <-chan T) {
+			defer p.wg.Done()
+			for job := range jobs {
+				fn(job)
+			}
+		}(p.queues[i])
+	}
+
+	return p
+}
+
+// Submit queues a job on the worker owning its key
+func (p *PartitionedPool[T]) Submit(job T) {
+	h := fnv.New32a()
+	h.Write([]byte(p.key(job)))
+	p.queues[h.Sum32()%uint32(len(p.queues))] <- job
+}
+
+// Close stops accepting jobs and waits for the queued ones to finish
+func (p *PartitionedPool[T]) Close() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+// WorkQueue decouples how jobs are ordered and bounded from the pool draining them
+type WorkQueue[T any] interface {
+	Put(item T) bool // false if the item was dropped or the queue is closed
+	Get() (T, bool)  // blocks until an item is available; false once closed and empty
+	Close()          // stop accepting items; queued ones can still be drained
+}
+
+// FullPolicy decides what Put does when a bounded queue is full
+type FullPolicy int
+
+const (
+	BlockOnFull FullPolicy = iota // wait for a worker to make room
+	DropOnFull                    // reject the new item immediately
+)
+
+// boundedQueue is a WorkQueue holding at most capacity items, served FIFO or LIFO.
+// A capacity below 1 is raised to 1: with no room at all, a BlockOnFull Put would wait forever.
+type boundedQueue[T any] struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []T
+	capacity int
+	lifo     bool
+	policy   FullPolicy
+	closed   bool
+}
+
+// NewFIFOQueue returns a queue serving items in insertion order
+func NewFIFOQueue[T any](capacity int, policy FullPolicy) WorkQueue[T] {
+	return newBoundedQueue[T](capacity, policy, false)
+}
+
+// NewLIFOQueue returns a queue serving the newest item first, e.g. for depth-first crawling
+func NewLIFOQueue[T any](capacity int, policy FullPolicy) WorkQueue[T] {
+	return newBoundedQueue[T](capacity, policy, true)
+}
+
+func newBoundedQueue[T any](capacity int, policy FullPolicy, lifo bool) *boundedQueue[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	q := &boundedQueue[T]{capacity: capacity, policy: policy, lifo: lifo}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *boundedQueue[T]) Put(item T) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) >= q.capacity && !q.closed {
+		if q.policy == DropOnFull {
+			return false
+		}
+		q.notFull.Wait()
+	}
+	if q.closed {
+		return false
+	}
+
+	q.items = append(q.items, item)
+	q.notEmpty.Signal()
+	return true
+}
+
+func (q *boundedQueue[T]) Get() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if len(q.items) == 0 {
+		var zero T
+		return zero, false
+	}
+
+	var item T
+	if q.lifo {
+		item = q.items[len(q.items)-1]
+		q.items = q.items[:len(q.items)-1]
+	} else {
+		item = q.items[0]
+		q.items = q.items[1:]
+	}
+	q.notFull.Signal()
+	return item, true
+}
+
+func (q *boundedQueue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+// runQueuePool starts workers that drain q until it's closed and empty.
+// The returned function waits for them to finish. Fewer than one worker is treated as one.
+func runQueuePool[T any](q WorkQueue[T], workers int, fn func(workerID int, item T)) func() {
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for {
+				item, ok := q.Get()
+				if !ok {
+					return
+				}
+				fn(id, item)
+			}
+		}(i)
+	}
+	return wg.Wait
+}
+
+// Pool is a long-lived worker pool fed through Submit. It can be paused for
+// maintenance: paused workers finish what they're running but don't pick up
+// new jobs, and queued jobs wait, untouched, until Resume.
+type Pool[T any] struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	queue     []T
+	paused    bool
+	closed    bool
+	inFlight  int
+	workers   int
+	completed int
+	busyTime  time.Duration // Sum of job durations, for the average
+	latencies latencyHistogram
+	wg        sync.WaitGroup
+}
+
+// latencyHistogram counts durations in powers-of-two buckets: bucket i holds
+// durations in [2^(i-1), 2^i) nanoseconds. It takes a fixed 65 counters however
+// many jobs run, and answers percentiles to within a factor of two.
+type latencyHistogram struct {
+	buckets [65]uint64
+	total   uint64
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.buckets[bits.Len64(uint64(d))]++
+	h.total++
+}
+
+// percentile returns the upper bound of the bucket holding the q-th quantile
+func (h *latencyHistogram) percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	q = min(max(q, 0), 1)
+
+	// Rank of the sample we want, counting from 1: the smallest rank covering
+	// a q share of the samples, so it is rounded up, never down
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	seen := uint64(0)
+	for i, count := range h.buckets {
+		seen += count
+		if seen >= rank {
+			if i == 0 {
+				return 0
+			}
+			if i >= 63 {
+				return time.Duration(1<<63 - 1)
+			}
+			return time.Duration(1) << i
+		}
+	}
+	return time.Duration(1<<63 - 1)
+}
+
+// PoolMetrics is a point-in-time snapshot of a Pool
+type PoolMetrics struct {
+	Queued      int
+	InFlight    int
+	Completed   int
+	AvgDuration time.Duration
+	Workers     int
+}
+
+// NewPool starts workers goroutines running fn on submitted jobs
+func NewPool[T any](workers int, fn func(T)) *Pool[T] {
+	p := &Pool[T]{workers: workers}
+	p.cond = sync.NewCond(&p.mu)
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for {
+				job, ok := p.next()
+				if !ok {
+					return
+				}
+				start := time.Now()
+				fn(job)
+				elapsed := time.Since(start)
+
+				p.mu.Lock()
+				p.inFlight--
+				p.completed++
+				p.busyTime += elapsed
+				p.latencies.record(elapsed)
+				p.cond.Broadcast()
+				p.mu.Unlock()
+			}
+		}()
+	}
+
+	return p
+}
+
+// next blocks until a job may run, returning false once the pool is closed and empty
+func (p *Pool[T]) next() (T, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The paused flag is checked before every fetch
+	for p.paused || (len(p.queue) == 0 && !p.closed) {
+		p.cond.Wait()
+	}
+	if len(p.queue) == 0 {
+		var zero T
+		return zero, false
+	}
+
+	job := p.queue[0]
+	p.queue = p.queue[1:]
+	p.inFlight++
+	return job, true
+}
+
+// Submit queues a job; it's ignored once the pool is closed
+func (p *Pool[T]) Submit(job T) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.queue = append(p.queue, job)
+	p.cond.Broadcast()
+}
+
+// Pause stops workers from starting new jobs; running jobs carry on
+func (p *Pool[T]) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+}
+
+// Resume lets workers pick up queued jobs again
+func (p *Pool[T]) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+	p.cond.Broadcast()
+}
+
+// Drain waits until no job is running. Call it after Pause to know the
+// pool is quiet; queued jobs stay queued.
+func (p *Pool[T]) Drain() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.inFlight > 0 {
+		p.cond.Wait()
+	}
+}
+
+// Metrics returns a consistent snapshot of the pool's counters
+func (p *Pool[T]) Metrics() PoolMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m := PoolMetrics{
+		Queued:    len(p.queue),
+		InFlight:  p.inFlight,
+		Completed: p.completed,
+		Workers:   p.workers,
+	}
+	if p.completed > 0 {
+		m.AvgDuration = p.busyTime / time.Duration(p.completed)
+	}
+	return m
+}
+
+// Percentile returns the approximate q-th quantile (0 to 1) of job durations,
+// such as 0.99 for p99. Durations are bucketed by powers of two, so the answer is
+// the bucket's upper bound: at most twice the true value, never below it.
+// It returns 0 before any job completes.
+func (p *Pool[T]) Percentile(q float64) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.latencies.percentile(q)
+}
+
+// Close stops accepting jobs, resumes a paused pool and waits for every queued job to finish
+func (p *Pool[T]) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.paused = false
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
 func main() {
 	jobs := []string{"job1", "job2", "job3", "job4", "job5"}
 	workers := 3
 	runPool(jobs, workers)
+
+	// A panicking job is reported without taking the pool down
+	errs := runPoolRecover(jobs, workers, func(id int, job string) {
+		if job == "job3" {
+			panic("bad input")
+		}
+		process(id, job)
+	})
+	for _, err := range errs {
+		fmt.Println("Failed:", err)
+	}
+
+	// Per-job deadlines under a longer pool deadline
+	poolCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	sleepJob := func(d time.Duration) func(context.Context) error {
+		return func(ctx context.Context) error {
+			select {
+			case <-time.After(d):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	ctxJobs := []Job{
+		{Name: "quick", Deadline: 500 * time.Millisecond, Run: sleepJob(100 * time.Millisecond)},
+		{Name: "slow", Deadline: 200 * time.Millisecond, Run: sleepJob(time.Second)},
+	}
+	for i, err := range runPoolContext(poolCtx, ctxJobs, workers) {
+		fmt.Printf("%s: %v\n", ctxJobs[i].Name, err)
+	}
+
+	// One job in twenty is slow: the average hides it, p99 doesn't
+	pool := NewPool(workers, func(d time.Duration) { time.Sleep(d) })
+	for i := 0; i < 100; i++ {
+		if i%20 == 0 {
+			pool.Submit(50 * time.Millisecond)
+		} else {
+			pool.Submit(time.Millisecond)
+		}
+	}
+	pool.Close()
+	fmt.Printf("avg: %v, p50: %v, p99: %v\n", pool.Metrics().AvgDuration, pool.Percentile(0.5), pool.Percentile(0.99))
 }
@@ -0,0 +1,391 @@
+package main
+
+// Run with: go test taskpool.go taskpool_test.go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunPoolRecover(t *testing.T) {
+	jobs := []string{"a", "b", "boom", "c", "d"}
+	var mu sync.Mutex
+	done := map[string]bool{}
+
+	errs := runPoolRecover(jobs, 2, func(_ int, job string) {
+		if job == "boom" {
+			panic("bad input")
+		}
+		mu.Lock()
+		done[job] = true
+		mu.Unlock()
+	})
+
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+	var pe *PanicError
+	if !errors.As(errs[0], &pe) || pe.Job != "boom" || pe.Value != "bad input" {
+		t.Fatalf("error = %v, want a PanicError for job boom", errs[0])
+	}
+	if len(pe.Stack) == 0 {
+		t.Error("PanicError has no stack")
+	}
+	for _, job := range []string{"a", "b", "c", "d"} {
+		if !done[job] {
+			t.Errorf("job %s did not complete", job)
+		}
+	}
+}
+
+// within fails the test if fn is still running after d, e.g. a pool waiting on workers it never started
+func within(t *testing.T, d time.Duration, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("still running after %v", d)
+	}
+}
+
+func TestRunPoolRecoverZeroWorkers(t *testing.T) {
+	var ran []string
+	within(t, time.Second, func() {
+		runPoolRecover([]string{"a", "b"}, 0, func(_ int, job string) { ran = append(ran, job) })
+	})
+	if len(ran) != 2 {
+		t.Errorf("ran %v with 0 workers, want both jobs on one worker", ran)
+	}
+}
+
+func TestRunPoolContextPerJobDeadline(t *testing.T) {
+	// sleepJob waits d unless its context ends first
+	sleepJob := func(name string, deadline, d time.Duration) Job {
+		return Job{Name: name, Deadline: deadline, Run: func(ctx context.Context) error {
+			select {
+			case <-time.After(d):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}}
+	}
+
+	poolCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	jobs := []Job{
+		sleepJob("slow", 20*time.Millisecond, time.Second),  // Outlives its own deadline
+		sleepJob("quick", time.Second, 10*time.Millisecond), // Finishes well within it
+	}
+
+	errs := runPoolContext(poolCtx, jobs, 2)
+	if !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Errorf("slow job err = %v, want its deadline exceeded", errs[0])
+	}
+	if errs[1] != nil {
+		t.Errorf("quick job err = %v, want nil", errs[1])
+	}
+	if poolCtx.Err() != nil {
+		t.Error("the pool context ended; only the slow job's context should have")
+	}
+}
+
+type keyedJob struct {
+	key string
+	seq int
+}
+
+func TestRunPoolContextZeroWorkers(t *testing.T) {
+	ok := Job{Name: "ok", Deadline: time.Second, Run: func(context.Context) error { return nil }}
+	var errs []error
+	within(t, time.Second, func() {
+		errs = runPoolContext(context.Background(), []Job{ok, ok}, 0)
+	})
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("job %d: %v", i, err)
+		}
+	}
+}
+
+func TestPartitionedPoolPerKeyOrder(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string][]int{}
+	p := NewPartitionedPool(4, func(j keyedJob) string { return j.key }, func(j keyedJob) {
+		mu.Lock()
+		seen[j.key] = append(seen[j.key], j.seq)
+		mu.Unlock()
+	})
+
+	keys := []string{"alice", "bob", "carol", "dave", "erin", "frank"}
+	for seq := 0; seq < 100; seq++ {
+		for _, k := range keys {
+			p.Submit(keyedJob{k, seq})
+		}
+	}
+	p.Close()
+
+	for _, k := range keys {
+		if len(seen[k]) != 100 {
+			t.Fatalf("%s: %d jobs ran, want 100", k, len(seen[k]))
+		}
+		for i, seq := range seen[k] {
+			if seq != i {
+				t.Fatalf("%s: job %d ran in position %d", k, seq, i)
+			}
+		}
+	}
+}
+
+func TestPartitionedPoolKeysRunConcurrently(t *testing.T) {
+	const workers = 4
+	worker := func(key string) uint32 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return h.Sum32() % workers
+	}
+	// Find a second key owned by a different worker than "a"
+	other := ""
+	for i := 0; other == ""; i++ {
+		if k := fmt.Sprint("k", i); worker(k) != worker("a") {
+			other = k
+		}
+	}
+
+	// The job for "a" waits for the other key's job, which only works if they run in parallel
+	otherRan := make(chan struct{})
+	timedOut := false
+	p := NewPartitionedPool(workers, func(k string) string { return k }, func(k string) {
+		if k == other {
+			close(otherRan)
+			return
+		}
+		select {
+		case <-otherRan:
+		case <-time.After(time.Second):
+			timedOut = true
+		}
+	})
+	p.Submit("a")
+	p.Submit(other)
+	p.Close()
+
+	if timedOut {
+		t.Error("a job blocked the job of a key owned by another worker")
+	}
+}
+
+func TestPartitionedPoolZeroWorkers(t *testing.T) {
+	var got []string
+	within(t, time.Second, func() {
+		p := NewPartitionedPool(0, func(s string) string { return s }, func(s string) { got = append(got, s) })
+		p.Submit("a")
+		p.Submit("b")
+		p.Close()
+	})
+	if fmt.Sprint(got) != "[a b]" {
+		t.Errorf("ran %v with 0 workers, want [a b] on one worker", got)
+	}
+}
+
+func TestWorkQueueOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		q    WorkQueue[int]
+		want []int
+	}{
+		{"FIFO", NewFIFOQueue[int](5, BlockOnFull), []int{1, 2, 3, 4, 5}},
+		{"LIFO", NewLIFOQueue[int](5, BlockOnFull), []int{5, 4, 3, 2, 1}},
+	} {
+		for i := 1; i <= 5; i++ {
+			tc.q.Put(i)
+		}
+		tc.q.Close()
+		var got []int
+		for v, ok := tc.q.Get(); ok; v, ok = tc.q.Get() {
+			got = append(got, v)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWorkQueueFullPolicies(t *testing.T) {
+	drop := NewFIFOQueue[int](2, DropOnFull)
+	if !drop.Put(1) || !drop.Put(2) {
+		t.Fatal("Put failed below capacity")
+	}
+	if drop.Put(3) {
+		t.Error("DropOnFull queue accepted an item while full")
+	}
+
+	block := NewFIFOQueue[int](1, BlockOnFull)
+	block.Put(1)
+	putDone := make(chan bool)
+	go func() { putDone <- block.Put(2) }()
+	select {
+	case <-putDone:
+		t.Fatal("BlockOnFull Put returned while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if v, _ := block.Get(); v != 1 {
+		t.Errorf("Get = %d, want 1", v)
+	}
+	select {
+	case ok := <-putDone:
+		if !ok {
+			t.Error("blocked Put was rejected once room was made")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked Put never completed after Get made room")
+	}
+
+	// Closing releases a blocked Put, which then reports the item was not queued
+	go func() { putDone <- block.Put(3) }()
+	time.Sleep(10 * time.Millisecond)
+	block.Close()
+	if <-putDone {
+		t.Error("Put succeeded on a closed queue")
+	}
+}
+
+func TestWorkQueueZeroCapacity(t *testing.T) {
+	for _, policy := range []FullPolicy{BlockOnFull, DropOnFull} {
+		q := NewFIFOQueue[int](0, policy)
+		within(t, time.Second, func() {
+			if !q.Put(1) {
+				t.Errorf("policy %d: Put on an empty zero-capacity queue was rejected", policy)
+			}
+		})
+		if v, ok := q.Get(); !ok || v != 1 {
+			t.Errorf("policy %d: Get = %d, %v, want 1, true", policy, v, ok)
+		}
+	}
+
+	// And a pool started with no workers still drains the queue
+	q := NewFIFOQueue[int](4, BlockOnFull)
+	var sum int
+	wait := runQueuePool(q, 0, func(_ int, item int) { sum += item })
+	q.Put(1)
+	q.Put(2)
+	q.Close()
+	within(t, time.Second, wait)
+	if sum != 3 {
+		t.Errorf("sum = %d with 0 workers, want 3", sum)
+	}
+}
+
+func TestPoolPauseResume(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	started := 0
+	p := NewPool(2, func(int) {
+		mu.Lock()
+		started++
+		mu.Unlock()
+		<-release
+	})
+
+	for i := 0; i < 10; i++ {
+		p.Submit(i)
+	}
+	// Wait for both workers to pick up a job, then pause
+	for p.Metrics().InFlight < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Pause()
+	close(release) // Let the running jobs finish
+	p.Drain()
+
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	pausedStarts := started
+	mu.Unlock()
+	if pausedStarts != 2 {
+		t.Errorf("%d jobs started, want only the 2 running before Pause", pausedStarts)
+	}
+	if m := p.Metrics(); m.Queued != 8 || m.InFlight != 0 || m.Completed != 2 {
+		t.Errorf("paused metrics = %+v, want 8 queued, 0 in flight, 2 completed", m)
+	}
+
+	p.Resume()
+	p.Close()
+	if m := p.Metrics(); m.Completed != 10 || m.Queued != 0 {
+		t.Errorf("after Resume and Close: %+v, want all 10 completed", m)
+	}
+}
+
+func TestPoolMetrics(t *testing.T) {
+	const jobs, jobTime = 12, 10 * time.Millisecond
+	p := NewPool(3, func(d time.Duration) { time.Sleep(d) })
+	if m := p.Metrics(); m.Workers != 3 || m.Completed != 0 || m.AvgDuration != 0 {
+		t.Errorf("fresh pool metrics = %+v", m)
+	}
+
+	for i := 0; i < jobs; i++ {
+		p.Submit(jobTime)
+	}
+	p.Close()
+
+	m := p.Metrics()
+	if m.Completed != jobs || m.Queued != 0 || m.InFlight != 0 {
+		t.Errorf("metrics = %+v, want %d completed and nothing pending", m, jobs)
+	}
+	if m.AvgDuration < jobTime || m.AvgDuration > 5*jobTime {
+		t.Errorf("average duration %v, want about %v", m.AvgDuration, jobTime)
+	}
+}
+
+func TestPercentileRankRoundsUp(t *testing.T) {
+	var h latencyHistogram
+	h.record(time.Microsecond)       // Bucket bound 1.024µs
+	h.record(100 * time.Millisecond) // Bucket bound ~134ms
+	slow := time.Duration(1) << 27
+
+	// The median of two samples is the first; anything past it needs the second
+	for _, c := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, 1024},
+		{0.5, 1024},
+		{0.75, slow},
+		{1, slow},
+	} {
+		if got := h.percentile(c.q); got != c.want {
+			t.Errorf("percentile(%v) = %v, want %v", c.q, got, c.want)
+		}
+	}
+}
+
+func TestPoolPercentileBimodal(t *testing.T) {
+	// 95 fast jobs and 5 that take 20ms: p50 is fast, p99 lands in the slow mode
+	p := NewPool(4, func(d time.Duration) { time.Sleep(d) })
+	for i := 0; i < 100; i++ {
+		if i%20 == 0 {
+			p.Submit(20 * time.Millisecond)
+		} else {
+			p.Submit(0)
+		}
+	}
+	p.Close()
+
+	if p50 := p.Percentile(0.5); p50 >= time.Millisecond {
+		t.Errorf("p50 = %v, want the fast mode, under 1ms", p50)
+	}
+	if p99 := p.Percentile(0.99); p99 < 20*time.Millisecond || p99 > 80*time.Millisecond {
+		t.Errorf("p99 = %v, want the slow mode's bucket, 20ms to 2x that", p99)
+	}
+}
@@ -3,12 +3,19 @@ package main
 import (
 	"container/list"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"math"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/spaolacci/murmur3"
 )
 
 // KVStore defines a simple key-value interface
@@ -18,6 +25,9 @@ type KVStore interface {
 	Delete(key string) error
 }
 
+// ErrNotFound is returned by Get when the key doesn't exist
+var ErrNotFound = errors.New("not found")
+
 // MemStore is an in-memory backend
 type MemStore struct {
 	data map[string]string
@@ -30,7 +40,7 @@ func NewMemStore() *MemStore {
 func (m *MemStore) Get(k string) (string, error) {
 	v, ok := m.data[k]
 	if !ok {
-		return "", errors.New("not found")
+		return "", ErrNotFound
 	}
 	return v, nil
 }
@@ -50,7 +60,38 @@ type SQLiteStore struct {
 	db *sql.DB
 }
 
+// SQLiteOptions trades durability for throughput explicitly
+type SQLiteOptions struct {
+	WAL         bool          // Write-ahead log: readers don't block the writer
+	Synchronous string        // OFF, NORMAL, FULL or EXTRA; empty keeps SQLite's default
+	BusyTimeout time.Duration // How long to wait on a locked database before failing
+}
+
 func NewSQLiteStore(path string) *SQLiteStore {
+	return NewSQLiteStoreWithOptions(path, SQLiteOptions{})
+}
+
+// NewSQLiteStoreWithOptions opens the store with the given pragmas. They're passed in
+// the DSN so every pooled connection gets them, not just the first one.
+func NewSQLiteStoreWithOptions(path string, opts SQLiteOptions) *SQLiteStore {
+	params := url.Values{}
+	if opts.WAL {
+		params.Set("_journal_mode", "WAL")
+	}
+	if opts.Synchronous != "" {
+		params.Set("_synchronous", opts.Synchronous)
+	}
+	if opts.BusyTimeout > 0 {
+		params.Set("_busy_timeout", fmt.Sprint(opts.BusyTimeout.Milliseconds()))
+	}
+	if len(params) > 0 {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + params.Encode()
+	}
+
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		log.Fatalf("failed to open sqlite: %v", err)
@@ -65,8 +106,11 @@ func NewSQLiteStore(path string) *SQLiteStore {
 func (s *SQLiteStore) Get(k string) (string, error) {
 	var v string
 	err := s.db.QueryRow("SELECT val FROM kv WHERE key = ?", k).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
 	if err != nil {
-		return "", errors.New("not found")
+		return "", err
 	}
 	return v, nil
 }
@@ -81,6 +125,239 @@ func (s *SQLiteStore) Delete(k string) error {
 	return err
 }
 
+// JournalMode reports the journal mode in effect, e.g. "wal" or "delete"
+func (s *SQLiteStore) JournalMode() (string, error) {
+	var mode string
+	err := s.db.QueryRow("PRAGMA journal_mode").Scan(&mode)
+	return mode, err
+}
+
+// Sync forces a WAL checkpoint, moving logged writes into the main database file.
+// Without WAL there is no log to checkpoint and this is a no-op.
+func (s *SQLiteStore) Sync() error {
+	_, err := s.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
+
+// RetryingStore wraps any KVStore and retries failed operations with exponential
+// backoff, for networked backends with transient failures. Only errors Retryable
+// accepts are retried; the default, also used when Retryable is nil, retries
+// everything except ErrNotFound, which is an answer rather than a failure.
+type RetryingStore struct {
+	KVStore
+	Attempts  int           // total tries per operation, at least 1
+	BaseDelay time.Duration // wait before the first retry, doubled after each
+	Retryable func(error) bool
+}
+
+// retryUnlessNotFound is RetryingStore's default Retryable
+func retryUnlessNotFound(err error) bool {
+	return !errors.Is(err, ErrNotFound)
+}
+
+func NewRetryingStore(store KVStore, attempts int, baseDelay time.Duration) *RetryingStore {
+	return &RetryingStore{
+		KVStore:   store,
+		Attempts:  attempts,
+		BaseDelay: baseDelay,
+		Retryable: retryUnlessNotFound,
+	}
+}
+
+func (s *RetryingStore) Get(k string) (string, error) {
+	var v string
+	err := s.retry(func() error {
+		var err error
+		v, err = s.KVStore.Get(k)
+		return err
+	})
+	return v, err
+}
+
+func (s *RetryingStore) Set(k, v string) error {
+	return s.retry(func() error { return s.KVStore.Set(k, v) })
+}
+
+func (s *RetryingStore) Delete(k string) error {
+	return s.retry(func() error { return s.KVStore.Delete(k) })
+}
+
+// retry calls op until it succeeds, fails with a non-retryable error or runs out of attempts
+func (s *RetryingStore) retry(op func() error) error {
+	delay := s.BaseDelay
+	attempts := s.Attempts
+	if attempts < 1 {
+		attempts = 1 // Always try at least once
+	}
+	retryable := s.Retryable
+	if retryable == nil {
+		retryable = retryUnlessNotFound
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = op(); err == nil || !retryable(err) {
+			return err
+		}
+		if attempt < attempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+}
+
+// Codec converts values to and from the strings a KVStore holds
+type Codec[V any] interface {
+	Encode(v V) (string, error)
+	Decode(s string) (V, error)
+}
+
+// JSONCodec encodes values as JSON
+type JSONCodec[V any] struct{}
+
+func (JSONCodec[V]) Encode(v V) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (JSONCodec[V]) Decode(s string) (V, error) {
+	var v V
+	err := json.Unmarshal([]byte(s), &v)
+	return v, err
+}
+
+// TypedStore stores values of type V over any string KVStore, encoding them with a Codec
+type TypedStore[V any] struct {
+	store KVStore
+	codec Codec[V]
+}
+
+// NewTypedStore wraps store, encoding values as JSON
+func NewTypedStore[V any](store KVStore) *TypedStore[V] {
+	return NewTypedStoreWithCodec[V](store, JSONCodec[V]{})
+}
+
+func NewTypedStoreWithCodec[V any](store KVStore, codec Codec[V]) *TypedStore[V] {
+	return &TypedStore[V]{store: store, codec: codec}
+}
+
+func (s *TypedStore[V]) Get(k string) (V, error) {
+	raw, err := s.store.Get(k)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	v, err := s.codec.Decode(raw)
+	if err != nil {
+		return v, fmt.Errorf("decoding %s: %w", k, err)
+	}
+	return v, nil
+}
+
+func (s *TypedStore[V]) Set(k string, v V) error {
+	raw, err := s.codec.Encode(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", k, err)
+	}
+	return s.store.Set(k, raw)
+}
+
+func (s *TypedStore[V]) Delete(k string) error {
+	return s.store.Delete(k)
+}
+
+// IdempotentStore wraps any KVStore and skips writes whose request ID was
+// already applied, so retried or replayed requests don't write twice.
+// The last recentCap IDs are kept in an exact set, so replays of recent
+// requests are always caught and a new ID is never mistaken for one of them.
+// IDs that age out of the set move to a Bloom filter, and that is the only
+// place a mistake can happen: a brand new ID that collides with an aged-out
+// one looks like a replay and its write is dropped. With n IDs aged out of
+// a filter sized for expectedIDs, that happens to about (1 - e^(-k*n/m))^k
+// of new IDs (m bits, k hashes), reaching 1% once n hits expectedIDs.
+type IdempotentStore struct {
+	KVStore
+	mu        sync.Mutex
+	aged      *BloomFilter // IDs that left the recent set
+	recent    map[string]struct{}
+	order     []string // recent IDs, oldest first
+	recentCap int
+}
+
+func NewIdempotentStore(store KVStore, expectedIDs, recentCap int) *IdempotentStore {
+	return &IdempotentStore{
+		KVStore:   store,
+		aged:      NewBloomFilter(expectedIDs, 0.01),
+		recent:    make(map[string]struct{}),
+		recentCap: recentCap,
+	}
+}
+
+// SetOnce applies the write unless requestID was seen before.
+// It reports whether the write was applied.
+func (s *IdempotentStore) SetOnce(requestID, k, v string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.recent[requestID]; ok {
+		return false, nil
+	}
+	if s.aged.Contains([]byte(requestID)) {
+		return false, nil // aged out of the exact set, or a false positive
+	}
+
+	if err := s.KVStore.Set(k, v); err != nil {
+		return false, err // not recorded, so a retry can still apply it
+	}
+
+	s.recent[requestID] = struct{}{}
+	s.order = append(s.order, requestID)
+	if len(s.order) > s.recentCap {
+		oldest := s.order[0]
+		delete(s.recent, oldest)
+		s.aged.Add([]byte(oldest))
+		s.order = s.order[1:]
+	}
+	return true, nil
+}
+
+// BloomFilter backs IdempotentStore's aged request IDs. Nothing here merges,
+// sizes or saves filters, so it keeps just Add and Contains over k murmur3
+// hashes; chapter 9 builds out the full structure.
+type BloomFilter struct {
+	bitset []uint64
+	size   uint
+	k      uint
+}
+
+func NewBloomFilter(expectedElements int, falsePositiveRate float64) *BloomFilter {
+	size := uint(math.Ceil(-float64(expectedElements) * math.Log(falsePositiveRate) / math.Pow(math.Log(2), 2)))
+	k := uint(math.Max(1, math.Round(float64(size)/float64(expectedElements)*math.Log(2))))
+	return &BloomFilter{
+		bitset: make([]uint64, (size+63)/64),
+		size:   size,
+		k:      k,
+	}
+}
+
+func (bf *BloomFilter) Add(data []byte) {
+	for i := uint(0); i < bf.k; i++ {
+		position := uint(murmur3.Sum64WithSeed(data, uint32(i)) % uint64(bf.size))
+		bf.bitset[position/64] |= 1 << (position % 64)
+	}
+}
+
+func (bf *BloomFilter) Contains(data []byte) bool {
+	for i := uint(0); i < bf.k; i++ {
+		position := uint(murmur3.Sum64WithSeed(data, uint32(i)) % uint64(bf.size))
+		if bf.bitset[position/64]&(1<<(position%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // LRUCache is a fixed-size key-value cache
 type entry struct{ key, val string }
 
@@ -125,7 +402,8 @@ func main() {
 	var storeName string
 
 	if os.Getenv("BACKEND") == "sqlite" {
-		store = NewSQLiteStore("kv.db")
+		// SQLITE_BUSY and friends are transient, so retry a few times
+		store = NewRetryingStore(NewSQLiteStore("kv.db"), 3, 50*time.Millisecond)
 		storeName = "sqlite"
 	} else {
 		store = NewMemStore()
@@ -168,4 +446,24 @@ func main() {
 	for k, e := range cache.data {
 		log.Printf("%s -> %s", k, e.Value.(entry).val)
 	}
+
+	// Replaying the same request ID is a no-op
+	idem := NewIdempotentStore(store, 10000, 100)
+	for _, reqID := range []string{"req-1", "req-1"} {
+		applied, err := idem.SetOnce(reqID, "site", "GOFORGOPHERS.COM")
+		log.Printf("[idempotent] %s applied=%v err=%v", reqID, applied, err)
+	}
+
+	// Structured values over the same string store
+	type release struct {
+		Version string
+		Stable  bool
+	}
+	releases := NewTypedStore[release](store)
+	if err := releases.Set("release", release{Version: "1.22", Stable: true}); err != nil {
+		log.Printf("[typed] failed to set release: %v", err)
+	}
+	if r, err := releases.Get("release"); err == nil {
+		log.Printf("[typed] release = %+v", r)
+	}
 }
@@ -0,0 +1,216 @@
+package main
+
+// Run with: go test kvstore.go kvstore_test.go
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdempotentStoreReplay(t *testing.T) {
+	store := NewMemStore()
+	idem := NewIdempotentStore(store, 1000, 10)
+
+	applied, err := idem.SetOnce("req-1", "k", "first")
+	if err != nil || !applied {
+		t.Fatalf("first SetOnce = %v, %v; want true, nil", applied, err)
+	}
+	applied, err = idem.SetOnce("req-1", "k", "second")
+	if err != nil || applied {
+		t.Fatalf("replayed SetOnce = %v, %v; want false, nil", applied, err)
+	}
+	if v, _ := store.Get("k"); v != "first" {
+		t.Errorf("value = %q after replay, want %q", v, "first")
+	}
+}
+
+func TestIdempotentStoreAgedReplay(t *testing.T) {
+	idem := NewIdempotentStore(NewMemStore(), 1000, 10)
+	for i := 0; i < 50; i++ {
+		if applied, _ := idem.SetOnce(fmt.Sprint("req-", i), "k", "v"); !applied {
+			t.Fatalf("req-%d dropped with only recent IDs to compare against", i)
+		}
+	}
+	// req-0 has long left the exact set; the Bloom filter still catches it
+	if applied, _ := idem.SetOnce("req-0", "k", "v"); applied {
+		t.Error("aged-out request ID was applied again")
+	}
+}
+
+func TestSQLiteStoreWAL(t *testing.T) {
+	store := NewSQLiteStoreWithOptions(filepath.Join(t.TempDir(), "kv.db"), SQLiteOptions{
+		WAL:         true,
+		Synchronous: "NORMAL",
+	})
+	mode, err := store.JournalMode()
+	if err != nil || mode != "wal" {
+		t.Fatalf("journal mode = %q, %v; want wal", mode, err)
+	}
+
+	if err := store.Set("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Sync(); err != nil {
+		t.Errorf("Sync: %v", err)
+	}
+	if v, err := store.Get("k"); err != nil || v != "v" {
+		t.Errorf("Get after Sync = %q, %v", v, err)
+	}
+}
+
+func TestSQLiteStoreBusyTimeout(t *testing.T) {
+	// Two stores on one file compete for the write lock; the busy timeout
+	// makes the loser wait instead of failing with "database is locked"
+	path := filepath.Join(t.TempDir(), "kv.db")
+	opts := SQLiteOptions{WAL: true, BusyTimeout: 5 * time.Second}
+	stores := []*SQLiteStore{NewSQLiteStoreWithOptions(path, opts), NewSQLiteStoreWithOptions(path, opts)}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 400)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			store := stores[w%2]
+			for i := 0; i < 100; i++ {
+				if err := store.Set(fmt.Sprintf("w%d-%d", w, i), "v"); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent Set: %v", err)
+	}
+	if v, err := stores[0].Get("w3-99"); err != nil || v != "v" {
+		t.Errorf("write through the other store not visible: %q, %v", v, err)
+	}
+}
+
+// flakyStore fails the first failures calls to each operation with err, then
+// passes through to an in-memory store
+type flakyStore struct {
+	*MemStore
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyStore) fail() error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	return nil
+}
+
+func (f *flakyStore) Get(k string) (string, error) {
+	if err := f.fail(); err != nil {
+		return "", err
+	}
+	return f.MemStore.Get(k)
+}
+
+func (f *flakyStore) Set(k, v string) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.MemStore.Set(k, v)
+}
+
+func TestRetryingStoreRecovers(t *testing.T) {
+	flaky := &flakyStore{MemStore: NewMemStore(), failures: 2, err: errors.New("connection reset")}
+	store := NewRetryingStore(flaky, 3, time.Millisecond)
+
+	if err := store.Set("k", "v"); err != nil {
+		t.Fatalf("Set after two transient failures: %v", err)
+	}
+	if flaky.calls != 3 {
+		t.Errorf("backend called %d times, want 3", flaky.calls)
+	}
+
+	// Out of attempts, the last error comes back
+	flaky.calls, flaky.failures = 0, 5
+	if _, err := store.Get("k"); err == nil || flaky.calls != 3 {
+		t.Errorf("Get with a persistent failure: err = %v after %d calls, want an error after 3", err, flaky.calls)
+	}
+}
+
+func TestRetryingStoreLiteralUsesDefaultPredicate(t *testing.T) {
+	// Built without NewRetryingStore, so Retryable is nil
+	flaky := &flakyStore{MemStore: NewMemStore(), failures: 1, err: errors.New("connection reset")}
+	store := &RetryingStore{KVStore: flaky, Attempts: 3, BaseDelay: time.Millisecond}
+	if err := store.Set("k", "v"); err != nil {
+		t.Fatalf("Set = %v, want success after a retry", err)
+	}
+
+	missing := &flakyStore{MemStore: NewMemStore(), failures: 5, err: ErrNotFound}
+	store = &RetryingStore{KVStore: missing, Attempts: 3}
+	if _, err := store.Get("k"); !errors.Is(err, ErrNotFound) || missing.calls != 1 {
+		t.Errorf("Get = %v after %d calls, want ErrNotFound after 1", err, missing.calls)
+	}
+}
+
+func TestRetryingStoreNotFoundIsNotRetried(t *testing.T) {
+	flaky := &flakyStore{MemStore: NewMemStore(), failures: 5, err: fmt.Errorf("lookup: %w", ErrNotFound)}
+	store := NewRetryingStore(flaky, 3, time.Millisecond)
+
+	if _, err := store.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+	if flaky.calls != 1 {
+		t.Errorf("backend called %d times for a not-found, want 1", flaky.calls)
+	}
+}
+
+type profile struct {
+	Name  string
+	Tags  []string
+	Score float64
+}
+
+func TestTypedStoreRoundTrip(t *testing.T) {
+	store := NewTypedStore[profile](NewMemStore())
+	want := profile{Name: "ada", Tags: []string{"admin", "ops"}, Score: 9.5}
+	if err := store.Set("u1", want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Get = %+v, want %+v", got, want)
+	}
+	if _, err := store.Get("u2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing key: err = %v, want ErrNotFound", err)
+	}
+}
+
+// failingCodec can't encode anything
+type failingCodec struct{ JSONCodec[profile] }
+
+func (failingCodec) Encode(profile) (string, error) { return "", errors.New("unsupported value") }
+
+func TestTypedStoreCodecErrors(t *testing.T) {
+	backend := NewMemStore()
+	if err := NewTypedStoreWithCodec[profile](backend, failingCodec{}).Set("u1", profile{}); err == nil {
+		t.Error("Set with a failing codec returned nil")
+	}
+	if _, err := backend.Get("u1"); !errors.Is(err, ErrNotFound) {
+		t.Error("a value that failed to encode was written")
+	}
+
+	backend.Set("u1", "{not json")
+	if _, err := NewTypedStore[profile](backend).Get("u1"); err == nil || !strings.Contains(err.Error(), "decoding u1") {
+		t.Errorf("Get of corrupt data: err = %v, want a decoding error", err)
+	}
+}
@@ -13,13 +13,33 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Cache is the common surface shared by the book's caches (this LRUCache and
+// the skip-list based TTLCache in chapter09), so callers can swap eviction
+// strategies without touching the code that uses them.
+type Cache[K comparable, V any] interface {
+	Get(key K) (V, bool)
+	Set(key K, val V)
+	SetWithTTL(key K, val V, ttl time.Duration)
+	Delete(key K)
+	Len() int
+}
+
+var _ Cache[string, string] = (*LRUCache)(nil)
+
 type entry struct{ key, val string }
 
+// Eviction is a key and value the cache evicted, as sent on Evictions
+type Eviction struct {
+	Key, Value string
+}
+
 type LRUCache struct {
-	cap  int
-	list *list.List
-	data map[string]*list.Element
-	mu   sync.Mutex
+	cap       int
+	list      *list.List
+	data      map[string]*list.Element
+	mu        sync.Mutex
+	evictions chan Eviction // nil unless created with NewLRUWithEvictions
+	dropped   uint64        // evictions discarded because the channel was full
 }
 
 func NewLRU(cap int) *LRUCache {
@@ -30,6 +50,28 @@ func NewLRU(cap int) *LRUCache {
 	}
 }
 
+// NewLRUWithEvictions creates a cache that also publishes evicted entries
+// on a channel with room for buffer pending entries (see Evictions)
+func NewLRUWithEvictions(cap, buffer int) *LRUCache {
+	c := NewLRU(cap)
+	c.evictions = make(chan Eviction, buffer)
+	return c
+}
+
+// Evictions returns the channel evicted entries are sent to, in eviction order.
+// Sends never block: if the consumer falls behind, entries are dropped and
+// counted in DroppedEvictions. It's nil for caches created with NewLRU.
+func (c *LRUCache) Evictions() <-chan Eviction {
+	return c.evictions
+}
+
+// DroppedEvictions returns how many evictions didn't fit in the channel
+func (c *LRUCache) DroppedEvictions() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
 func (c *LRUCache) Get(k string) (string, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -52,11 +94,45 @@ func (c *LRUCache) Set(k, v string) {
 		old := c.list.Back()
 		c.list.Remove(old)
 		delete(c.data, old.Value.(entry).key)
+		c.notifyEviction(old.Value.(entry))
 	}
 	e := c.list.PushFront(entry{k, v})
 	c.data[k] = e
 }
 
+// notifyEviction publishes an evicted entry without blocking; callers hold c.mu
+func (c *LRUCache) notifyEviction(e entry) {
+	if c.evictions == nil {
+		return
+	}
+	select {
+	case c.evictions <- Eviction{Key: e.key, Value: e.val}:
+	default:
+		c.dropped++
+	}
+}
+
+// SetWithTTL satisfies Cache. An LRU evicts by recency, not age,
+// so the TTL is ignored and this behaves exactly like Set.
+func (c *LRUCache) SetWithTTL(k, v string, _ time.Duration) {
+	c.Set(k, v)
+}
+
+func (c *LRUCache) Delete(k string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.data[k]; ok {
+		c.list.Remove(e)
+		delete(c.data, k)
+	}
+}
+
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.list.Len()
+}
+
 type LRUSQLiteBackend struct {
 	cache *LRUCache
 	db    *sql.DB
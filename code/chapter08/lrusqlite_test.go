@@ -0,0 +1,98 @@
+package main
+
+// Run with: go test lrusqlite.go lrusqlite_test.go
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testCacheContract checks the behavior every Cache must share;
+// value makes the i-th test value in the cache's value type.
+func testCacheContract[V comparable](t *testing.T, c Cache[string, V], value func(i int) V) {
+	t.Helper()
+
+	if _, ok := c.Get("missing"); ok {
+		t.Error("Get found a key that was never set")
+	}
+
+	c.Set("a", value(1))
+	c.SetWithTTL("b", value(2), time.Hour)
+	if got, ok := c.Get("a"); !ok || got != value(1) {
+		t.Errorf("Get(a) = %v, %v; want %v, true", got, ok, value(1))
+	}
+	if got, ok := c.Get("b"); !ok || got != value(2) {
+		t.Errorf("Get(b) = %v, %v; want %v, true", got, ok, value(2))
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+
+	c.Set("a", value(3))
+	if got, _ := c.Get("a"); got != value(3) {
+		t.Errorf("Get(a) after overwrite = %v, want %v", got, value(3))
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() after overwrite = %d, want 2", c.Len())
+	}
+
+	c.Delete("a")
+	c.Delete("missing") // Deleting an absent key is a no-op
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get found a deleted key")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len() after delete = %d, want 1", c.Len())
+	}
+}
+
+func TestLRUCacheContract(t *testing.T) {
+	testCacheContract[string](t, NewLRU(10), func(i int) string { return fmt.Sprint("v", i) })
+}
+
+func TestLRUCacheIgnoresTTL(t *testing.T) {
+	c := NewLRU(10)
+	c.SetWithTTL("k", "v", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := c.Get("k"); !ok {
+		t.Error("LRUCache expired an entry; it should evict by recency only")
+	}
+}
+
+func TestLRUEvictionsInOrder(t *testing.T) {
+	c := NewLRUWithEvictions(2, 10)
+	c.Set("a", "1")
+	c.Set("b", "2")
+	c.Get("a") // b is now the least recently used
+	c.Set("c", "3")
+	c.Set("d", "4")
+
+	want := []Eviction{{"b", "2"}, {"a", "1"}}
+	for _, w := range want {
+		select {
+		case got := <-c.Evictions():
+			if got != w {
+				t.Errorf("evicted %v, want %v", got, w)
+			}
+		default:
+			t.Fatalf("no eviction on the channel, want %v", w)
+		}
+	}
+	if c.DroppedEvictions() != 0 {
+		t.Errorf("%d evictions dropped, want 0", c.DroppedEvictions())
+	}
+}
+
+func TestLRUEvictionsDropWhenFull(t *testing.T) {
+	c := NewLRUWithEvictions(1, 1)
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprint("k", i), "v") // Nobody reads; Set must not block
+	}
+	if got := <-c.Evictions(); got.Key != "k0" {
+		t.Errorf("first eviction = %v, want k0", got)
+	}
+	if c.DroppedEvictions() != 3 {
+		t.Errorf("%d evictions dropped, want 3", c.DroppedEvictions())
+	}
+}
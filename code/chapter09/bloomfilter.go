@@ -1,35 +1,98 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"math"
+	"math/bits"
 
 	"github.com/spaolacci/murmur3"
 )
 
 // BloomFilter represents a Bloom filter data structure
 type BloomFilter struct {
-	bitset []uint64 // Using uint64 for efficient bit operations
-	size   uint     // Size of the bitset in bits
-	k      uint     // Number of hash functions
+	bitset []uint64    // Using uint64 for efficient bit operations
+	size   uint        // Size of the bitset in bits
+	k      uint        // Number of hash functions
+	scheme bloomScheme // How elements are hashed to bits
+	key    [2]uint64   // SipHash key, for bloomSipHash
+	seed   uint32      // Base mixed into every hash seed, for bloomMurmur3
 }
 
-// New creates a new Bloom filter optimized for expectedElements with falsePositiveRate
+// bloomScheme identifies how a filter maps elements to bits
+type bloomScheme byte
+
+const (
+	// bloomMurmur3 hashes the i-th position with murmur3 seeded by seed+i. Its
+	// outputs are easy to predict, so it's only kept for filters saved before SipHash.
+	bloomMurmur3 bloomScheme = 1
+
+	// bloomSipHash hashes the i-th position with SipHash-2-4 under key, its second
+	// half offset by i. Without the key, nobody can tell which bits an element sets.
+	bloomSipHash bloomScheme = 2
+)
+
+// NewBloomFilter creates a new Bloom filter optimized for expectedElements with falsePositiveRate.
+// It hashes with a random key, so an adversary can't craft elements that all land on
+// the same bits. Filters only combine or compare when they share a key; build those
+// with NewSeededBloomFilter and the same key instead.
+// It panics if the system's random source fails.
 func NewBloomFilter(expectedElements int, falsePositiveRate float64) *BloomFilter {
+	bf, err := NewSeededBloomFilter(expectedElements, falsePositiveRate, nil)
+	if err != nil {
+		panic(err)
+	}
+	return bf
+}
+
+// NewSeededBloomFilter creates a filter whose SipHash key is derived from key, so
+// filters built with the same key hash alike and can be compared or combined.
+// A nil key draws a random one, as NewBloomFilter does.
+// It fails only if a random key was needed and the system's random source failed.
+func NewSeededBloomFilter(expectedElements int, falsePositiveRate float64, key []byte) (*BloomFilter, error) {
 	// Calculate optimal size and number of hash functions
-	size := optimalBitSize(expectedElements, falsePositiveRate)
-	k := optimalHashCount(size, expectedElements)
+	size, bytes, k := EstimateBloomMemory(expectedElements, falsePositiveRate)
+
+	var sipKey [16]byte
+	if key == nil {
+		if _, err := rand.Read(sipKey[:]); err != nil {
+			return nil, fmt.Errorf("generating bloom filter key: %w", err)
+		}
+	} else {
+		sum := sha256.Sum256(key)
+		copy(sipKey[:], sum[:])
+	}
 
 	// Create a bitset with enough uint64 elements
-	bitsetSize := (size + 63) / 64 // Round up to nearest uint64
 	return &BloomFilter{
-		bitset: make([]uint64, bitsetSize),
+		bitset: make([]uint64, bytes/8),
 		size:   size,
 		k:      k,
-	}
+		scheme: bloomSipHash,
+		key:    [2]uint64{binary.LittleEndian.Uint64(sipKey[:]), binary.LittleEndian.Uint64(sipKey[8:])},
+	}, nil
+}
+
+// EstimateBloomMemory returns the sizing NewBloomFilter would use without allocating anything:
+// the number of bits, the bytes backing them (rounded up to whole uint64 words) and the hash count.
+// Handy to sanity-check parameters, since a tiny error rate over many elements gets big fast.
+func EstimateBloomMemory(expected int, fpRate float64) (bits uint, bytes int, k uint) {
+	bits = optimalBitSize(expected, fpRate)
+	k = optimalHashCount(bits, expected)
+	bytes = int((bits+63)/64) * 8 // Round up to nearest uint64
+	return bits, bytes, k
 }
 
 // optimalBitSize calculates the optimal size of the bitset
@@ -63,13 +126,391 @@ func (bf *BloomFilter) Contains(data []byte) bool {
 	return true
 }
 
+// AddString adds a string element, saving callers the []byte conversion
+func (bf *BloomFilter) AddString(s string) {
+	bf.Add([]byte(s))
+}
+
+// ContainsString checks if a string element might be in the Bloom filter
+func (bf *BloomFilter) ContainsString(s string) bool {
+	return bf.Contains([]byte(s))
+}
+
+// ContainsAll checks a batch of elements, returning one result per item.
+// It answers exactly like calling Contains on each item, but makes a single
+// allocation for the whole batch and stops hashing an item at its first unset bit.
+func (bf *BloomFilter) ContainsAll(items [][]byte) []bool {
+	results := make([]bool, len(items))
+	for n, data := range items {
+		results[n] = true
+		for i := uint(0); i < bf.k; i++ {
+			position := bf.getPosition(data, i)
+			if bf.bitset[position/64]&(1<<(position%64)) == 0 {
+				results[n] = false
+				break
+			}
+		}
+	}
+	return results
+}
+
+// FillRatio returns the fraction of bits set. At the design load it sits near 0.5;
+// well past that, the false positive rate has degraded beyond what was asked for.
+func (bf *BloomFilter) FillRatio() float64 {
+	return float64(bf.setBits()) / float64(bf.size)
+}
+
+// EstimatedCount approximates how many distinct elements were added, from the set bits X:
+// n ≈ -(m/k) * ln(1 - X/m). It loses precision as the filter saturates,
+// and returns math.MaxUint64 once every bit is set.
+func (bf *BloomFilter) EstimatedCount() uint64 {
+	set := bf.setBits()
+	if set >= bf.size {
+		return math.MaxUint64
+	}
+	m, k := float64(bf.size), float64(bf.k)
+	return uint64(math.Round(-m / k * math.Log1p(-float64(set)/m)))
+}
+
+// setBits counts the bits set in the bitset
+func (bf *BloomFilter) setBits() uint {
+	var count int
+	for _, word := range bf.bitset {
+		count += bits.OnesCount64(word)
+	}
+	return uint(count)
+}
+
+// ApproxDifference returns a new filter approximating the elements in bf but not in other.
+// Bloom filters can't subtract exactly: the result is the bitset a AND NOT b, so an element
+// only in bf loses any bit that other also set (for its own elements), which shows up as a
+// false negative. An exclusive element survives only if none of its k bits is set in other,
+// so the false negative rate is about 1 - (1 - other.FillRatio())^k: the fuller other is,
+// the more of bf's exclusive elements go missing.
+// Both filters must share size, k and hash key, otherwise nil is returned.
+func (bf *BloomFilter) ApproxDifference(other *BloomFilter) *BloomFilter {
+	if !bf.compatible(other) {
+		return nil
+	}
+
+	diff := &BloomFilter{
+		bitset: make([]uint64, len(bf.bitset)),
+		size:   bf.size,
+		k:      bf.k,
+		scheme: bf.scheme,
+		key:    bf.key,
+		seed:   bf.seed,
+	}
+	for i := range bf.bitset {
+		diff.bitset[i] = bf.bitset[i] &^ other.bitset[i]
+	}
+	return diff
+}
+
+// Union merges other into bf, ORing the bitsets so bf contains every element of either.
+// The false positive rate stays within the design rate as long as the combined element
+// count stays within the expected elements bf was sized for.
+func (bf *BloomFilter) Union(other *BloomFilter) error {
+	if !bf.compatible(other) {
+		return fmt.Errorf("cannot union bloom filters: size %d/%d and hash count %d/%d must match, and so must the hash key",
+			bf.size, other.size, bf.k, other.k)
+	}
+	for i := range bf.bitset {
+		bf.bitset[i] |= other.bitset[i]
+	}
+	return nil
+}
+
+// Clone returns an independent copy of the filter
+func (bf *BloomFilter) Clone() *BloomFilter {
+	clone := *bf
+	clone.bitset = make([]uint64, len(bf.bitset))
+	copy(clone.bitset, bf.bitset)
+	return &clone
+}
+
+// compatible reports whether two filters map elements to the same bits
+func (bf *BloomFilter) compatible(other *BloomFilter) bool {
+	return bf.size == other.size && bf.k == other.k &&
+		bf.scheme == other.scheme && bf.key == other.key && bf.seed == other.seed
+}
+
 // getPosition calculates the bit position for a given element and hash function
 func (bf *BloomFilter) getPosition(data []byte, hashNum uint) uint {
-	// Create different hash functions using the seed value
-	hash := murmur3.Sum64WithSeed(data, uint32(hashNum))
+	var hash uint64
+	if bf.scheme == bloomMurmur3 {
+		// Create different hash functions using the seed value
+		hash = murmur3.Sum64WithSeed(data, bf.seed+uint32(hashNum))
+	} else {
+		// Create different hash functions by offsetting the key
+		hash = sipHash24(bf.key[0], bf.key[1]+uint64(hashNum), data)
+	}
 	return uint(hash % uint64(bf.size))
 }
 
+// sipHash24 returns the SipHash-2-4 of data under the 128-bit key k0, k1.
+// It's a keyed pseudorandom function: fast enough for hash tables, but without
+// the key its outputs can't be predicted, so collisions can't be precomputed.
+func sipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13) ^ v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16) ^ v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21) ^ v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17) ^ v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	// Two rounds per 8-byte word, the final word padded with the length in its top byte
+	last := uint64(len(data)) << 56
+	for ; len(data) >= 8; data = data[8:] {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	for i, b := range data {
+		last |= uint64(b) << (8 * i)
+	}
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+
+	// Four finalization rounds
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		round()
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// addCounting adds an element like Add, returning how many bits it newly set
+func (bf *BloomFilter) addCounting(data []byte) uint {
+	var added uint
+	for i := uint(0); i < bf.k; i++ {
+		position := bf.getPosition(data, i)
+		index, bit := position/64, position%64
+		if bf.bitset[index]&(1<<bit) == 0 {
+			bf.bitset[index] |= 1 << bit
+			added++
+		}
+	}
+	return added
+}
+
+// Growth parameters for ScalableBloomFilter
+const (
+	scalableFillThreshold = 0.5 // fill ratio at which a new slice is started
+	scalableGrowth        = 2   // each slice expects this many times the elements of the last
+	scalableTightening    = 0.5 // each slice's error rate is this fraction of the last
+)
+
+// ScalableBloomFilter grows as elements arrive instead of needing the count up front.
+// It is a series of Bloom filter slices: new elements go to the newest slice, and once
+// that slice is half full a larger one is started. Each slice gets a tighter error rate,
+// p0, p0*r, p0*r^2..., so the compound rate (the sum) stays below p0/(1-r).
+// Starting at p0 = errorRate*(1-r) keeps that bound at errorRate.
+type ScalableBloomFilter struct {
+	filters   []*BloomFilter
+	fill      uint    // bits set in the newest slice
+	capacity  int     // expected elements of the newest slice
+	errorRate float64 // error rate of the newest slice
+}
+
+// NewScalableBloomFilter creates a filter starting with room for initialCapacity
+// elements, holding the false positive rate under errorRate however many are added
+func NewScalableBloomFilter(initialCapacity int, errorRate float64) *ScalableBloomFilter {
+	sbf := &ScalableBloomFilter{
+		capacity:  initialCapacity,
+		errorRate: errorRate * (1 - scalableTightening),
+	}
+	sbf.filters = []*BloomFilter{NewBloomFilter(sbf.capacity, sbf.errorRate)}
+	return sbf
+}
+
+// Add adds an element to the newest slice, starting a new slice if it's now full
+func (sbf *ScalableBloomFilter) Add(data []byte) {
+	current := sbf.filters[len(sbf.filters)-1]
+	sbf.fill += current.addCounting(data)
+
+	if float64(sbf.fill)/float64(current.size) >= scalableFillThreshold {
+		sbf.capacity *= scalableGrowth
+		sbf.errorRate *= scalableTightening
+		sbf.filters = append(sbf.filters, NewBloomFilter(sbf.capacity, sbf.errorRate))
+		sbf.fill = 0
+	}
+}
+
+// Contains checks if an element might be in any slice
+func (sbf *ScalableBloomFilter) Contains(data []byte) bool {
+	for _, f := range sbf.filters {
+		if f.Contains(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Slices returns the number of filter slices
+func (sbf *ScalableBloomFilter) Slices() int {
+	return len(sbf.filters)
+}
+
+// Bits returns the total size in bits across all slices
+func (sbf *ScalableBloomFilter) Bits() uint {
+	var total uint
+	for _, f := range sbf.filters {
+		total += f.size
+	}
+	return total
+}
+
+// Serialized structures start with a small header: a 4-byte magic number
+// naming the structure, then a version byte for the payload layout.
+// Loaders reject foreign magic and versions newer than they know, and bring
+// older payloads up to date by running migrations one version at a time.
+const headerSize = 5
+
+// migration upgrades a payload from version v to v+1
+type migration func(payload []byte) ([]byte, error)
+
+// writeHeader appends the header for a structure to buf
+func writeHeader(buf []byte, magic [4]byte, version byte) []byte {
+	buf = append(buf, magic[:]...)
+	return append(buf, version)
+}
+
+// readHeader validates data's header and returns its payload upgraded to
+// version current, using migrations[v] to step from v to v+1
+func readHeader(data []byte, magic [4]byte, current byte, migrations map[byte]migration) ([]byte, error) {
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("data too short for header: %d bytes", len(data))
+	}
+	if !bytes.Equal(data[:4], magic[:]) {
+		return nil, fmt.Errorf("bad magic %q, expected %q", data[:4], magic[:])
+	}
+
+	version := data[4]
+	if version == 0 || version > current {
+		return nil, fmt.Errorf("unsupported format version %d (latest known is %d)", version, current)
+	}
+
+	payload := data[headerSize:]
+	for ; version < current; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration from format version %d", version)
+		}
+		var err error
+		if payload, err = migrate(payload); err != nil {
+			return nil, fmt.Errorf("migrating from format version %d: %w", version, err)
+		}
+	}
+	return payload, nil
+}
+
+// bloomMagic and bloomVersion head every serialized BloomFilter.
+// Version 2 records the hashing scheme and its key ahead of the bitset, so the
+// scheme can change without making stored filters unreadable.
+var bloomMagic = [4]byte{'B', 'L', 'O', 'M'}
+
+const bloomVersion = 2
+
+// bloomFieldsSize covers size (uint64), k (uint32), the scheme and the key length
+// (a byte each) ahead of the key and the bitset
+const bloomFieldsSize = 14
+
+// bloomMigrations bring older serialized filters up to bloomVersion
+var bloomMigrations = map[byte]migration{
+	// Version 1 stored size, k and the seed base, and always hashed with murmur3
+	1: func(payload []byte) ([]byte, error) {
+		if len(payload) < 16 {
+			return nil, fmt.Errorf("payload too short: %d bytes", len(payload))
+		}
+		migrated := make([]byte, 0, len(payload)+2)
+		migrated = append(migrated, payload[:12]...)
+		migrated = append(migrated, byte(bloomMurmur3), 4)
+		return append(migrated, payload[12:]...), nil
+	},
+}
+
+// MarshalBinary encodes the filter as header, size, k, hashing scheme, key and then
+// the bitset words, all little-endian. The key is kept so the filter hashes the
+// same after a reload; treat the output as secret as the key itself.
+// SipHash keys take 16 bytes, murmur3 seed bases 4.
+func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, headerSize+bloomFieldsSize+16+len(bf.bitset)*8)
+	buf = writeHeader(buf, bloomMagic, bloomVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(bf.size))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(bf.k))
+	if bf.scheme == bloomMurmur3 {
+		buf = append(buf, byte(bloomMurmur3), 4)
+		buf = binary.LittleEndian.AppendUint32(buf, bf.seed)
+	} else {
+		buf = append(buf, byte(bloomSipHash), 16)
+		buf = binary.LittleEndian.AppendUint64(buf, bf.key[0])
+		buf = binary.LittleEndian.AppendUint64(buf, bf.key[1])
+	}
+	for _, word := range bf.bitset {
+		buf = binary.LittleEndian.AppendUint64(buf, word)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary restores a filter written by MarshalBinary, replacing bf's contents.
+// Filters written in an older format version are migrated as they load.
+func (bf *BloomFilter) UnmarshalBinary(data []byte) error {
+	payload, err := readHeader(data, bloomMagic, bloomVersion, bloomMigrations)
+	if err != nil {
+		return fmt.Errorf("bloom filter: %w", err)
+	}
+	if len(payload) < bloomFieldsSize {
+		return fmt.Errorf("bloom filter: payload too short: %d bytes", len(payload))
+	}
+
+	size := uint(binary.LittleEndian.Uint64(payload))
+	k := uint(binary.LittleEndian.Uint32(payload[8:]))
+	if size == 0 || k == 0 {
+		return fmt.Errorf("bloom filter: invalid size %d or hash count %d", size, k)
+	}
+	scheme, keyLen := bloomScheme(payload[12]), int(payload[13])
+	if !(scheme == bloomMurmur3 && keyLen == 4 || scheme == bloomSipHash && keyLen == 16) {
+		return fmt.Errorf("bloom filter: unknown hashing scheme %d with a %d byte key", scheme, keyLen)
+	}
+	if len(payload) < bloomFieldsSize+keyLen {
+		return fmt.Errorf("bloom filter: payload too short for a %d byte key", keyLen)
+	}
+	restored := BloomFilter{size: size, k: k, scheme: scheme}
+	if keyBytes := payload[bloomFieldsSize:]; scheme == bloomMurmur3 {
+		restored.seed = binary.LittleEndian.Uint32(keyBytes)
+	} else {
+		restored.key = [2]uint64{binary.LittleEndian.Uint64(keyBytes), binary.LittleEndian.Uint64(keyBytes[8:])}
+	}
+
+	words := payload[bloomFieldsSize+keyLen:]
+	expected := (size + 63) / 64
+	if uint(len(words)) != expected*8 {
+		return fmt.Errorf("bloom filter: bitset is %d bytes, size %d bits needs %d", len(words), size, expected*8)
+	}
+
+	restored.bitset = make([]uint64, expected)
+	for i := range restored.bitset {
+		restored.bitset[i] = binary.LittleEndian.Uint64(words[i*8:])
+	}
+	*bf = restored
+	return nil
+}
+
 // Example usage of the Bloom filter
 // This example demonstrates how to use the Bloom filter for a web crawler cache
 // It normalizes URLs to ensure consistent representation and checks if a URL has been visited
@@ -84,13 +525,25 @@ type WebCrawlerCache struct {
 	filter *BloomFilter
 }
 
-// NewWebCrawlerCache creates a new cache optimized for expectedURLs
+// NewWebCrawlerCache creates a new cache optimized for expectedURLs.
+// Its filter hashes with a random key, so crafted URLs can't flood it with false positives.
 func NewWebCrawlerCache(expectedURLs int) *WebCrawlerCache {
 	// 0.01 = 1% false positive rate
 	filter := NewBloomFilter(expectedURLs, 0.01)
 	return &WebCrawlerCache{filter: filter}
 }
 
+// NewKeyedWebCrawlerCache creates a cache whose filter hashes with a key derived
+// from key, so caches sharing it can be compared with Overlap. Keep key secret:
+// whoever knows it can craft colliding URLs. A nil key picks a random one.
+func NewKeyedWebCrawlerCache(expectedURLs int, key []byte) (*WebCrawlerCache, error) {
+	filter, err := NewSeededBloomFilter(expectedURLs, 0.01, key)
+	if err != nil {
+		return nil, err
+	}
+	return &WebCrawlerCache{filter: filter}, nil
+}
+
 // NormalizeURL normalizes URLs for consistent representation
 func NormalizeURL(rawURL string) (string, error) {
 	u, err := url.Parse(rawURL)
@@ -136,6 +589,255 @@ func (wc *WebCrawlerCache) MarkVisited(rawURL string) error {
 	return nil
 }
 
+// Frontier is the crawler's to-visit queue. URLs are checked against the cache
+// when enqueued, so duplicates never enter the queue, and anything deeper than
+// maxDepth is dropped.
+type Frontier struct {
+	cache    *WebCrawlerCache
+	queue    []frontierItem
+	maxDepth int
+}
+
+type frontierItem struct {
+	URL   string `json:"url"`
+	Depth int    `json:"depth"`
+}
+
+// NewFrontier creates a frontier that dedups through cache
+func NewFrontier(cache *WebCrawlerCache, maxDepth int) *Frontier {
+	return &Frontier{cache: cache, maxDepth: maxDepth}
+}
+
+// Enqueue adds a URL found at depth, reporting whether it was queued.
+// URLs are marked visited as they're queued, so the same URL is never queued twice.
+// A URL that doesn't parse is never queued, and its error is returned.
+func (f *Frontier) Enqueue(rawURL string, depth int) (bool, error) {
+	if depth > f.maxDepth {
+		return false, nil
+	}
+
+	visited, err := f.cache.HasVisited(rawURL)
+	if err != nil || visited {
+		return false, err
+	}
+
+	if err := f.cache.MarkVisited(rawURL); err != nil {
+		return false, err
+	}
+	f.queue = append(f.queue, frontierItem{URL: rawURL, Depth: depth})
+	return true, nil
+}
+
+// Next pops the oldest queued URL and its depth; false when the queue is empty
+func (f *Frontier) Next() (string, int, bool) {
+	if len(f.queue) == 0 {
+		return "", 0, false
+	}
+	item := f.queue[0]
+	f.queue = f.queue[1:]
+	return item.URL, item.Depth, true
+}
+
+// Len returns the number of queued URLs
+func (f *Frontier) Len() int {
+	return len(f.queue)
+}
+
+// frontierState is what Save writes: the cache's filter and the pending queue
+type frontierState struct {
+	Filter []byte         `json:"filter"`
+	Queue  []frontierItem `json:"queue"`
+}
+
+// Save writes the cache's filter and the pending queue as JSON so a crawl can
+// resume later without queuing the URLs it already saw a second time
+func (f *Frontier) Save(w io.Writer) error {
+	filter, err := f.cache.filter.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(frontierState{Filter: filter, Queue: f.queue})
+}
+
+// Load replaces the cache's filter and the pending queue with ones written by Save.
+// The cache is restored in place, so anything else sharing it sees the saved URLs too.
+func (f *Frontier) Load(r io.Reader) error {
+	var state frontierState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+	var filter BloomFilter
+	if err := filter.UnmarshalBinary(state.Filter); err != nil {
+		return err
+	}
+	*f.cache.filter = filter
+	f.queue = state.Queue
+	return nil
+}
+
+// Overlap estimates the Jaccard similarity of the URL sets two crawls visited,
+// as the number of bits set in both filters over the number set in either.
+// Each URL sets the same k bits in any filter, so shared URLs land in the AND and
+// exclusive ones only in the OR. It's approximate: bits shared by colliding,
+// unrelated URLs inflate it, more so as the filters fill up.
+// Both caches must use identical filter parameters and the same key, as caches from
+// NewKeyedWebCrawlerCache with one key do, otherwise NaN is returned.
+func Overlap(a, b *WebCrawlerCache) float64 {
+	fa, fb := a.filter, b.filter
+	if !fa.compatible(fb) {
+		return math.NaN()
+	}
+
+	and, or := 0, 0
+	for i := range fa.bitset {
+		and += bits.OnesCount64(fa.bitset[i] & fb.bitset[i])
+		or += bits.OnesCount64(fa.bitset[i] | fb.bitset[i])
+	}
+	if or == 0 {
+		return 0 // Both empty
+	}
+	return float64(and) / float64(or)
+}
+
+// RateLimiter paces requests: Wait blocks until the next one may go out, or
+// returns ctx's error if it's canceled first. golang.org/x/time/rate's
+// *Limiter satisfies it.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// IntervalLimiter is a RateLimiter letting one request through per interval
+type IntervalLimiter struct {
+	ticker *time.Ticker
+}
+
+// NewIntervalLimiter creates a limiter allowing one request every interval.
+// Stop it when done to release the ticker.
+func NewIntervalLimiter(interval time.Duration) *IntervalLimiter {
+	return &IntervalLimiter{ticker: time.NewTicker(interval)}
+}
+
+// Wait blocks until the next tick or until ctx is canceled
+func (l *IntervalLimiter) Wait(ctx context.Context) error {
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop releases the limiter's ticker
+func (l *IntervalLimiter) Stop() {
+	l.ticker.Stop()
+}
+
+// crawlExpectedURLs sizes Crawl's visited filter
+const crawlExpectedURLs = 1_000_000
+
+// crawlResult is one fetched page's outcome, sent from a worker back to Crawl
+type crawlResult struct {
+	url   string
+	links []string
+	err   error
+}
+
+// Crawl visits every URL reachable from seeds. fetch downloads a page and returns
+// the links on it, which are queued unless a WebCrawlerCache has seen them, so each
+// URL is fetched at most once (a Bloom filter false positive can skip one, about
+// 1% at the expected size). Up to workers fetches run at once, each waiting on
+// rate first when it's non-nil. Only this goroutine touches the cache and the
+// queue, so they need no locking.
+// Canceling ctx stops the crawl: no new fetches start, and Crawl returns once the
+// running ones finish. Fetch errors and links that don't parse as URLs don't
+// stop the crawl; they are returned joined, after ctx's error if it was canceled.
+func Crawl(ctx context.Context, seeds []string, fetch func(string) ([]string, error), workers int, rate RateLimiter) error {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cache := NewWebCrawlerCache(crawlExpectedURLs)
+	var queue []string
+	var errs []error
+	enqueue := func(urls []string) {
+		for _, u := range urls {
+			visited, err := cache.HasVisited(u)
+			if err == nil && !visited {
+				err = cache.MarkVisited(u)
+			}
+			switch {
+			case err != nil:
+				errs = append(errs, fmt.Errorf("queuing %s: %w", u, err))
+			case !visited:
+				queue = append(queue, u)
+			}
+		}
+	}
+	enqueue(seeds)
+
+	jobs := make(chan string)
+	results := make(chan crawlResult)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range jobs {
+				res := crawlResult{url: u}
+				if rate != nil {
+					res.err = rate.Wait(ctx)
+				}
+				if res.err == nil {
+					res.links, res.err = fetch(u)
+				}
+				select {
+				case results <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	inFlight := 0
+	for (len(queue) > 0 || inFlight > 0) && ctx.Err() == nil {
+		// A nil channel never sends, so the job case is off while the queue is empty
+		var send chan string
+		var next string
+		if len(queue) > 0 {
+			send, next = jobs, queue[0]
+		}
+
+		select {
+		case send <- next:
+			queue = queue[1:]
+			inFlight++
+		case res := <-results:
+			inFlight--
+			if res.err != nil {
+				if ctx.Err() == nil {
+					errs = append(errs, fmt.Errorf("fetching %s: %w", res.url, res.err))
+				}
+				continue
+			}
+			enqueue(res.links)
+		case <-ctx.Done():
+		}
+	}
+
+	canceled := ctx.Err()
+	close(jobs)
+	cancel() // Unblocks workers waiting to report once we've stopped listening
+	wg.Wait()
+
+	if canceled != nil {
+		errs = append([]error{canceled}, errs...)
+	}
+	return errors.Join(errs...)
+}
+
 func main() {
 	// Create a cache expecting ~1 million URLs
 	cache := NewWebCrawlerCache(1_000_000)
@@ -156,4 +858,28 @@ func main() {
 			fmt.Printf("Skipping previously visited: %s\n", u)
 		}
 	}
+
+	// Crawl a small site concurrently, at most one request every 50ms
+	site := map[string][]string{
+		"https://example.com/":       {"https://example.com/about", "https://example.com/blog"},
+		"https://example.com/about":  {"https://example.com/"},
+		"https://example.com/blog":   {"https://example.com/blog/1", "https://example.com/blog/2", "https://example.com/About"},
+		"https://example.com/blog/1": {"https://example.com/blog"},
+		"https://example.com/blog/2": {"https://example.com/blog/1"},
+	}
+	var mu sync.Mutex
+	fetch := func(u string) ([]string, error) {
+		mu.Lock()
+		fmt.Println("Fetched:", u)
+		mu.Unlock()
+		return site[u], nil
+	}
+
+	limiter := NewIntervalLimiter(50 * time.Millisecond)
+	defer limiter.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Crawl(ctx, []string{"https://example.com/"}, fetch, 3, limiter); err != nil {
+		fmt.Println("Crawl stopped:", err)
+	}
 }
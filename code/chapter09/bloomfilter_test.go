@@ -0,0 +1,639 @@
+package main
+
+// Run with: go test bloomfilter.go bloomfilter_test.go
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// addRange adds the elements "tag-from" up to "tag-(to-1)"
+func addRange(bf *BloomFilter, tag string, from, to int) {
+	for i := from; i < to; i++ {
+		bf.AddString(fmt.Sprintf("%s-%d", tag, i))
+	}
+}
+
+// sharedKey builds filters that hash alike, so they can be combined
+var sharedKey = []byte("shared")
+
+func TestApproxDifferenceFalseNegatives(t *testing.T) {
+	a, _ := NewSeededBloomFilter(10000, 0.01, sharedKey)
+	b, _ := NewSeededBloomFilter(10000, 0.01, sharedKey)
+	addRange(a, "url", 0, 2000)
+	addRange(b, "url", 1000, 3000)
+
+	diff := a.ApproxDifference(b)
+	if diff == nil {
+		t.Fatal("ApproxDifference of compatible filters returned nil")
+	}
+
+	// URLs 0-999 are only in a; count how many the difference lost
+	missed := 0
+	for i := 0; i < 1000; i++ {
+		if !diff.ContainsString(fmt.Sprintf("url-%d", i)) {
+			missed++
+		}
+	}
+	rate := float64(missed) / 1000
+	bound := 1 - math.Pow(1-b.FillRatio(), float64(b.k))
+	if math.Abs(rate-bound) > 0.06 {
+		t.Errorf("false negative rate %.3f, documented estimate %.3f", rate, bound)
+	}
+	t.Logf("false negative rate %.3f, documented estimate %.3f", rate, bound)
+
+	// Elements of b never survive: all their bits are cleared
+	for i := 1000; i < 3000; i++ {
+		if diff.ContainsString(fmt.Sprintf("url-%d", i)) {
+			t.Fatalf("url-%d is in b but survived the difference", i)
+		}
+	}
+}
+
+func TestApproxDifferenceIncompatible(t *testing.T) {
+	a := NewBloomFilter(1000, 0.01)
+	if a.ApproxDifference(NewBloomFilter(5000, 0.01)) != nil {
+		t.Error("expected nil for filters of different sizes")
+	}
+	if a.ApproxDifference(NewBloomFilter(1000, 0.01)) != nil {
+		t.Error("expected nil for filters with different random keys")
+	}
+}
+
+func TestEstimateBloomMemoryMatchesAllocation(t *testing.T) {
+	params := []struct {
+		expected int
+		fpRate   float64
+	}{
+		{1, 0.5},
+		{100, 0.01},
+		{1000, 0.001},
+		{12345, 0.05},
+		{1000000, 0.0001},
+	}
+	for _, p := range params {
+		bits, bytes, k := EstimateBloomMemory(p.expected, p.fpRate)
+		bf := NewBloomFilter(p.expected, p.fpRate)
+		if bits != bf.size || k != bf.k || bytes != len(bf.bitset)*8 {
+			t.Errorf("EstimateBloomMemory(%d, %g) = %d bits, %d bytes, k=%d; filter has %d bits, %d bytes, k=%d",
+				p.expected, p.fpRate, bits, bytes, k, bf.size, len(bf.bitset)*8, bf.k)
+		}
+		if uint(bytes)*8 < bits {
+			t.Errorf("EstimateBloomMemory(%d, %g): %d bytes can't hold %d bits", p.expected, p.fpRate, bytes, bits)
+		}
+	}
+}
+
+func TestFrontierEnqueue(t *testing.T) {
+	f := NewFrontier(NewWebCrawlerCache(1000), 2)
+
+	enqueues := []struct {
+		url     string
+		depth   int
+		want    bool
+		wantErr bool
+	}{
+		{"https://example.com/", 0, true, false},
+		{"https://example.com/a", 1, true, false},
+		{"https://example.com/A/", 1, false, false}, // Same as /a once normalized
+		{"https://example.com/b", 2, true, false},
+		{"https://example.com/c", 3, false, false}, // Too deep
+		{"https://example.com/", 2, false, false},  // Already queued
+		{"://bad", 0, false, true},                 // Doesn't parse
+	}
+	for _, e := range enqueues {
+		got, err := f.Enqueue(e.url, e.depth)
+		if got != e.want || (err != nil) != e.wantErr {
+			t.Errorf("Enqueue(%q, %d) = %v, %v; want %v, error %v", e.url, e.depth, got, err, e.want, e.wantErr)
+		}
+	}
+
+	want := []frontierItem{{"https://example.com/", 0}, {"https://example.com/a", 1}, {"https://example.com/b", 2}}
+	for _, w := range want {
+		u, depth, ok := f.Next()
+		if !ok || u != w.URL || depth != w.Depth {
+			t.Fatalf("Next() = %q, %d, %v; want %q, %d, true", u, depth, ok, w.URL, w.Depth)
+		}
+	}
+	if _, _, ok := f.Next(); ok {
+		t.Error("Next returned a URL from an empty frontier")
+	}
+}
+
+func TestFrontierSaveLoad(t *testing.T) {
+	f := NewFrontier(NewWebCrawlerCache(1000), 5)
+	for _, u := range []string{"https://example.com/done", "https://example.com/x", "https://example.com/y"} {
+		if _, err := f.Enqueue(u, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Next() // /done has been crawled, only x and y are pending
+
+	var buf bytes.Buffer
+	if err := f.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewFrontier(NewWebCrawlerCache(1000), 5)
+	if err := restored.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Len() != 2 {
+		t.Errorf("restored queue has %d URLs, want 2", restored.Len())
+	}
+
+	// Every URL seen before the save is still deduplicated, crawled or pending
+	for _, u := range []string{"https://example.com/done", "https://example.com/x", "https://example.com/Y/"} {
+		if queued, err := restored.Enqueue(u, 1); queued || err != nil {
+			t.Errorf("Enqueue(%q) after Load = %v, %v; want it deduplicated", u, queued, err)
+		}
+	}
+	if queued, _ := restored.Enqueue("https://example.com/new", 1); !queued {
+		t.Error("a URL never seen before wasn't queued after Load")
+	}
+	if u, depth, ok := restored.Next(); !ok || u != "https://example.com/x" || depth != 1 {
+		t.Errorf("first restored URL = %q, %d, %v", u, depth, ok)
+	}
+
+	if err := restored.Load(strings.NewReader(`{"filter":"","queue":[]}`)); err == nil {
+		t.Error("Load accepted a state without a filter")
+	}
+}
+
+// batch returns n byte-slice elements "tag-0" up to "tag-(n-1)"
+func batch(tag string, n int) [][]byte {
+	items := make([][]byte, n)
+	for i := range items {
+		items[i] = []byte(fmt.Sprintf("%s-%d", tag, i))
+	}
+	return items
+}
+
+func TestContainsAllMatchesContains(t *testing.T) {
+	bf := NewBloomFilter(1000, 0.05)
+	addRange(bf, "in", 0, 1000)
+
+	// Members, plus enough non-members that some are false positives
+	items := append(batch("in", 1000), batch("out", 5000)...)
+	results := bf.ContainsAll(items)
+	positives := 0
+	for i, item := range items {
+		if results[i] != bf.Contains(item) {
+			t.Fatalf("ContainsAll[%d] = %v, Contains(%s) = %v", i, results[i], item, !results[i])
+		}
+		if i < 1000 && !results[i] {
+			t.Errorf("member %s not found", item)
+		}
+		if i >= 1000 && results[i] {
+			positives++
+		}
+	}
+	if rate := float64(positives) / 5000; rate > 0.08 {
+		t.Errorf("false positive rate %.3f, designed for 0.05", rate)
+	}
+}
+
+func BenchmarkContainsAll(b *testing.B) {
+	bf := NewBloomFilter(100000, 0.01)
+	addRange(bf, "in", 0, 100000)
+	items := append(batch("in", 500), batch("out", 500)...)
+
+	b.Run("ContainsAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bf.ContainsAll(items)
+		}
+	})
+	b.Run("ContainsLoop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			results := make([]bool, len(items))
+			for n, item := range items {
+				results[n] = bf.Contains(item)
+			}
+		}
+	})
+}
+
+func TestReadHeaderBadMagic(t *testing.T) {
+	bf := NewBloomFilter(100, 0.01)
+	data, _ := bf.MarshalBinary()
+	copy(data, "CMSK")
+
+	err := (&BloomFilter{}).UnmarshalBinary(data)
+	if err == nil || !strings.Contains(err.Error(), "bad magic") {
+		t.Errorf("err = %v, want a bad magic error", err)
+	}
+	if _, err := readHeader([]byte("BL"), bloomMagic, 1, nil); err == nil {
+		t.Error("a truncated header was accepted")
+	}
+}
+
+func TestReadHeaderMigratesOlderVersion(t *testing.T) {
+	magic := [4]byte{'T', 'E', 'S', 'T'}
+	// Version 1 stored a uint32 count; version 2 widened it to uint64
+	v1 := binary.LittleEndian.AppendUint32(writeHeader(nil, magic, 1), 42)
+	migrations := map[byte]migration{
+		1: func(payload []byte) ([]byte, error) {
+			return binary.LittleEndian.AppendUint64(nil, uint64(binary.LittleEndian.Uint32(payload))), nil
+		},
+	}
+
+	payload, err := readHeader(v1, magic, 2, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) != 8 || binary.LittleEndian.Uint64(payload) != 42 {
+		t.Errorf("migrated payload = %v, want 42 as a uint64", payload)
+	}
+
+	// Newer than the reader knows, or with no path forward, is an error
+	if _, err := readHeader(writeHeader(nil, magic, 3), magic, 2, migrations); err == nil {
+		t.Error("a version from the future was accepted")
+	}
+	if _, err := readHeader(v1, magic, 2, nil); err == nil {
+		t.Error("version 1 was accepted without a migration")
+	}
+}
+
+// bloomV1 is a filter saved in format version 1, before the hashing scheme was
+// recorded: NewSeededBloomFilter(10, 0.1, []byte("v1")) holding alpha, beta and gamma
+const bloomV1 = "424c4f4d01300000000000000003000000e1771af10040001810a40000"
+
+func TestUnmarshalMigratesVersion1(t *testing.T) {
+	data, err := hex.DecodeString(bloomV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored BloomFilter
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same bits answer the same way, still hashed with the saved murmur3 seed base
+	if restored.scheme != bloomMurmur3 || restored.seed != 4045043681 {
+		t.Errorf("migrated filter has scheme %d, seed %d; want murmur3 with the saved seed", restored.scheme, restored.seed)
+	}
+	for _, s := range []string{"alpha", "beta", "gamma"} {
+		if !restored.ContainsString(s) {
+			t.Errorf("%s was lost migrating from version 1", s)
+		}
+	}
+	for _, s := range []string{"delta", "epsilon", "zeta"} {
+		if restored.ContainsString(s) {
+			t.Errorf("%s wasn't in the version 1 filter but is found", s)
+		}
+	}
+
+	// Saving again writes the current version, which loads without migrating
+	data, _ = restored.MarshalBinary()
+	if data[4] != bloomVersion {
+		t.Errorf("re-saved filter has version %d, want %d", data[4], bloomVersion)
+	}
+	var again BloomFilter
+	if err := again.UnmarshalBinary(data); err != nil || !again.compatible(&restored) || !again.ContainsString("alpha") {
+		t.Errorf("re-saved filter didn't load the same: %v", err)
+	}
+
+	// A version 1 payload cut short fails in the migration, not with a panic
+	if err := (&BloomFilter{}).UnmarshalBinary(data[:headerSize+10]); err == nil {
+		t.Error("a truncated filter was accepted")
+	}
+	v1, _ := hex.DecodeString(bloomV1)
+	if err := (&BloomFilter{}).UnmarshalBinary(v1[:headerSize+10]); err == nil || !strings.Contains(err.Error(), "migrating") {
+		t.Errorf("truncated version 1 filter: err = %v, want a migration error", err)
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	a, _ := NewKeyedWebCrawlerCache(10000, sharedKey)
+	b, _ := NewKeyedWebCrawlerCache(10000, sharedKey)
+	for i := 0; i < 1000; i++ {
+		a.MarkVisited(fmt.Sprintf("https://example.com/page/%d", i))
+	}
+	for i := 500; i < 1500; i++ {
+		b.MarkVisited(fmt.Sprintf("https://example.com/page/%d", i))
+	}
+
+	// 500 shared of 1500 distinct URLs
+	if got, want := Overlap(a, b), 500.0/1500; math.Abs(got-want) > 0.03 {
+		t.Errorf("Overlap = %.3f, want about %.3f", got, want)
+	}
+	if got := Overlap(a, a); got != 1 {
+		t.Errorf("Overlap of a cache with itself = %v, want 1", got)
+	}
+	small, _ := NewKeyedWebCrawlerCache(500, sharedKey)
+	if got := Overlap(a, small); !math.IsNaN(got) {
+		t.Errorf("Overlap of differently sized caches = %v, want NaN", got)
+	}
+	if got := Overlap(NewWebCrawlerCache(10000), NewWebCrawlerCache(10000)); !math.IsNaN(got) {
+		t.Errorf("Overlap of caches with different random keys = %v, want NaN", got)
+	}
+}
+
+// positions returns the bits bf sets for data
+func positions(bf *BloomFilter, data []byte) []uint {
+	out := make([]uint, bf.k)
+	for i := range out {
+		out[i] = bf.getPosition(data, uint(i))
+	}
+	return out
+}
+
+func TestSipHash24Vectors(t *testing.T) {
+	// From the SipHash paper's reference implementation: key 00..0f, message 00..(n-1)
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	msg := make([]byte, 64)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	vectors := map[int]uint64{0: 0x726fdb47dd0e0e31, 1: 0x74f839c593dc67fd, 8: 0x93f5f5799a932462, 15: 0xa129ca6149be45e5, 63: 0x958a324ceb064572}
+	for n, want := range vectors {
+		if got := sipHash24(k0, k1, msg[:n]); got != want {
+			t.Errorf("sipHash24 of %d bytes = %#x, want %#x", n, got, want)
+		}
+	}
+}
+
+func TestSeededFiltersPlaceElementsDifferently(t *testing.T) {
+	a, err := NewSeededBloomFilter(1000, 0.01, []byte("key-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewSeededBloomFilter(1000, 0.01, []byte("key-b"))
+	random, err := NewSeededBloomFilter(1000, 0.01, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := NewSeededBloomFilter(1000, 0.01, []byte("key-a"))
+
+	elem := []byte("https://example.com/")
+	if fmt.Sprint(positions(a, elem)) == fmt.Sprint(positions(b, elem)) {
+		t.Error("filters with different keys set the same bits")
+	}
+	if a.key == random.key {
+		t.Error("a random key matched a derived one")
+	}
+	if NewBloomFilter(1000, 0.01).key == NewBloomFilter(1000, 0.01).key {
+		t.Error("two default filters got the same key")
+	}
+	if NewWebCrawlerCache(1000).filter.key == NewWebCrawlerCache(1000).filter.key {
+		t.Error("two default crawler caches got the same key")
+	}
+	if fmt.Sprint(positions(a, elem)) != fmt.Sprint(positions(again, elem)) {
+		t.Error("the same key gave different positions")
+	}
+
+	// Seeding doesn't change accuracy, and only same-seed filters combine
+	a.Add(elem)
+	if !a.Contains(elem) {
+		t.Error("seeded filter lost an element")
+	}
+	if err := a.Union(b); err == nil {
+		t.Error("Union accepted filters with different keys")
+	}
+}
+
+func TestStringAndByteAPIsAgree(t *testing.T) {
+	byStr, _ := NewSeededBloomFilter(1000, 0.01, sharedKey)
+	byBytes, _ := NewSeededBloomFilter(1000, 0.01, sharedKey)
+	for i := 0; i < 500; i++ {
+		s := fmt.Sprint("elem-", i)
+		byStr.AddString(s)
+		byBytes.Add([]byte(s))
+	}
+	for i := range byStr.bitset {
+		if byStr.bitset[i] != byBytes.bitset[i] {
+			t.Fatalf("AddString and Add set different bits in word %d", i)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		s := fmt.Sprint("elem-", i)
+		if byStr.ContainsString(s) != byStr.Contains([]byte(s)) {
+			t.Errorf("ContainsString(%q) and Contains disagree", s)
+		}
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	bf, _ := NewSeededBloomFilter(2000, 0.01, []byte("key"))
+	addRange(bf, "in", 0, 1000)
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var restored BloomFilter
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.compatible(bf) {
+		t.Error("the restored filter lost its key")
+	}
+	for i := 0; i < 3000; i++ {
+		s := fmt.Sprint("in-", i)
+		if bf.ContainsString(s) != restored.ContainsString(s) {
+			t.Fatalf("membership of %s changed across the round trip", s)
+		}
+	}
+
+	// A bitset that doesn't match the encoded size is rejected
+	if err := restored.UnmarshalBinary(data[:len(data)-8]); err == nil || !strings.Contains(err.Error(), "bitset") {
+		t.Errorf("truncated bitset: err = %v, want a bitset length error", err)
+	}
+}
+
+func TestUnionIsLogicalOr(t *testing.T) {
+	a, _ := NewSeededBloomFilter(2000, 0.01, sharedKey)
+	b, _ := NewSeededBloomFilter(2000, 0.01, sharedKey)
+	addRange(a, "a", 0, 1000)
+	addRange(b, "b", 0, 1000)
+
+	merged := a.Clone()
+	if err := merged.Union(b); err != nil {
+		t.Fatal(err)
+	}
+	// Merging is the same as inserting both sets into one filter
+	both, _ := NewSeededBloomFilter(2000, 0.01, sharedKey)
+	addRange(both, "a", 0, 1000)
+	addRange(both, "b", 0, 1000)
+	for _, tag := range []string{"a", "b", "c"} {
+		for i := 0; i < 1000; i++ {
+			s := fmt.Sprintf("%s-%d", tag, i)
+			if got, want := merged.ContainsString(s), both.ContainsString(s); got != want {
+				t.Fatalf("merged.Contains(%s) = %v, want %v", s, got, want)
+			}
+		}
+	}
+
+	// The union leaves the original untouched
+	missing := 0
+	for i := 0; i < 1000; i++ {
+		if !a.ContainsString(fmt.Sprint("b-", i)) {
+			missing++
+		}
+	}
+	if missing < 900 {
+		t.Errorf("original reports %d of b's 1000 elements, the union wrote through the clone", 1000-missing)
+	}
+
+	if err := a.Union(NewBloomFilter(50, 0.01)); err == nil {
+		t.Error("union of differently sized filters succeeded, want an error")
+	}
+}
+
+func TestEstimatedCount(t *testing.T) {
+	bf := NewBloomFilter(20000, 0.01)
+	if bf.EstimatedCount() != 0 || bf.FillRatio() != 0 {
+		t.Fatalf("empty filter: count %d, fill %v", bf.EstimatedCount(), bf.FillRatio())
+	}
+
+	inserted := 0
+	for _, n := range []int{500, 5000, 20000} {
+		addRange(bf, "item", inserted, n)
+		inserted = n
+		bf.AddString("item-0") // Duplicates don't set new bits
+		est := float64(bf.EstimatedCount())
+		if rel := math.Abs(est-float64(n)) / float64(n); rel > 0.03 {
+			t.Errorf("after %d inserts: estimate %.0f, relative error %.3f", n, est, rel)
+		}
+	}
+
+	// At design capacity an optimally sized filter is about half full
+	if fill := bf.FillRatio(); math.Abs(fill-0.5) > 0.02 {
+		t.Errorf("fill ratio at capacity = %.3f, want about 0.5", fill)
+	}
+	for i := uint(0); i < bf.size; i++ {
+		bf.bitset[i/64] |= 1 << (i % 64)
+	}
+	if bf.EstimatedCount() != math.MaxUint64 || bf.FillRatio() != 1 {
+		t.Errorf("saturated filter: count %d, fill %v", bf.EstimatedCount(), bf.FillRatio())
+	}
+}
+
+func TestScalableBloomFilterGrows(t *testing.T) {
+	const errorRate = 0.01
+	sbf := NewScalableBloomFilter(100, errorRate)
+	for i := 0; i < 20000; i++ {
+		sbf.Add([]byte(fmt.Sprint("in-", i)))
+	}
+	if sbf.Slices() < 5 {
+		t.Errorf("%d slices after 200x the initial capacity, want growth", sbf.Slices())
+	}
+	var bits uint
+	for i, f := range sbf.filters {
+		if i > 0 && f.size <= sbf.filters[i-1].size {
+			t.Errorf("slice %d has %d bits, no larger than the %d before it", i, f.size, sbf.filters[i-1].size)
+		}
+		bits += f.size
+	}
+	if sbf.Bits() != bits {
+		t.Errorf("Bits() = %d, slices add up to %d", sbf.Bits(), bits)
+	}
+
+	for i := 0; i < 20000; i++ {
+		if !sbf.Contains([]byte(fmt.Sprint("in-", i))) {
+			t.Fatalf("in-%d was added but isn't found", i)
+		}
+	}
+	positives := 0
+	for i := 0; i < 50000; i++ {
+		if sbf.Contains([]byte(fmt.Sprint("out-", i))) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / 50000; rate > errorRate {
+		t.Errorf("compound false positive rate %.4f, want below %.2f", rate, errorRate)
+	}
+}
+
+func TestCrawlVisitsEachPageOnce(t *testing.T) {
+	site := map[string][]string{
+		"https://example.com/":       {"https://example.com/about", "https://example.com/blog"},
+		"https://example.com/about":  {"https://example.com/", "://bad"},
+		"https://example.com/blog":   {"https://example.com/blog/1", "https://example.com/blog/2", "https://example.com/About"},
+		"https://example.com/blog/1": {"https://example.com/blog"},
+		"https://example.com/blog/2": {"https://example.com/blog/1", "https://example.com/missing"},
+		"https://example.com/orphan": {"https://example.com/"}, // Nothing links here
+	}
+	var mu sync.Mutex
+	fetched := make(map[string]int)
+	fetch := func(u string) ([]string, error) {
+		mu.Lock()
+		fetched[u]++
+		mu.Unlock()
+		links, ok := site[u]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return links, nil
+	}
+
+	rate := NewIntervalLimiter(time.Millisecond)
+	defer rate.Stop()
+	err := Crawl(context.Background(), []string{"https://example.com/"}, fetch, 3, rate)
+
+	want := []string{
+		"https://example.com/", "https://example.com/about", "https://example.com/blog",
+		"https://example.com/blog/1", "https://example.com/blog/2", "https://example.com/missing",
+	}
+	if len(fetched) != len(want) {
+		t.Errorf("fetched %d URLs, want %d: %v", len(fetched), len(want), fetched)
+	}
+	for _, u := range want {
+		if fetched[u] != 1 {
+			t.Errorf("%s fetched %d times, want once", u, fetched[u])
+		}
+	}
+
+	// The missing page and the bad link are reported, but didn't stop the crawl
+	if err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("Crawl() = %v, want only the fetch and parse errors", err)
+	}
+	for _, s := range []string{"fetching https://example.com/missing", "queuing ://bad"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("Crawl error %q doesn't mention %q", err, s)
+		}
+	}
+}
+
+func TestCrawlCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// An endless site: every page links to the next two
+	const stopAfter, workers = 20, 4
+	var fetches atomic.Int64
+	fetch := func(u string) ([]string, error) {
+		if fetches.Add(1) == stopAfter {
+			cancel()
+		}
+		var n int
+		fmt.Sscanf(u, "https://example.com/%d", &n)
+		return []string{fmt.Sprint("https://example.com/", n+1), fmt.Sprint("https://example.com/", n+2)}, nil
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- Crawl(ctx, []string{"https://example.com/0"}, fetch, workers, nil) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Crawl() = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Crawl still running 2s after its context was canceled")
+	}
+	// Fetches already handed to workers may finish, but no new ones start
+	if n := fetches.Load(); n > stopAfter+workers {
+		t.Errorf("%d fetches after canceling at %d, want at most %d", n, stopAfter, stopAfter+workers)
+	}
+}
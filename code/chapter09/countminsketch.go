@@ -1,19 +1,23 @@
 package main
 
 import (
+	"container/heap"
 	"fmt"
 	"math"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/spaolacci/murmur3"
 )
 
 // CountMinSketch represents a Count-Min Sketch data structure
 type CountMinSketch struct {
-	matrix [][]uint32
-	width  uint
-	depth  uint
+	matrix     [][]uint32
+	width      uint
+	depth      uint
+	total      uint64      // sum of all increments
+	candidates *hitterHeap // likeliest heavy hitters, by estimate; nil unless tracked
 }
 
 // New creates a new Count-Min Sketch with the specified error parameters
@@ -36,11 +40,56 @@ func NewCountMinSketch(epsilon, delta float64) *CountMinSketch {
 	}
 }
 
+// NewCountMinSketchWithHeavyHitters creates a sketch that also remembers the
+// candidates keys with the highest estimates, for HeavyHitters. Tracking costs a
+// map lookup and a heap update on every increment, so plain sketches skip it.
+func NewCountMinSketchWithHeavyHitters(epsilon, delta float64, candidates int) *CountMinSketch {
+	cms := NewCountMinSketch(epsilon, delta)
+	cms.candidates = newHitterHeap(max(candidates, 1))
+	return cms
+}
+
 // Increment adds a count for the given data
 func (cms *CountMinSketch) Increment(data []byte, count uint32) {
+	var min uint32 = math.MaxUint32
 	for i := uint(0); i < cms.depth; i++ {
 		position := cms.getPosition(data, i)
 		cms.matrix[i][position] += count
+		if cms.matrix[i][position] < min {
+			min = cms.matrix[i][position]
+		}
+	}
+	cms.total += uint64(count)
+	if cms.candidates != nil {
+		cms.candidates.offer(data, min)
+	}
+}
+
+// IncrementConservative adds a count using conservative update: every counter
+// is raised only as far as the item's new estimate (its current minimum plus count).
+// Counters already above that were inflated by collisions and are left alone, which
+// cuts over-estimation a lot for skewed streams. Estimates still never undercount,
+// but a sketch updated this way no longer holds plain sums, so don't mix it with
+// Increment on the same sketch if you rely on that.
+func (cms *CountMinSketch) IncrementConservative(data []byte, count uint32) {
+	positions := make([]uint, cms.depth)
+	var min uint32 = math.MaxUint32
+	for i := uint(0); i < cms.depth; i++ {
+		positions[i] = cms.getPosition(data, i)
+		if cms.matrix[i][positions[i]] < min {
+			min = cms.matrix[i][positions[i]]
+		}
+	}
+
+	target := min + count
+	for i, position := range positions {
+		if cms.matrix[i][position] < target {
+			cms.matrix[i][position] = target
+		}
+	}
+	cms.total += uint64(count)
+	if cms.candidates != nil {
+		cms.candidates.offer(data, target)
 	}
 }
 
@@ -58,12 +107,450 @@ func (cms *CountMinSketch) Count(data []byte) uint32 {
 	return min
 }
 
+// EstimateCMM estimates the count with the Count-Mean-Min estimator, which corrects
+// for collision noise: each row's cell has the row's average noise, the mean of its
+// other cells, subtracted, and the median of those de-biased values is returned,
+// capped by Count. This is much closer than Count for rare keys, but can undercount.
+// It relies on each row summing to TotalCount, so it doesn't apply to sketches
+// filled with IncrementConservative.
+func (cms *CountMinSketch) EstimateCMM(data []byte) uint32 {
+	if cms.width < 2 {
+		return cms.Count(data) // No other cells to estimate noise from
+	}
+
+	estimates := make([]float64, cms.depth)
+	for i := uint(0); i < cms.depth; i++ {
+		cell := float64(cms.matrix[i][cms.getPosition(data, i)])
+		noise := (float64(cms.total) - cell) / float64(cms.width-1)
+		estimates[i] = cell - noise
+	}
+	sort.Float64s(estimates)
+
+	var median float64
+	if mid := len(estimates) / 2; len(estimates)%2 == 1 {
+		median = estimates[mid]
+	} else {
+		median = (estimates[mid-1] + estimates[mid]) / 2
+	}
+
+	if median <= 0 {
+		return 0
+	}
+	if count := cms.Count(data); float64(count) < median {
+		return count
+	}
+	return uint32(math.Round(median))
+}
+
+// Merge adds other's counters into cms, so cms then estimates counts over both
+// streams with the usual error bound for the combined total.
+// Both sketches must have the same width and depth.
+func (cms *CountMinSketch) Merge(other *CountMinSketch) error {
+	if cms.width != other.width || cms.depth != other.depth {
+		return fmt.Errorf("cannot merge count-min sketches: %dx%d vs %dx%d (width x depth)",
+			cms.width, cms.depth, other.width, other.depth)
+	}
+	for i := range cms.matrix {
+		for j := range cms.matrix[i] {
+			cms.matrix[i][j] += other.matrix[i][j]
+		}
+	}
+	cms.total += other.total
+	if cms.candidates == nil {
+		return nil
+	}
+
+	// Candidates from either side may now rank differently, so re-offer them all
+	var keys []string
+	for _, h := range cms.candidates.items {
+		keys = append(keys, h.Key)
+	}
+	if other.candidates != nil {
+		for _, h := range other.candidates.items {
+			keys = append(keys, h.Key)
+		}
+	}
+	for _, key := range keys {
+		cms.candidates.offer([]byte(key), cms.Count([]byte(key)))
+	}
+	return nil
+}
+
+// Clone returns an independent copy of the sketch
+func (cms *CountMinSketch) Clone() *CountMinSketch {
+	matrix := make([][]uint32, cms.depth)
+	for i := range matrix {
+		matrix[i] = make([]uint32, cms.width)
+		copy(matrix[i], cms.matrix[i])
+	}
+	clone := &CountMinSketch{
+		matrix: matrix,
+		width:  cms.width,
+		depth:  cms.depth,
+		total:  cms.total,
+	}
+	if cms.candidates != nil {
+		clone.candidates = cms.candidates.clone()
+	}
+	return clone
+}
+
+// TotalCount returns the sum of all counts added to the sketch
+func (cms *CountMinSketch) TotalCount() uint64 {
+	return cms.total
+}
+
+// HeavyHitter pairs a key with its estimated count
+type HeavyHitter struct {
+	Key   string
+	Count uint32
+}
+
+// HeavyHitters returns the keys whose estimated count is at least fraction of
+// TotalCount, highest first. Only the sketch's candidate keys with the highest
+// estimates are remembered, so use fractions well above 1/candidates. A sketch
+// created without heavy hitter tracking returns nil.
+func (cms *CountMinSketch) HeavyHitters(fraction float64) []HeavyHitter {
+	if cms.candidates == nil {
+		return nil
+	}
+	cutoff := fraction * float64(cms.total)
+	var result []HeavyHitter
+	for _, h := range cms.candidates.items {
+		if float64(h.Count) >= cutoff {
+			result = append(result, h)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// hitterHeap is a bounded min-heap of heavy hitter candidates, with an index
+// so a key already in the heap can have its count updated in place
+type hitterHeap struct {
+	items    []HeavyHitter
+	index    map[string]int
+	capacity int
+}
+
+func newHitterHeap(capacity int) *hitterHeap {
+	return &hitterHeap{index: make(map[string]int), capacity: capacity}
+}
+
+func (h *hitterHeap) Len() int           { return len(h.items) }
+func (h *hitterHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+func (h *hitterHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].Key] = i
+	h.index[h.items[j].Key] = j
+}
+func (h *hitterHeap) Push(x any) {
+	item := x.(HeavyHitter)
+	h.index[item.Key] = len(h.items)
+	h.items = append(h.items, item)
+}
+func (h *hitterHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, item.Key)
+	return item
+}
+
+// offer records key's latest estimate, evicting the smallest candidate if key
+// beats it and the heap is full
+func (h *hitterHeap) offer(key []byte, count uint32) {
+	if i, ok := h.index[string(key)]; ok {
+		h.items[i].Count = count
+		heap.Fix(h, i)
+		return
+	}
+	if len(h.items) < h.capacity {
+		heap.Push(h, HeavyHitter{Key: string(key), Count: count})
+		return
+	}
+	if count > h.items[0].Count {
+		delete(h.index, h.items[0].Key)
+		h.items[0] = HeavyHitter{Key: string(key), Count: count}
+		h.index[h.items[0].Key] = 0
+		heap.Fix(h, 0)
+	}
+}
+
+// scale multiplies every candidate's count by factor. Scaling all of them alike
+// keeps their order, so the heap needs no fixing.
+func (h *hitterHeap) scale(factor float64) {
+	for i := range h.items {
+		h.items[i].Count = uint32(float64(h.items[i].Count) * factor)
+	}
+}
+
+func (h *hitterHeap) clone() *hitterHeap {
+	c := newHitterHeap(h.capacity)
+	c.items = append([]HeavyHitter(nil), h.items...)
+	for key, i := range h.index {
+		c.index[key] = i
+	}
+	return c
+}
+
 // getPosition calculates the array position for a given element and hash function
 func (cms *CountMinSketch) getPosition(data []byte, hashNum uint) uint {
 	hash := murmur3.Sum64WithSeed(data, uint32(hashNum))
 	return uint(hash % uint64(cms.width))
 }
 
+// DecayingCountMinSketch weights recent events over old ones: every call to Decay
+// scales all counters by a factor, so a count seen n decays ago weighs factor^n.
+// Call Decay on a ticker for a rolling "trending now" view.
+//
+// With uint32 cells each Decay truncates, losing up to one count per cell per call,
+// so small counts fade to zero faster than they should and the error adds up over
+// many decays. Float cells keep the exact decayed weights at twice the memory.
+type DecayingCountMinSketch struct {
+	sketch *CountMinSketch // uint32 cells, and the hashing for both modes
+	cells  [][]float64     // float64 cells, nil unless requested
+}
+
+// NewDecayingCountMinSketch creates a decaying sketch, storing float64 cells if floatCells is set
+func NewDecayingCountMinSketch(epsilon, delta float64, floatCells bool) *DecayingCountMinSketch {
+	d := &DecayingCountMinSketch{sketch: NewCountMinSketch(epsilon, delta)}
+	if floatCells {
+		d.cells = make([][]float64, d.sketch.depth)
+		for i := range d.cells {
+			d.cells[i] = make([]float64, d.sketch.width)
+		}
+	}
+	return d
+}
+
+// Increment adds a count for the given data
+func (d *DecayingCountMinSketch) Increment(data []byte, count uint32) {
+	if d.cells == nil {
+		d.sketch.Increment(data, count)
+		return
+	}
+	for i := uint(0); i < d.sketch.depth; i++ {
+		d.cells[i][d.sketch.getPosition(data, i)] += float64(count)
+	}
+	d.sketch.total += uint64(count)
+}
+
+// Decay multiplies every counter by factor, which should be in (0, 1).
+// The total, and any heavy hitter candidates, decay with them, so fractions
+// of TotalCount stay comparable to decayed estimates.
+func (d *DecayingCountMinSketch) Decay(factor float64) {
+	d.sketch.total = uint64(float64(d.sketch.total) * factor)
+	if d.sketch.candidates != nil {
+		d.sketch.candidates.scale(factor)
+	}
+	if d.cells == nil {
+		for _, row := range d.sketch.matrix {
+			for j := range row {
+				row[j] = uint32(float64(row[j]) * factor)
+			}
+		}
+		return
+	}
+	for _, row := range d.cells {
+		for j := range row {
+			row[j] *= factor
+		}
+	}
+}
+
+// TotalCount returns the decayed sum of all counts added
+func (d *DecayingCountMinSketch) TotalCount() uint64 {
+	return d.sketch.total
+}
+
+// Count estimates the decayed count for the given data, the minimum across rows
+func (d *DecayingCountMinSketch) Count(data []byte) float64 {
+	if d.cells == nil {
+		return float64(d.sketch.Count(data))
+	}
+
+	min := math.Inf(1)
+	for i := uint(0); i < d.sketch.depth; i++ {
+		if c := d.cells[i][d.sketch.getPosition(data, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// GrowableCMS starts with a small sketch and adds a wider one whenever the
+// current sketch's expected error (e/width * items counted in it) would pass
+// maxError. Growth is additive: old counts stay in the old sketches, new
+// increments go to the newest, and Count sums the estimates of all of them.
+// Each sketch is twice as wide as the last, so growth stops at maxSketches;
+// after that the newest sketch keeps counting and its error passes maxError.
+type GrowableCMS struct {
+	sketches    []*CountMinSketch
+	epsilon     float64 // epsilon of the newest sketch
+	delta       float64
+	total       uint64 // items counted in the newest sketch
+	maxError    float64
+	maxSketches int
+}
+
+// NewGrowableCMS creates a growable sketch starting at epsilon, keeping each
+// sketch's expected overcount under maxError with at most maxSketches sketches
+func NewGrowableCMS(epsilon, delta float64, maxError uint32, maxSketches int) *GrowableCMS {
+	return &GrowableCMS{
+		sketches:    []*CountMinSketch{NewCountMinSketch(epsilon, delta)},
+		epsilon:     epsilon,
+		delta:       delta,
+		maxError:    float64(maxError),
+		maxSketches: max(maxSketches, 1),
+	}
+}
+
+// Increment adds a count to the newest sketch, growing first if it's saturated.
+// An empty sketch never grows: it holds no error yet, and replacing it would
+// only leave an unused sketch behind.
+func (g *GrowableCMS) Increment(data []byte, count uint32) {
+	current := g.sketches[len(g.sketches)-1]
+	expected := float64(g.total+uint64(count)) * math.E / float64(current.width)
+	if expected > g.maxError && g.total > 0 && len(g.sketches) < g.maxSketches {
+		// Twice as wide halves the error per counted item
+		g.epsilon /= 2
+		current = NewCountMinSketch(g.epsilon, g.delta)
+		g.sketches = append(g.sketches, current)
+		g.total = 0
+	}
+
+	current.Increment(data, count)
+	g.total += uint64(count)
+}
+
+// Count estimates the count for the given data across all sketches
+func (g *GrowableCMS) Count(data []byte) uint32 {
+	var total uint32
+	for _, sketch := range g.sketches {
+		total += sketch.Count(data)
+	}
+	return total
+}
+
+// Sketches returns how many sketches have been allocated so far
+func (g *GrowableCMS) Sketches() int {
+	return len(g.sketches)
+}
+
+// RingBuffer is a fixed-capacity circular buffer. Once full, Push overwrites
+// the oldest element, which makes it a natural fit for sliding windows.
+type RingBuffer[T any] struct {
+	items []T
+	start int // index of the oldest element
+	size  int
+}
+
+// NewRingBuffer creates a ring buffer holding at most capacity elements.
+// capacity must be positive.
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	if capacity < 1 {
+		panic(fmt.Sprintf("ringbuffer: capacity %d must be positive", capacity))
+	}
+	return &RingBuffer[T]{items: make([]T, capacity)}
+}
+
+// Push appends v, overwriting the oldest element when the buffer is full
+func (rb *RingBuffer[T]) Push(v T) {
+	if rb.size < len(rb.items) {
+		rb.items[(rb.start+rb.size)%len(rb.items)] = v
+		rb.size++
+		return
+	}
+	rb.items[rb.start] = v
+	rb.start = (rb.start + 1) % len(rb.items)
+}
+
+// Len returns the number of stored elements
+func (rb *RingBuffer[T]) Len() int {
+	return rb.size
+}
+
+// Cap returns the maximum number of elements
+func (rb *RingBuffer[T]) Cap() int {
+	return len(rb.items)
+}
+
+// ForEach calls fn on every element from oldest to newest
+func (rb *RingBuffer[T]) ForEach(fn func(T)) {
+	for i := 0; i < rb.size; i++ {
+		fn(rb.items[(rb.start+i)%len(rb.items)])
+	}
+}
+
+// SlidingCMS answers "how many in the last N minutes" by keeping a ring of
+// per-bucket sketches. Each bucket covers bucketSize of wall time; once the
+// ring is full, starting a new bucket drops the oldest so old counts roll off.
+type SlidingCMS struct {
+	buckets    *RingBuffer[*slidingBucket]
+	current    *slidingBucket
+	bucketSize time.Duration
+	epsilon    float64
+	delta      float64
+	now        func() time.Time // Swappable for tests
+}
+
+// slidingBucket is the sketch for one bucketSize slice of time
+type slidingBucket struct {
+	epoch  int64
+	sketch *CountMinSketch
+}
+
+// NewSlidingCMS creates a sliding sketch covering numBuckets * bucketSize of history.
+// bucketSize and numBuckets must be positive.
+func NewSlidingCMS(epsilon, delta float64, bucketSize time.Duration, numBuckets int) *SlidingCMS {
+	if bucketSize <= 0 || numBuckets < 1 {
+		panic(fmt.Sprintf("slidingcms: need a positive bucket size and count, got %v x %d", bucketSize, numBuckets))
+	}
+	return &SlidingCMS{
+		buckets:    NewRingBuffer[*slidingBucket](numBuckets),
+		bucketSize: bucketSize,
+		epsilon:    epsilon,
+		delta:      delta,
+		now:        time.Now,
+	}
+}
+
+// currentEpoch returns the index of the time bucket we're in
+func (s *SlidingCMS) currentEpoch() int64 {
+	return s.now().UnixNano() / int64(s.bucketSize)
+}
+
+// Increment adds a count for the given data to the current bucket
+func (s *SlidingCMS) Increment(data []byte, count uint32) {
+	epoch := s.currentEpoch()
+	if s.current == nil || s.current.epoch != epoch {
+		s.current = &slidingBucket{epoch: epoch, sketch: NewCountMinSketch(s.epsilon, s.delta)}
+		s.buckets.Push(s.current)
+	}
+
+	s.current.sketch.Increment(data, count)
+}
+
+// CountWindow estimates the count for data over the trailing window,
+// rounded up to whole buckets and capped at the history the ring holds
+func (s *SlidingCMS) CountWindow(data []byte, window time.Duration) uint32 {
+	epoch := s.currentEpoch()
+	oldest := epoch - int64((window+s.bucketSize-1)/s.bucketSize) + 1
+
+	var total uint32
+	s.buckets.ForEach(func(b *slidingBucket) {
+		if b.epoch >= oldest && b.epoch <= epoch {
+			total += b.sketch.Count(data)
+		}
+	})
+	return total
+}
+
 // Let's create a simple analytics tracker using Count-Min Sketch
 // This will track search query frequencies and identify trending terms
 // The threshold for heavy hitters is set to 5, meaning any term with a count of 5 or more
@@ -73,57 +560,131 @@ func (cms *CountMinSketch) getPosition(data []byte, hashNum uint) uint {
 
 // SearchAnalytics tracks search query frequencies
 type SearchAnalytics struct {
-	sketch       *CountMinSketch
+	sketch       *DecayingCountMinSketch
 	heavyHitters map[string]uint32 // Store actual counts for potential heavy hitters
 	threshold    uint32
+	lossy        *LossyCounter // Set instead of sketch in lossy counting mode
+
+	// Normalizer maps a raw query to the key it's counted under, so queries that
+	// mean the same thing share a counter. Defaults to NormalizeQuery.
+	Normalizer func(string) string
+}
+
+// NormalizeQuery lowercases a query and collapses runs of whitespace to a single
+// space, trimming both ends, so "Go  programming " counts as "go programming"
+func NormalizeQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
 }
 
 // NewSearchAnalytics creates a new analytics tracker
 func NewSearchAnalytics(errorRate, confidence float64, threshold uint32) *SearchAnalytics {
 	return &SearchAnalytics{
-		sketch:       NewCountMinSketch(errorRate, 1-confidence),
+		sketch:       NewDecayingCountMinSketch(errorRate, 1-confidence, false),
 		heavyHitters: make(map[string]uint32),
 		threshold:    threshold,
+		Normalizer:   NormalizeQuery,
 	}
 }
 
+// NewLossySearchAnalytics creates a tracker using Lossy Counting instead of a sketch.
+// A term is trending once it makes up at least support of all queries, and counts
+// are underestimated by at most errorBound * total queries.
+//
+// Compared with the CMS + map approach: the CMS needs a fixed e/ε × ln(1/δ) counters
+// but can overestimate, so terms near the threshold may be reported wrongly, and
+// the exact map grows with every term that ever crossed it. Lossy Counting keeps
+// at most (1/errorBound) * log(errorBound * N) entries, never overestimates and
+// never misses a term above support, at the cost of memory growing (slowly) with N.
+func NewLossySearchAnalytics(support, errorBound float64) *SearchAnalytics {
+	return &SearchAnalytics{lossy: NewLossyCounter(support, errorBound), Normalizer: NormalizeQuery}
+}
+
 // RecordQuery records a search query
 func (sa *SearchAnalytics) RecordQuery(query string) {
 	// Normalize the query
-	query = strings.ToLower(strings.TrimSpace(query))
+	normalize := sa.Normalizer
+	if normalize == nil {
+		normalize = NormalizeQuery
+	}
+	query = normalize(query)
 
 	// Skip empty queries
 	if query == "" {
 		return
 	}
 
+	if sa.lossy != nil {
+		sa.lossy.Add(query)
+		return
+	}
+
 	// Update the sketch
 	sa.sketch.Increment([]byte(query), 1)
 
 	// Check if this might be a heavy hitter
-	count := sa.sketch.Count([]byte(query))
+	count := uint32(sa.sketch.Count([]byte(query)))
 	if count >= sa.threshold {
 		// Keep exact count for potential heavy hitters
 		sa.heavyHitters[query] = count
 	}
 }
 
+// Decay multiplies every count in the sketch by factor, which should be in (0, 1),
+// so old queries stop looking like trends. Call Prune afterwards to drop the terms
+// that fell below the threshold. It does nothing in lossy counting mode.
+func (sa *SearchAnalytics) Decay(factor float64) {
+	if sa.lossy != nil {
+		return
+	}
+	sa.sketch.Decay(factor)
+}
+
+// Prune drops heavy hitters whose current sketch estimate fell below the threshold
+// (e.g. after Decay) and refreshes the counts of the rest.
+// Run it periodically on long-lived streams to keep the exact map bounded.
+// It returns the number of terms removed.
+func (sa *SearchAnalytics) Prune() int {
+	if sa.lossy != nil {
+		return 0 // Lossy Counting prunes itself at every bucket boundary
+	}
+
+	removed := 0
+	for query := range sa.heavyHitters {
+		count := uint32(sa.sketch.Count([]byte(query)))
+		if count < sa.threshold {
+			delete(sa.heavyHitters, query)
+			removed++
+			continue
+		}
+		sa.heavyHitters[query] = count
+	}
+	return removed
+}
+
 // GetTrendingTerms returns the top N trending search terms
 func (sa *SearchAnalytics) GetTrendingTerms(n int) []string {
 	type queryCount struct {
 		query string
-		count uint32
+		count uint64
 	}
 
 	// Convert map to slice for sorting
 	counts := make([]queryCount, 0, len(sa.heavyHitters))
+	if sa.lossy != nil {
+		for query, count := range sa.lossy.HeavyHitters() {
+			counts = append(counts, queryCount{query, count})
+		}
+	}
 	for query, count := range sa.heavyHitters {
-		counts = append(counts, queryCount{query, count})
+		counts = append(counts, queryCount{query, uint64(count)})
 	}
 
-	// Sort by count (descending)
+	// Sort by count (descending), ties alphabetically so output is stable
 	sort.Slice(counts, func(i, j int) bool {
-		return counts[i].count > counts[j].count
+		if counts[i].count != counts[j].count {
+			return counts[i].count > counts[j].count
+		}
+		return counts[i].query < counts[j].query
 	})
 
 	// Take top N
@@ -135,6 +696,157 @@ func (sa *SearchAnalytics) GetTrendingTerms(n int) []string {
 	return result
 }
 
+// LossyCounter implements Manku-Motwani Lossy Counting. The stream is split into
+// buckets of 1/errorBound items; at each bucket boundary, entries that can't be
+// frequent are dropped, bounding memory while undercounting by at most errorBound * N.
+type LossyCounter struct {
+	entries    map[string]*lossyEntry
+	support    float64
+	errorBound float64
+	width      uint64 // bucket width, ceil(1/errorBound)
+	total      uint64
+}
+
+type lossyEntry struct {
+	count uint64
+	delta uint64 // maximum undercount when the entry was created
+}
+
+// NewLossyCounter creates a counter reporting items above support of the stream.
+// errorBound must be positive and below support, otherwise nothing separates
+// frequent items from the ones the counter is allowed to forget.
+func NewLossyCounter(support, errorBound float64) *LossyCounter {
+	if errorBound <= 0 || errorBound >= support {
+		panic(fmt.Sprintf("lossycounter: error bound %v must be in (0, support %v)", errorBound, support))
+	}
+	return &LossyCounter{
+		entries:    make(map[string]*lossyEntry),
+		support:    support,
+		errorBound: errorBound,
+		width:      uint64(math.Ceil(1 / errorBound)),
+	}
+}
+
+// Add counts one occurrence of item
+func (lc *LossyCounter) Add(item string) {
+	lc.total++
+	bucket := (lc.total + lc.width - 1) / lc.width
+
+	if e, ok := lc.entries[item]; ok {
+		e.count++
+	} else {
+		lc.entries[item] = &lossyEntry{count: 1, delta: bucket - 1}
+	}
+
+	// At a bucket boundary, forget items that can't be frequent
+	if lc.total%lc.width == 0 {
+		for key, e := range lc.entries {
+			if e.count+e.delta <= bucket {
+				delete(lc.entries, key)
+			}
+		}
+	}
+}
+
+// HeavyHitters returns the items whose count is at least (support - errorBound) * N
+func (lc *LossyCounter) HeavyHitters() map[string]uint64 {
+	cutoff := (lc.support - lc.errorBound) * float64(lc.total)
+	result := make(map[string]uint64)
+	for key, e := range lc.entries {
+		if float64(e.count) >= cutoff {
+			result[key] = e.count
+		}
+	}
+	return result
+}
+
+// MisraGries is a frequent-items summary holding at most k counters.
+// Estimates never overcount and undercount by at most N/(k+1) over a stream of N
+// items, so anything above that share of the stream is guaranteed to be tracked.
+// Unlike a CountMinSketch it is deterministic and two summaries merge with the same bound.
+type MisraGries struct {
+	counters map[string]uint64
+	k        int
+	total    uint64
+}
+
+// NewMisraGries creates a summary tracking at most k items
+func NewMisraGries(k int) *MisraGries {
+	return &MisraGries{
+		counters: make(map[string]uint64, k),
+		k:        k,
+	}
+}
+
+// Add counts one occurrence of item
+func (mg *MisraGries) Add(item string) {
+	mg.total++
+	if _, ok := mg.counters[item]; ok || len(mg.counters) < mg.k {
+		mg.counters[item]++
+		return
+	}
+
+	// No room: decrement every counter instead, dropping those that hit zero
+	for key := range mg.counters {
+		mg.counters[key]--
+		if mg.counters[key] == 0 {
+			delete(mg.counters, key)
+		}
+	}
+}
+
+// Estimate returns a lower bound on item's count, 0 if it isn't tracked
+func (mg *MisraGries) Estimate(item string) uint64 {
+	return mg.counters[item]
+}
+
+// Merge folds other into mg. Counters are summed, then if more than k remain,
+// the (k+1)-th largest count is subtracted from all of them and the non-positive
+// ones dropped, which keeps the N/(k+1) bound over the combined stream.
+func (mg *MisraGries) Merge(other *MisraGries) {
+	mg.total += other.total
+	for key, count := range other.counters {
+		mg.counters[key] += count
+	}
+	if len(mg.counters) <= mg.k {
+		return
+	}
+
+	counts := make([]uint64, 0, len(mg.counters))
+	for _, count := range mg.counters {
+		counts = append(counts, count)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i] > counts[j] })
+	cut := counts[mg.k]
+
+	for key, count := range mg.counters {
+		if count <= cut {
+			delete(mg.counters, key)
+		} else {
+			mg.counters[key] = count - cut
+		}
+	}
+}
+
+// TopK returns up to n tracked items by estimated count, ties broken lexicographically
+func (mg *MisraGries) TopK(n int) []string {
+	items := make([]string, 0, len(mg.counters))
+	for key := range mg.counters {
+		items = append(items, key)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		ci, cj := mg.counters[items[i]], mg.counters[items[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return items[i] < items[j]
+	})
+	if n < len(items) {
+		items = items[:n]
+	}
+	return items
+}
+
 func main() {
 	// Create analytics with 0.01 error rate, 0.99 confidence, threshold of 5
 	analytics := NewSearchAnalytics(0.01, 0.99, 5)
@@ -147,6 +859,7 @@ func main() {
 		"bloom filter example", "count min sketch",
 		"go programming", "golang jobs", "probabilistic data structures",
 		"count min sketch", "go programming", "golang tutorial",
+		"Go  programming", // Counted with "go programming" once normalized
 	}
 
 	for _, query := range queries {
@@ -158,6 +871,6 @@ func main() {
 	fmt.Println("Top trending search terms:")
 	for i, term := range trending {
 		count := analytics.sketch.Count([]byte(term))
-		fmt.Printf("%d. %s (approx. %d times)\n", i+1, term, count)
+		fmt.Printf("%d. %s (approx. %.0f times)\n", i+1, term, count)
 	}
 }
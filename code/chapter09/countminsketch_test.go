@@ -0,0 +1,571 @@
+package main
+
+// Run with: go test countminsketch.go countminsketch_test.go
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestGetTrendingTermsTieBreak(t *testing.T) {
+	for run := 0; run < 20; run++ {
+		sa := NewSearchAnalytics(0.001, 0.99, 1)
+		for _, q := range []string{"zebra", "apple", "mango", "zebra", "apple", "mango", "mango"} {
+			sa.RecordQuery(q)
+		}
+		got := sa.GetTrendingTerms(3)
+		want := []string{"mango", "apple", "zebra"}
+		if len(got) != len(want) {
+			t.Fatalf("run %d: got %v, want %v", run, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("run %d: got %v, want %v", run, got, want)
+			}
+		}
+	}
+}
+
+func TestSearchAnalyticsPrune(t *testing.T) {
+	sa := NewSearchAnalytics(0.001, 0.99, 5)
+	for i := 0; i < 10; i++ {
+		sa.RecordQuery("golang")
+	}
+	sa.RecordQuery("rust")
+	if _, ok := sa.heavyHitters["golang"]; !ok {
+		t.Fatal("golang was not promoted to the exact map")
+	}
+
+	// Nothing has fallen below the threshold yet
+	if removed := sa.Prune(); removed != 0 {
+		t.Fatalf("Prune removed %d terms before any decay", removed)
+	}
+
+	// Decay so golang's estimate drops to 1
+	sa.Decay(0.1)
+	if removed := sa.Prune(); removed != 1 {
+		t.Errorf("Prune removed %d terms, want 1", removed)
+	}
+	if _, ok := sa.heavyHitters["golang"]; ok {
+		t.Error("golang is still in the exact map after decay and prune")
+	}
+}
+
+func TestSlidingCMSRollsOff(t *testing.T) {
+	clock := time.Unix(0, 0)
+	s := NewSlidingCMS(0.001, 0.01, time.Minute, 5)
+	s.now = func() time.Time { return clock }
+	key := []byte("/checkout")
+
+	// 10 hits in each of the first 3 minutes
+	for minute := 0; minute < 3; minute++ {
+		s.Increment(key, 10)
+		clock = clock.Add(time.Minute)
+	}
+	// Minute 3: nothing yet in the current bucket
+	if got := s.CountWindow(key, time.Minute); got != 0 {
+		t.Errorf("last minute = %d, want 0", got)
+	}
+	if got := s.CountWindow(key, 3*time.Minute); got != 20 {
+		t.Errorf("last 3 minutes = %d, want 20", got)
+	}
+	if got := s.CountWindow(key, 5*time.Minute); got != 30 {
+		t.Errorf("last 5 minutes = %d, want 30", got)
+	}
+
+	// Move to minute 6: minute 0 and 1 fall out of a 5 minute window
+	clock = clock.Add(3 * time.Minute)
+	if got := s.CountWindow(key, 5*time.Minute); got != 10 {
+		t.Errorf("last 5 minutes at minute 6 = %d, want 10", got)
+	}
+
+	// Keep writing until the ring recycles every old bucket
+	for minute := 6; minute < 12; minute++ {
+		s.Increment(key, 1)
+		clock = clock.Add(time.Minute)
+	}
+	if got := s.CountWindow(key, time.Hour); got != 5 {
+		t.Errorf("whole ring = %d, want 5 (only the newest 5 buckets are kept)", got)
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	contents := func(rb *RingBuffer[int]) []int {
+		var out []int
+		rb.ForEach(func(v int) { out = append(out, v) })
+		return out
+	}
+	equal := func(a, b []int) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	rb := NewRingBuffer[int](3)
+	if rb.Len() != 0 || rb.Cap() != 3 || len(contents(rb)) != 0 {
+		t.Fatalf("new buffer: Len %d, Cap %d, contents %v", rb.Len(), rb.Cap(), contents(rb))
+	}
+
+	rb.Push(1)
+	rb.Push(2)
+	if got := contents(rb); !equal(got, []int{1, 2}) || rb.Len() != 2 {
+		t.Errorf("partly filled = %v (Len %d), want [1 2]", got, rb.Len())
+	}
+
+	// Overwrites the oldest once full, wrapping around more than once
+	for v := 3; v <= 8; v++ {
+		rb.Push(v)
+	}
+	if got := contents(rb); !equal(got, []int{6, 7, 8}) || rb.Len() != 3 {
+		t.Errorf("after wraparound = %v (Len %d), want [6 7 8]", got, rb.Len())
+	}
+}
+
+func TestRingBufferRejectsBadCapacity(t *testing.T) {
+	for _, capacity := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewRingBuffer(%d) did not panic", capacity)
+				}
+			}()
+			NewRingBuffer[int](capacity)
+		}()
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("NewSlidingCMS with 0 buckets did not panic")
+		}
+	}()
+	NewSlidingCMS(0.01, 0.01, time.Minute, 0)
+}
+
+func TestGrowableCMSBoundsError(t *testing.T) {
+	const items = 200000
+	g := NewGrowableCMS(0.01, 0.01, 50, 10)
+	fixed := NewCountMinSketch(0.01, 0.01)
+	exact := map[string]uint32{}
+	for i := 0; i < items; i++ {
+		key := fmt.Sprint("item", i%5000)
+		g.Increment([]byte(key), 1)
+		fixed.Increment([]byte(key), 1)
+		exact[key]++
+	}
+
+	if g.Sketches() < 2 || g.Sketches() > 10 {
+		t.Fatalf("%d sketches, want growth capped at 10", g.Sketches())
+	}
+	var growErr, fixedErr float64
+	for key, n := range exact {
+		growErr += float64(g.Count([]byte(key)) - n)
+		fixedErr += float64(fixed.Count([]byte(key)) - n)
+	}
+	growErr /= float64(len(exact))
+	fixedErr /= float64(len(exact))
+	if growErr*2 > fixedErr {
+		t.Errorf("mean overcount %.1f growable vs %.1f fixed, want growing to at least halve it", growErr, fixedErr)
+	}
+}
+
+func TestGrowableCMSLimits(t *testing.T) {
+	// A count past maxError on an empty sketch doesn't grow it
+	g := NewGrowableCMS(0.1, 0.01, 10, 5)
+	g.Increment([]byte("big"), 1000)
+	if g.Sketches() != 1 {
+		t.Errorf("%d sketches after one increment, want 1", g.Sketches())
+	}
+
+	for i := 0; i < 10000; i++ {
+		g.Increment([]byte(fmt.Sprint(i)), 100)
+	}
+	if g.Sketches() != 5 {
+		t.Errorf("%d sketches, want the cap of 5", g.Sketches())
+	}
+	if got := g.Count([]byte("big")); got < 1000 {
+		t.Errorf("Count(big) = %d, want at least 1000", got)
+	}
+}
+
+// zipfStream returns n queries drawn from a Zipf distribution over 10,000 terms
+func zipfStream(n int) []string {
+	z := rand.NewZipf(rand.New(rand.NewSource(1)), 1.2, 1, 9999)
+	stream := make([]string, n)
+	for i := range stream {
+		stream[i] = fmt.Sprint("term", z.Uint64())
+	}
+	return stream
+}
+
+func TestHeavyHitterRecallCMSvsLossy(t *testing.T) {
+	const n, support, errorBound = 100000, 0.01, 0.001
+	stream := zipfStream(n)
+
+	exact := map[string]int{}
+	cms := NewSearchAnalytics(0.0001, 0.99, uint32(support*n))
+	lossy := NewLossySearchAnalytics(support, errorBound)
+	for _, q := range stream {
+		exact[q]++
+		cms.RecordQuery(q)
+		lossy.RecordQuery(q)
+	}
+
+	var heavy []string
+	for q, c := range exact {
+		if c >= support*n {
+			heavy = append(heavy, q)
+		}
+	}
+	if len(heavy) < 5 {
+		t.Fatalf("only %d true heavy hitters, the stream isn't skewed enough", len(heavy))
+	}
+
+	for name, sa := range map[string]*SearchAnalytics{"cms": cms, "lossy": lossy} {
+		reported := map[string]bool{}
+		for _, q := range sa.GetTrendingTerms(1000) {
+			reported[q] = true
+		}
+		for _, q := range heavy {
+			if !reported[q] {
+				t.Errorf("%s missed heavy hitter %s (%d of %d)", name, q, exact[q], n)
+			}
+		}
+		// Neither reports anything far below support
+		for q := range reported {
+			if float64(exact[q]) < (support-2*errorBound)*n {
+				t.Errorf("%s reported %s with only %d occurrences", name, q, exact[q])
+			}
+		}
+	}
+}
+
+func TestNewLossyCounterRejectsBadBounds(t *testing.T) {
+	for _, eb := range []float64{0, -0.1, 0.01, 0.02} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewLossyCounter(0.01, %v) did not panic", eb)
+				}
+			}()
+			NewLossyCounter(0.01, eb)
+		}()
+	}
+}
+
+// checkMisraGries asserts the summary's guarantees against exact counts over n items:
+// no overcounts, undercounts within n/(k+1), and every item above that share tracked
+func checkMisraGries(t *testing.T, mg *MisraGries, exact map[string]int, n, k int) {
+	t.Helper()
+	bound := n / (k + 1)
+	for item, count := range exact {
+		est := int(mg.Estimate(item))
+		if est > count || est < count-bound {
+			t.Errorf("%s: estimate %d, exact %d, allowed [%d, %d]", item, est, count, count-bound, count)
+		}
+		if count > bound && est == 0 {
+			t.Errorf("%s: %d occurrences is above n/(k+1) = %d but it isn't tracked", item, count, bound)
+		}
+	}
+	if len(mg.counters) > k {
+		t.Errorf("summary holds %d counters, want at most %d", len(mg.counters), k)
+	}
+}
+
+func TestMisraGriesHeavyHitters(t *testing.T) {
+	const n, k = 100000, 100
+	stream := zipfStream(n)
+	exact := map[string]int{}
+	mg := NewMisraGries(k)
+	for _, term := range stream {
+		exact[term]++
+		mg.Add(term)
+	}
+	checkMisraGries(t, mg, exact, n, k)
+
+	// The head of a Zipf stream dominates, so the top items match ground truth
+	if top := mg.TopK(3); len(top) != 3 || top[0] != "term0" || top[1] != "term1" || top[2] != "term2" {
+		t.Errorf("TopK(3) = %v, want [term0 term1 term2]", top)
+	}
+}
+
+func TestMisraGriesMerge(t *testing.T) {
+	const n, k = 100000, 100
+	stream := zipfStream(n)
+	exact := map[string]int{}
+	left, right := NewMisraGries(k), NewMisraGries(k)
+	for i, term := range stream {
+		exact[term]++
+		if i%2 == 0 {
+			left.Add(term)
+		} else {
+			right.Add(term)
+		}
+	}
+
+	left.Merge(right)
+	if left.total != n {
+		t.Errorf("merged total = %d, want %d", left.total, n)
+	}
+	checkMisraGries(t, left, exact, n, k)
+}
+
+// BenchmarkConservativeUpdate compares plain and conservative increments on a Zipfian
+// stream, reporting the mean overestimate across distinct terms next to the timing
+func BenchmarkConservativeUpdate(b *testing.B) {
+	stream := zipfStream(100000)
+	exact := map[string]uint32{}
+	for _, term := range stream {
+		exact[term]++
+	}
+
+	for _, c := range []struct {
+		name      string
+		increment func(*CountMinSketch, []byte, uint32)
+	}{
+		{"Increment", (*CountMinSketch).Increment},
+		{"IncrementConservative", (*CountMinSketch).IncrementConservative},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			var overestimate float64
+			for i := 0; i < b.N; i++ {
+				cms := NewCountMinSketch(0.001, 0.01)
+				for _, term := range stream {
+					c.increment(cms, []byte(term), 1)
+				}
+
+				b.StopTimer()
+				var sum uint64
+				for term, count := range exact {
+					sum += uint64(cms.Count([]byte(term)) - count)
+				}
+				overestimate = float64(sum) / float64(len(exact))
+				b.StartTimer()
+			}
+			b.ReportMetric(overestimate, "overcount/term")
+		})
+	}
+}
+
+func TestConservativeUpdateNeverUndercounts(t *testing.T) {
+	stream := zipfStream(100000)
+	exact := map[string]uint32{}
+	plain, conservative := NewCountMinSketch(0.001, 0.01), NewCountMinSketch(0.001, 0.01)
+	for _, term := range stream {
+		exact[term]++
+		plain.Increment([]byte(term), 1)
+		conservative.IncrementConservative([]byte(term), 1)
+	}
+
+	var plainOver, conservativeOver uint64
+	for term, count := range exact {
+		p, c := plain.Count([]byte(term)), conservative.Count([]byte(term))
+		if c < count || c > p {
+			t.Fatalf("%s: conservative estimate %d, want between exact %d and plain %d", term, c, count, p)
+		}
+		plainOver += uint64(p - count)
+		conservativeOver += uint64(c - count)
+	}
+	if conservativeOver >= plainOver {
+		t.Errorf("conservative update overcounts %d in total, plain %d", conservativeOver, plainOver)
+	}
+}
+
+func TestMergeSplitStream(t *testing.T) {
+	const n, epsilon = 100000, 0.001
+	stream := zipfStream(n)
+	exact := map[string]uint32{}
+	left, right := NewCountMinSketch(epsilon, 0.01), NewCountMinSketch(epsilon, 0.01)
+	for i, term := range stream {
+		exact[term]++
+		if i%3 == 0 {
+			left.Increment([]byte(term), 1)
+		} else {
+			right.Increment([]byte(term), 1)
+		}
+	}
+
+	merged := left.Clone()
+	if err := merged.Merge(right); err != nil {
+		t.Fatal(err)
+	}
+	if merged.TotalCount() != n {
+		t.Errorf("merged total = %d, want %d", merged.TotalCount(), n)
+	}
+	if left.TotalCount() == n {
+		t.Error("merging into the clone changed the original")
+	}
+
+	// Count-Min overcounts by at most epsilon*N with probability 1-delta per term
+	bound := uint32(epsilon * n)
+	var over int
+	for term, count := range exact {
+		est := merged.Count([]byte(term))
+		if est < count {
+			t.Fatalf("%s: merged estimate %d undercounts exact %d", term, est, count)
+		}
+		if est > count+bound {
+			over++
+		}
+	}
+	if rate := float64(over) / float64(len(exact)); rate > 0.01 {
+		t.Errorf("%.3f of terms overcount by more than epsilon*N, want at most delta", rate)
+	}
+
+	if err := merged.Merge(NewCountMinSketch(0.01, 0.01)); err == nil {
+		t.Error("merging sketches of different widths succeeded, want an error")
+	}
+}
+
+func TestDecayingStaleFadesBelowFresh(t *testing.T) {
+	for _, floatCells := range []bool{false, true} {
+		d := NewDecayingCountMinSketch(0.001, 0.01, floatCells)
+		d.Increment([]byte("stale"), 1000) // A burst that's over
+
+		// Each cycle "fresh" arrives steadily while everything decays by half
+		for cycle := 1; cycle <= 6; cycle++ {
+			d.Increment([]byte("fresh"), 100)
+			d.Decay(0.5)
+			stale, fresh := d.Count([]byte("stale")), d.Count([]byte("fresh"))
+			if cycle <= 2 && stale <= fresh {
+				t.Errorf("floatCells=%v cycle %d: stale %v already below fresh %v", floatCells, cycle, stale, fresh)
+			}
+			if cycle >= 4 && stale >= fresh {
+				t.Errorf("floatCells=%v cycle %d: stale %v hasn't faded below fresh %v", floatCells, cycle, stale, fresh)
+			}
+		}
+		if want := 1000 * math.Pow(0.5, 6); floatCells && d.Count([]byte("stale")) != want {
+			t.Errorf("float cells: stale = %v, want exactly %v", d.Count([]byte("stale")), want)
+		}
+	}
+}
+
+func TestHeavyHittersMatchExact(t *testing.T) {
+	const n, fraction = 100000, 0.01
+	stream := zipfStream(n)
+	exact := map[string]int{}
+	cms := NewCountMinSketchWithHeavyHitters(0.0001, 0.01, 50)
+	for _, term := range stream {
+		exact[term]++
+		cms.Increment([]byte(term), 1)
+	}
+	if cms.TotalCount() != n {
+		t.Errorf("TotalCount() = %d, want %d", cms.TotalCount(), n)
+	}
+
+	hitters := cms.HeavyHitters(fraction)
+	reported := map[string]bool{}
+	for i, h := range hitters {
+		reported[h.Key] = true
+		if i > 0 && h.Count > hitters[i-1].Count {
+			t.Errorf("HeavyHitters not sorted descending at %d: %v", i, hitters)
+		}
+		if h.Count < uint32(exact[h.Key]) {
+			t.Errorf("%s: estimate %d undercounts exact %d", h.Key, h.Count, exact[h.Key])
+		}
+	}
+	for term, count := range exact {
+		if count >= fraction*n && !reported[term] {
+			t.Errorf("%s has %d of %d queries but wasn't reported", term, count, n)
+		}
+	}
+
+	// Plain sketches don't pay for tracking, and have nothing to report
+	if plain := NewCountMinSketch(0.01, 0.01); plain.candidates != nil || plain.HeavyHitters(fraction) != nil {
+		t.Error("a plain sketch tracks heavy hitter candidates")
+	}
+}
+
+func TestDecayScalesTotal(t *testing.T) {
+	for _, floatCells := range []bool{false, true} {
+		d := NewDecayingCountMinSketch(0.01, 0.01, floatCells)
+		d.Increment([]byte("a"), 600)
+		d.Increment([]byte("b"), 400)
+		d.Decay(0.5)
+		if d.TotalCount() != 500 {
+			t.Errorf("floatCells=%v: total after decay = %d, want 500", floatCells, d.TotalCount())
+		}
+	}
+
+	// Candidates decay with the cells, so their counts track Count
+	d := NewDecayingCountMinSketch(0.01, 0.01, false)
+	d.sketch.candidates = newHitterHeap(10)
+	d.Increment([]byte("a"), 600)
+	d.Decay(0.5)
+	if hitters := d.sketch.HeavyHitters(0.5); len(hitters) != 1 || hitters[0].Count != uint32(d.Count([]byte("a"))) {
+		t.Errorf("decayed candidates = %v, want a at %v", hitters, d.Count([]byte("a")))
+	}
+}
+
+func BenchmarkIncrementTracking(b *testing.B) {
+	stream := zipfStream(10000)
+	for _, c := range []struct {
+		name string
+		cms  *CountMinSketch
+	}{
+		{"Plain", NewCountMinSketch(0.001, 0.01)},
+		{"HeavyHitters", NewCountMinSketchWithHeavyHitters(0.001, 0.01, 100)},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.cms.Increment([]byte(stream[i%len(stream)]), 1)
+			}
+		})
+	}
+}
+
+func TestEstimateCMMImprovesLongTail(t *testing.T) {
+	stream := zipfStream(100000)
+	exact := map[string]int{}
+	cms := NewCountMinSketch(0.01, 0.001) // Narrow, so the tail collides with the head
+	for _, term := range stream {
+		exact[term]++
+		cms.Increment([]byte(term), 1)
+	}
+
+	var rare int
+	var minErr, cmmErr float64
+	for term, count := range exact {
+		if count > 5 {
+			continue
+		}
+		rare++
+		minErr += math.Abs(float64(cms.Count([]byte(term))) - float64(count))
+		cmmErr += math.Abs(float64(cms.EstimateCMM([]byte(term))) - float64(count))
+	}
+	minErr, cmmErr = minErr/float64(rare), cmmErr/float64(rare)
+	t.Logf("%d rare terms: mean error %.1f with Count, %.1f with EstimateCMM", rare, minErr, cmmErr)
+	if cmmErr >= minErr/2 {
+		t.Errorf("EstimateCMM mean error %.1f, want well under Count's %.1f", cmmErr, minErr)
+	}
+}
+
+func TestNormalizerMergesWhitespaceVariants(t *testing.T) {
+	sa := NewSearchAnalytics(0.001, 0.99, 3)
+	for _, q := range []string{"go programming", "Go  programming", " go\tprogramming ", "GO PROGRAMMING", "rust"} {
+		sa.RecordQuery(q)
+	}
+	if got := sa.sketch.Count([]byte("go programming")); got != 4 {
+		t.Errorf("count for go programming = %v, want all 4 variants", got)
+	}
+	if got := sa.GetTrendingTerms(5); len(got) != 1 || got[0] != "go programming" {
+		t.Errorf("trending = %v, want [go programming]", got)
+	}
+
+	// A custom normalizer replaces the default
+	custom := NewSearchAnalytics(0.001, 0.99, 1)
+	custom.Normalizer = func(q string) string { return q }
+	custom.RecordQuery("Go")
+	custom.RecordQuery("go")
+	if a, b := custom.sketch.Count([]byte("Go")), custom.sketch.Count([]byte("go")); a != 1 || b != 1 {
+		t.Errorf("identity normalizer: Go = %v, go = %v, want them counted apart", a, b)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// HyperLogLog estimates the number of distinct elements in a stream
+// using 2^precision small registers instead of remembering the elements.
+// It starts sparse, keeping only the non-zero registers in a map, and switches
+// to the dense array once that map would be about as big, so thousands of
+// mostly empty sketches stay cheap. Both modes give identical estimates.
+type HyperLogLog struct {
+	registers []uint8          // Longest run of leading zeros seen, plus one, per register; nil while sparse
+	sparse    map[uint32]uint8 // Non-zero registers by index, nil once dense
+	precision uint             // Number of hash bits used to pick a register
+	m         uint             // Number of registers, 2^precision
+}
+
+// New creates a HyperLogLog with 2^precision registers.
+// The standard error is about 1.04/sqrt(2^precision): 0.8% at precision 14.
+// precision must be between 4 and 18.
+func New(precision uint) *HyperLogLog {
+	if precision < 4 || precision > 18 {
+		panic(fmt.Sprintf("hyperloglog: precision %d out of range [4, 18]", precision))
+	}
+	return &HyperLogLog{
+		sparse:    make(map[uint32]uint8),
+		precision: precision,
+		m:         uint(1) << precision,
+	}
+}
+
+// IsSparse reports whether the registers are still kept in the sparse map
+func (hll *HyperLogLog) IsSparse() bool {
+	return hll.sparse != nil
+}
+
+// sparseLimit is the number of sparse entries past which the dense array is smaller.
+// A map entry costs roughly 8 bytes against 1 byte per dense register.
+func (hll *HyperLogLog) sparseLimit() int {
+	return int(hll.m / 8)
+}
+
+// update raises register index to rank if rank is larger
+func (hll *HyperLogLog) update(index uint32, rank uint8) {
+	if hll.sparse == nil {
+		if rank > hll.registers[index] {
+			hll.registers[index] = rank
+		}
+		return
+	}
+
+	if rank > hll.sparse[index] {
+		hll.sparse[index] = rank
+		if len(hll.sparse) > hll.sparseLimit() {
+			hll.toDense()
+		}
+	}
+}
+
+// toDense moves the sparse registers into the dense array
+func (hll *HyperLogLog) toDense() {
+	hll.registers = make([]uint8, hll.m)
+	for index, rank := range hll.sparse {
+		hll.registers[index] = rank
+	}
+	hll.sparse = nil
+}
+
+// forEach calls fn for every non-zero register
+func (hll *HyperLogLog) forEach(fn func(index uint32, rank uint8)) {
+	if hll.sparse != nil {
+		for index, rank := range hll.sparse {
+			fn(index, rank)
+		}
+		return
+	}
+	for index, rank := range hll.registers {
+		if rank != 0 {
+			fn(uint32(index), rank)
+		}
+	}
+}
+
+// clone returns an independent copy of hll
+func (hll *HyperLogLog) clone() *HyperLogLog {
+	c := &HyperLogLog{precision: hll.precision, m: hll.m}
+	if hll.sparse != nil {
+		c.sparse = make(map[uint32]uint8, len(hll.sparse))
+		for index, rank := range hll.sparse {
+			c.sparse[index] = rank
+		}
+	} else {
+		c.registers = make([]uint8, hll.m)
+		copy(c.registers, hll.registers)
+	}
+	return c
+}
+
+// Add adds an element to the HyperLogLog
+func (hll *HyperLogLog) Add(data []byte) {
+	hash := murmur3.Sum64(data)
+
+	// The first precision bits pick the register
+	index := hash >> (64 - hll.precision)
+
+	// The rest give the rank: position of the first 1 bit. The sentinel bit
+	// caps the rank when every remaining bit is zero.
+	rest := hash<<hll.precision | 1<<(hll.precision-1)
+	rank := uint8(bits.LeadingZeros64(rest) + 1)
+
+	hll.update(uint32(index), rank)
+}
+
+// Estimate returns the estimated number of distinct elements added
+func (hll *HyperLogLog) Estimate() uint64 {
+	m := float64(hll.m)
+	estimate, zeros := hll.rawEstimate()
+
+	// HyperLogLog++ corrections. Below 5m the raw estimate runs high, by as much
+	// as 70% of m when empty, so the measured bias is subtracted. With empty
+	// registers left, linear counting is more accurate still up to a threshold.
+	// The 64-bit hash makes a large range correction unnecessary.
+	if estimate <= 5*m {
+		estimate -= m * rawEstimateBias(hll.precision, estimate/m)
+	}
+	if zeros > 0 {
+		if linear := m * math.Log(m/float64(zeros)); linear <= linearCountingThreshold[hll.precision-4] {
+			estimate = linear
+		}
+	}
+
+	return uint64(math.Round(max(estimate, 0)))
+}
+
+// rawEstimate returns the uncorrected estimate, alpha*m^2 over the harmonic sum
+// of 2^-register, and the number of registers still zero
+func (hll *HyperLogLog) rawEstimate() (estimate float64, zeros uint) {
+	m := float64(hll.m)
+
+	// Tallying ranks first sums them in a fixed order, so sparse and dense
+	// give the exact same result
+	var ranks [66]uint64 // Ranks go up to 64-precision+1
+	nonZero := uint(0)
+	hll.forEach(func(_ uint32, rank uint8) {
+		ranks[rank]++
+		nonZero++
+	})
+	zeros = hll.m - nonZero
+
+	sum := float64(zeros)
+	for rank, count := range ranks {
+		sum += float64(count) * math.Ldexp(1, -rank)
+	}
+	return alpha(hll.m) * m * m / sum, zeros
+}
+
+// Merge folds other into hll by keeping the larger of each register pair, so hll
+// then estimates the distinct count of the union of both streams. Sketches built on
+// different machines merge fine as long as they share the same precision.
+func (hll *HyperLogLog) Merge(other *HyperLogLog) error {
+	if hll.precision != other.precision {
+		return fmt.Errorf("cannot merge hyperloglogs with precision %d and %d", hll.precision, other.precision)
+	}
+	other.forEach(hll.update)
+	return nil
+}
+
+// UnionEstimate estimates the distinct count of the union of a and b without
+// modifying either. They must share the same precision; it panics otherwise.
+func UnionEstimate(a, b *HyperLogLog) uint64 {
+	union := a.clone()
+	if err := union.Merge(b); err != nil {
+		panic(err)
+	}
+	return union.Estimate()
+}
+
+// IntersectionEstimate estimates how many distinct elements a and b share, by
+// inclusion-exclusion: |A| + |B| - |A∪B|. The three estimates' errors add up, and
+// they're relative to the sets, not the overlap, so a small overlap between large
+// sets is mostly noise. For nearly disjoint sets the result can come out negative;
+// it is clamped to zero.
+func IntersectionEstimate(a, b *HyperLogLog) uint64 {
+	intersection := int64(a.Estimate()) + int64(b.Estimate()) - int64(UnionEstimate(a, b))
+	if intersection < 0 {
+		return 0
+	}
+	return uint64(intersection)
+}
+
+// linearCountingThreshold is, by precision from 4, the cardinality below which
+// linear counting beats the bias-corrected estimate (from the HyperLogLog++ paper)
+var linearCountingThreshold = [...]float64{
+	10, 20, 40, 80, 220, 400, 900, 1800, 3100, 6500, 11500, 20000, 50000, 120000, 350000,
+}
+
+// rawEstimateBias returns the average amount by which the raw estimate overshoots
+// the true cardinality, interpolated linearly in the table for precision. Both
+// are divided by m. Measured that way, the bias is the same at every precision
+// from 7 up; below that, the fixed alpha constants shift it.
+func rawEstimateBias(precision uint, estimate float64) float64 {
+	table := rawEstimateBias7
+	switch precision {
+	case 4:
+		table = rawEstimateBias4
+	case 5:
+		table = rawEstimateBias5
+	case 6:
+		table = rawEstimateBias6
+	}
+
+	if estimate <= table[0][0] {
+		return table[0][1]
+	}
+	for i := 1; i < len(table); i++ {
+		if estimate <= table[i][0] {
+			lo, hi := table[i-1], table[i]
+			return lo[1] + (hi[1]-lo[1])*(estimate-lo[0])/(hi[0]-lo[0])
+		}
+	}
+	return table[len(table)-1][1]
+}
+
+// Raw estimate and its bias, both divided by m, at 41 cardinalities from 0 to 5m.
+// Each point averages the raw estimates of many simulated sketches: a million at
+// precisions 4 and 5, half a million at 6, and 16,384 at 14 for the shared table.
+var (
+	rawEstimateBias4 = [][2]float64{
+		{0.6730, 0.6730}, {0.7326, 0.6076}, {0.7962, 0.5462}, {0.8636, 0.4886}, {0.9350, 0.4350},
+		{1.0104, 0.3854}, {1.0897, 0.3397}, {1.1729, 0.2979}, {1.2597, 0.2597}, {1.3503, 0.2253},
+		{1.4444, 0.1944}, {1.5418, 0.1668}, {1.6425, 0.1425}, {1.7461, 0.1211}, {1.8524, 0.1024},
+		{1.9610, 0.0860}, {2.0716, 0.0716}, {2.1847, 0.0597}, {2.2995, 0.0495}, {2.4157, 0.0407},
+		{2.5330, 0.0330}, {2.6520, 0.0270}, {2.7721, 0.0221}, {2.8927, 0.0177}, {3.0142, 0.0142},
+		{3.1366, 0.0116}, {3.2592, 0.0092}, {3.3821, 0.0071}, {3.5055, 0.0055}, {3.6292, 0.0042},
+		{3.7530, 0.0030}, {3.8772, 0.0022}, {4.0016, 0.0016}, {4.1256, 0.0006}, {4.2500, 0.0000},
+		{4.3746, -0.0004}, {4.4994, -0.0006}, {4.6245, -0.0005}, {4.7497, -0.0003}, {4.8750, 0.0000},
+		{4.9998, -0.0002},
+	}
+	rawEstimateBias5 = [][2]float64{
+		{0.6970, 0.6970}, {0.7578, 0.6328}, {0.8223, 0.5723}, {0.8906, 0.5156}, {0.9627, 0.4627},
+		{1.0385, 0.4135}, {1.1180, 0.3680}, {1.2011, 0.3261}, {1.2878, 0.2878}, {1.3778, 0.2528},
+		{1.4712, 0.2212}, {1.5674, 0.1924}, {1.6669, 0.1669}, {1.7689, 0.1439}, {1.8737, 0.1237},
+		{1.9807, 0.1057}, {2.0899, 0.0899}, {2.2012, 0.0762}, {2.3142, 0.0642}, {2.4291, 0.0541},
+		{2.5451, 0.0451}, {2.6624, 0.0374}, {2.7809, 0.0309}, {2.9007, 0.0257}, {3.0212, 0.0212},
+		{3.1423, 0.0173}, {3.2639, 0.0139}, {3.3859, 0.0109}, {3.5086, 0.0086}, {3.6318, 0.0068},
+		{3.7553, 0.0053}, {3.8790, 0.0040}, {4.0029, 0.0029}, {4.1273, 0.0023}, {4.2517, 0.0017},
+		{4.3761, 0.0011}, {4.5012, 0.0012}, {4.6257, 0.0007}, {4.7504, 0.0004}, {4.8750, -0.0000},
+		{5.0000, -0.0000},
+	}
+	rawEstimateBias6 = [][2]float64{
+		{0.7090, 0.7090}, {0.7704, 0.6454}, {0.8354, 0.5854}, {0.9041, 0.5291}, {0.9765, 0.4765},
+		{1.0524, 0.4274}, {1.1320, 0.3820}, {1.2152, 0.3402}, {1.3017, 0.3017}, {1.3915, 0.2665},
+		{1.4845, 0.2345}, {1.5805, 0.2055}, {1.6794, 0.1794}, {1.7811, 0.1561}, {1.8850, 0.1350},
+		{1.9915, 0.1165}, {2.1001, 0.1001}, {2.2106, 0.0856}, {2.3230, 0.0730}, {2.4367, 0.0617},
+		{2.5520, 0.0520}, {2.6688, 0.0438}, {2.7866, 0.0366}, {2.9054, 0.0304}, {3.0250, 0.0250},
+		{3.1452, 0.0202}, {3.2666, 0.0166}, {3.3884, 0.0134}, {3.5104, 0.0104}, {3.6333, 0.0083},
+		{3.7567, 0.0067}, {3.8803, 0.0053}, {4.0039, 0.0039}, {4.1281, 0.0031}, {4.2524, 0.0024},
+		{4.3768, 0.0018}, {4.5012, 0.0012}, {4.6258, 0.0008}, {4.7506, 0.0006}, {4.8751, 0.0001},
+		{4.9996, -0.0004},
+	}
+	rawEstimateBias7 = [][2]float64{
+		{0.7213, 0.7213}, {0.7832, 0.6582}, {0.8487, 0.5987}, {0.9178, 0.5428}, {0.9905, 0.4905},
+		{1.0667, 0.4417}, {1.1465, 0.3965}, {1.2296, 0.3546}, {1.3161, 0.3161}, {1.4058, 0.2808},
+		{1.4985, 0.2485}, {1.5942, 0.2192}, {1.6926, 0.1926}, {1.7937, 0.1687}, {1.8972, 0.1472},
+		{2.0030, 0.1280}, {2.1108, 0.1108}, {2.2206, 0.0956}, {2.3322, 0.0822}, {2.4455, 0.0705},
+		{2.5602, 0.0602}, {2.6763, 0.0513}, {2.7936, 0.0436}, {2.9118, 0.0368}, {3.0310, 0.0310},
+		{3.1511, 0.0261}, {3.2719, 0.0219}, {3.3932, 0.0182}, {3.5152, 0.0152}, {3.6376, 0.0126},
+		{3.7605, 0.0105}, {3.8837, 0.0087}, {4.0072, 0.0072}, {4.1308, 0.0058}, {4.2548, 0.0048},
+		{4.3789, 0.0039}, {4.5032, 0.0032}, {4.6276, 0.0026}, {4.7521, 0.0021}, {4.8768, 0.0018},
+		{5.0015, 0.0015},
+	}
+)
+
+// alpha is the bias correction constant for m registers
+func alpha(m uint) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
+
+func main() {
+	hll := New(14)
+
+	// Count 100,000 distinct user IDs, each seen several times
+	const distinct = 100000
+	for round := 0; round < 3; round++ {
+		for i := 0; i < distinct; i++ {
+			hll.Add([]byte(fmt.Sprintf("user-%d", i)))
+		}
+	}
+
+	estimate := hll.Estimate()
+	errorPct := math.Abs(float64(estimate)-distinct) / distinct * 100
+	fmt.Printf("Distinct users: %d, estimated: %d (%.2f%% error)\n", distinct, estimate, errorPct)
+	fmt.Printf("Memory used: %d bytes of registers (sparse: %v)\n", len(hll.registers), hll.IsSparse())
+
+	// Two shards seeing overlapping users merge into the count for both
+	shardA, shardB := New(14), New(14)
+	for i := 0; i < 60000; i++ {
+		shardA.Add([]byte(fmt.Sprintf("user-%d", i)))
+		shardB.Add([]byte(fmt.Sprintf("user-%d", i+40000)))
+	}
+	if err := shardA.Merge(shardB); err != nil {
+		fmt.Println("Merge failed:", err)
+		return
+	}
+	fmt.Printf("Users across shards: 100000, estimated: %d\n", shardA.Estimate())
+
+	// Users active both last week and this week
+	lastWeek, thisWeek := New(14), New(14)
+	for i := 0; i < 50000; i++ {
+		lastWeek.Add([]byte(fmt.Sprintf("user-%d", i)))
+		thisWeek.Add([]byte(fmt.Sprintf("user-%d", i+30000)))
+	}
+	fmt.Printf("Users in both weeks: 20000, estimated: %d (union estimated: %d)\n",
+		IntersectionEstimate(lastWeek, thisWeek), UnionEstimate(lastWeek, thisWeek))
+}
@@ -0,0 +1,191 @@
+package main
+
+// Run with: go test hyperloglog.go hyperloglog_test.go
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"testing"
+)
+
+// fill adds n distinct elements, tagged so different sketches get different ones
+func fill(hll *HyperLogLog, tag string, n int) {
+	for i := 0; i < n; i++ {
+		hll.Add([]byte(fmt.Sprintf("%s-%d", tag, i)))
+	}
+}
+
+func TestEstimateBiasCorrected(t *testing.T) {
+	const precision, runs = 10, 100
+	m := 1 << precision
+
+	// The raw estimate overshoots by several percent around 2m-3m; corrected,
+	// the average error over many sketches should be close to zero
+	for _, n := range []int{m, 2 * m, 3 * m, 4 * m} {
+		corrected, raw := 0.0, 0.0
+		for r := 0; r < runs; r++ {
+			hll := New(precision)
+			fill(hll, fmt.Sprint("run", r), n)
+			corrected += (float64(hll.Estimate()) - float64(n)) / float64(n)
+			estimate, _ := hll.rawEstimate()
+			raw += (estimate - float64(n)) / float64(n)
+		}
+		corrected, raw = corrected/runs, raw/runs
+		t.Logf("n=%d: average relative error %.4f corrected, %.4f raw", n, corrected, raw)
+		if math.Abs(corrected) > 0.015 {
+			t.Errorf("n=%d: average relative error %.4f, want within 0.015", n, corrected)
+		}
+
+		// Up to 2m, where the raw bias is largest, the correction must clearly beat it
+		if n <= 2*m && math.Abs(corrected) >= math.Abs(raw)/2 {
+			t.Errorf("n=%d: corrected error %.4f isn't well below the raw %.4f", n, corrected, raw)
+		}
+	}
+}
+
+func TestRawEstimateBiasInterpolates(t *testing.T) {
+	// Empty sketches: the raw estimate is alpha*m, all of it bias
+	if got := rawEstimateBias(14, 0.7213); math.Abs(got-0.7213) > 1e-9 {
+		t.Errorf("bias at empty = %v, want 0.7213", got)
+	}
+	// Halfway between two table points
+	lo, hi := rawEstimateBias7[10], rawEstimateBias7[11]
+	want := (lo[1] + hi[1]) / 2
+	if got := rawEstimateBias(12, (lo[0]+hi[0])/2); math.Abs(got-want) > 1e-9 {
+		t.Errorf("interpolated bias = %v, want %v", got, want)
+	}
+	// Past the table the last value holds
+	if got := rawEstimateBias(4, 9); got != rawEstimateBias4[len(rawEstimateBias4)-1][1] {
+		t.Errorf("bias past the table = %v", got)
+	}
+}
+
+func TestEstimateAccuracy(t *testing.T) {
+	// Small counts use linear counting and come out near exact
+	for _, n := range []int{0, 1, 10, 100} {
+		hll := New(14)
+		fill(hll, "small", n)
+		if got := hll.Estimate(); math.Abs(float64(got)-float64(n)) > math.Max(1, 0.01*float64(n)) {
+			t.Errorf("n=%d: estimate %d", n, got)
+		}
+	}
+
+	// Standard error at precision 14 is 1.04/sqrt(2^14), about 0.8%
+	hll := New(14)
+	fill(hll, "user", 100000)
+	fill(hll, "user", 100000) // Repeats don't count
+	if rel := math.Abs(float64(hll.Estimate())-100000) / 100000; rel > 0.02 {
+		t.Errorf("estimate %d for 100000 distinct, relative error %.4f", hll.Estimate(), rel)
+	}
+}
+
+func TestMergeSplitIDs(t *testing.T) {
+	// Shard A sees users 0-59999, shard B users 40000-99999: 100000 in the union
+	a, b := New(14), New(14)
+	for i := 0; i < 100000; i++ {
+		id := []byte(fmt.Sprint("user-", i))
+		if i < 60000 {
+			a.Add(id)
+		}
+		if i >= 40000 {
+			b.Add(id)
+		}
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if rel := math.Abs(float64(a.Estimate())-100000) / 100000; rel > 0.02 {
+		t.Errorf("merged estimate %d for a union of 100000, relative error %.4f", a.Estimate(), rel)
+	}
+	if err := a.Merge(New(10)); err == nil {
+		t.Error("merging different precisions succeeded, want an error")
+	}
+}
+
+func TestIntersectionEstimateTracksOverlap(t *testing.T) {
+	// Last week 50000 users, this week 50000, sharing overlap of them
+	for _, overlap := range []int{10000, 25000, 40000} {
+		lastWeek, thisWeek := New(14), New(14)
+		for i := 0; i < 50000; i++ {
+			lastWeek.Add([]byte(fmt.Sprint("user-", i)))
+			thisWeek.Add([]byte(fmt.Sprint("user-", 50000-overlap+i)))
+		}
+
+		union := 100000 - overlap
+		if got := UnionEstimate(lastWeek, thisWeek); math.Abs(float64(got)-float64(union))/float64(union) > 0.02 {
+			t.Errorf("overlap %d: union estimate %d, want about %d", overlap, got, union)
+		}
+		// Errors of three estimates add up, relative to the sets rather than the overlap
+		got := IntersectionEstimate(lastWeek, thisWeek)
+		if math.Abs(float64(got)-float64(overlap)) > 0.03*100000 {
+			t.Errorf("overlap %d: intersection estimate %d", overlap, got)
+		}
+	}
+
+	// Disjoint sets may come out negative, which is clamped
+	a, b := New(10), New(10)
+	fill(a, "a", 5000)
+	fill(b, "b", 5000)
+	if got := IntersectionEstimate(a, b); got > 500 {
+		t.Errorf("disjoint sets: intersection estimate %d, want near zero", got)
+	}
+}
+
+func TestSparseMatchesDense(t *testing.T) {
+	sparse := New(14)
+	dense := New(14)
+	dense.toDense()
+
+	for n, step := 0, 1; n < 5000; n += step {
+		fill(sparse, "item", n)
+		fill(dense, "item", n)
+		if sparse.Estimate() != dense.Estimate() {
+			t.Fatalf("n=%d: sparse estimate %d, dense %d", n, sparse.Estimate(), dense.Estimate())
+		}
+		step *= 2
+	}
+
+	// 2^14/8 = 2048 non-zero registers is where the map stops paying off
+	small := New(14)
+	fill(small, "endpoint", 1000)
+	if !small.IsSparse() {
+		t.Error("a sketch of 1000 items went dense")
+	}
+	fill(small, "endpoint", 5000)
+	if small.IsSparse() {
+		t.Error("a sketch of 5000 items is still sparse")
+	}
+}
+
+// BenchmarkSparseMemory measures the heap held by 10k sketches of about 50
+// items each, the one-sketch-per-endpoint case sparse mode is for
+func BenchmarkSparseMemory(b *testing.B) {
+	const sketches, items = 10000, 50
+	for _, mode := range []string{"sparse", "dense"} {
+		b.Run(mode, func(b *testing.B) {
+			var perSketch float64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				all := make([]*HyperLogLog, sketches)
+				for s := range all {
+					all[s] = New(14)
+					if mode == "dense" {
+						all[s].toDense()
+					}
+					fill(all[s], fmt.Sprint("endpoint", s), items)
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				perSketch = float64(after.HeapAlloc-before.HeapAlloc) / sketches
+				runtime.KeepAlive(all)
+			}
+			b.ReportMetric(perSketch, "bytes/sketch")
+		})
+	}
+}
@@ -2,18 +2,22 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
+	"container/heap"
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"ourpackage/bloomfilter"
-	"ourpackage/cms"
-	"ourpackage/hyperloglog"
-	"ourpackage/lsh"
-	"ourpackage/minhash"
+	"github.com/spaolacci/murmur3"
 )
 
 // LogEntry represents a parsed log entry
@@ -28,30 +32,260 @@ type LogEntry struct {
 }
 
 // LogAnalyzer uses probabilistic data structures to analyze logs
+// It is not safe for concurrent use; each goroutine needs its own analyzer.
 type LogAnalyzer struct {
-	deduper        *bloomfilter.BloomFilter
-	pathCounter    *cms.CountMinSketch
-	userCounter    *hyperloglog.HyperLogLog
-	sessionCounter *hyperloglog.HyperLogLog
-	errorMinhash   *minhash.MinHash
-	errorLSH       *lsh.LSH
+	deduper        *BloomFilter
+	pathCounter    *CountMinSketch
+	userCounter    *HyperLogLog
+	sessionCounter *HyperLogLog
+	errorLSH       *LSH // MinHash signatures of error messages, banded for similarity queries
 	errorMessages  map[int]LogEntry
 	nextErrorID    int
+	topPaths       *pathHeap
+	pathUsers      map[string]*HyperLogLog // distinct users, hot paths only
+	exact          *exactCounts            // nil unless Config.ExactMode is set
+	errorCounts    map[string]uint64       // error entries per Classifier category
+	keys           KeyBuilder              // reused for dedup keys
+
+	// Classifier buckets error entries (status >= 400) into report categories
+	Classifier func(LogEntry) string
+
+	// SampleRate is the fraction of entries counted, in (0, 1]; other values count
+	// everything. Hit and error counts are scaled up by 1/SampleRate, while distinct
+	// user and session counts always see every entry.
+	SampleRate float64
+}
+
+// StatusClassClassifier is the default Classifier, bucketing errors by status class ("4xx", "5xx")
+func StatusClassClassifier(entry LogEntry) string {
+	return fmt.Sprintf("%dxx", entry.Status/100)
+}
+
+// exactCounts keeps ground truth next to the sketches, for small inputs and accuracy checks
+type exactCounts struct {
+	entries   map[string]struct{}
+	paths     map[string]uint64
+	pathUsers map[string]map[string]struct{}
+	users     map[string]struct{}
+	sessions  map[string]struct{}
+}
+
+func newExactCounts() *exactCounts {
+	return &exactCounts{
+		entries:   make(map[string]struct{}),
+		paths:     make(map[string]uint64),
+		pathUsers: make(map[string]map[string]struct{}),
+		users:     make(map[string]struct{}),
+		sessions:  make(map[string]struct{}),
+	}
+}
+
+// topPathsCapacity bounds how many candidate heavy-hitter paths are tracked
+const topPathsCapacity = 100
+
+// pathUsersPrecision sizes the per-path HyperLogLogs (2^10 registers, ~3% error).
+// With topPathsCapacity paths that's about 100KB, however many paths the logs contain.
+const pathUsersPrecision = 10
+
+// PathCount pairs a path with its estimated hit count
+type PathCount struct {
+	Path  string
+	Count uint64
+}
+
+// pathHeap is a min-heap of the heaviest paths seen so far, with an index
+// so a path already in the heap can be updated in place
+type pathHeap struct {
+	items []PathCount
+	index map[string]int
+}
+
+func newPathHeap() *pathHeap {
+	return &pathHeap{index: make(map[string]int)}
+}
+
+func (h *pathHeap) Len() int           { return len(h.items) }
+func (h *pathHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+func (h *pathHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].Path] = i
+	h.index[h.items[j].Path] = j
+}
+
+func (h *pathHeap) Push(x any) {
+	pc := x.(PathCount)
+	h.index[pc.Path] = len(h.items)
+	h.items = append(h.items, pc)
+}
+
+func (h *pathHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, last.Path)
+	return last
+}
+
+// offer records a fresh estimate for path, keeping only the heaviest paths.
+// If making room pushed another path out, it's returned as evicted.
+func (h *pathHeap) offer(path string, count uint64) (evicted string) {
+	if i, ok := h.index[path]; ok {
+		h.items[i].Count = count
+		heap.Fix(h, i)
+		return ""
+	}
+	if h.Len() < topPathsCapacity {
+		heap.Push(h, PathCount{Path: path, Count: count})
+		return ""
+	}
+	if count > h.items[0].Count {
+		evicted = heap.Pop(h).(PathCount).Path
+		heap.Push(h, PathCount{Path: path, Count: count})
+	}
+	return evicted
+}
+
+// contains reports whether path is currently one of the tracked heavy hitters
+func (h *pathHeap) contains(path string) bool {
+	_, ok := h.index[path]
+	return ok
+}
+
+// Config sizes the probabilistic structures used by LogAnalyzer
+type Config struct {
+	HLLPrecision   uint    // HyperLogLog uses 2^HLLPrecision registers (4-18)
+	BloomElements  int     // Expected entries for the dedup Bloom filter
+	BloomErrorRate float64 // Bloom filter false positive rate (0-1)
+	CMSWidth       int     // Count-Min Sketch counters per row
+	CMSDepth       int     // Count-Min Sketch rows (hash functions)
+	ExactMode      bool    // Also keep exact counts and report those instead of estimates
+}
+
+// DefaultConfig returns the sizing used by NewLogAnalyzer
+func DefaultConfig() Config {
+	return Config{
+		HLLPrecision:   14,      // 2^14 registers
+		BloomElements:  1000000, // 1M entries
+		BloomErrorRate: 0.01,    // 1% error rate
+		CMSWidth:       10000,   // Track up to 10K paths
+		CMSDepth:       5,       // 5 hash functions
+	}
+}
+
+// Validate checks that the configuration describes usable structures
+func (c Config) Validate() error {
+	if c.HLLPrecision < 4 || c.HLLPrecision > 18 {
+		return fmt.Errorf("HLL precision must be between 4 and 18, got %d", c.HLLPrecision)
+	}
+	if c.BloomElements <= 0 {
+		return fmt.Errorf("bloom filter expected elements must be positive, got %d", c.BloomElements)
+	}
+	if c.BloomErrorRate <= 0 || c.BloomErrorRate >= 1 {
+		return fmt.Errorf("bloom filter false positive rate must be in (0, 1), got %v", c.BloomErrorRate)
+	}
+	if c.CMSWidth <= 0 || c.CMSDepth <= 0 {
+		return fmt.Errorf("CMS dimensions must be positive, got %dx%d", c.CMSWidth, c.CMSDepth)
+	}
+	return nil
+}
+
+// HLLRegisters returns the number of registers in each HyperLogLog
+func (c Config) HLLRegisters() int {
+	return 1 << c.HLLPrecision
+}
+
+// MemoryFootprint approximates the bytes the sketches take: two HyperLogLogs
+// (users and sessions) of one-byte registers, the Bloom filter's bits and the
+// CMS's 4-byte counters. Per-path and error structures come on top of it.
+func (c Config) MemoryFootprint() int {
+	hll := 2 * c.HLLRegisters()
+	bloom := int(optimalBitSize(c.BloomElements, c.BloomErrorRate)) / 8
+	cms := c.CMSWidth * c.CMSDepth * 4
+	return hll + bloom + cms
 }
 
 // NewLogAnalyzer creates a new log analyzer with initialized data structures
 func NewLogAnalyzer() *LogAnalyzer {
 	// Initialize with reasonable defaults for a medium-sized log analysis
-	return &LogAnalyzer{
-		deduper:        bloomfilter.New(1000000, 0.01), // 1M entries, 1% error rate
-		pathCounter:    cms.New(10000, 5),              // Track up to 10K paths with 5 hash functions
-		userCounter:    hyperloglog.New(14),            // 2^14 registers
-		sessionCounter: hyperloglog.New(14),            // 2^14 registers
-		errorMinhash:   minhash.New(100),               // 100 hash functions for error similarity
-		errorLSH:       lsh.New(100, 20, 5),            // bands=20, rows=5 for LSH
+	la, _ := NewLogAnalyzerWithConfig(DefaultConfig())
+	return la
+}
+
+// NewLogAnalyzerWithConfig creates a log analyzer sized for a specific workload.
+// Each HyperLogLog costs 2^HLLPrecision one-byte registers, so precision 10 is
+// ~1KB per counter while 16 is ~64KB; pick the smallest that meets your accuracy needs.
+func NewLogAnalyzerWithConfig(cfg Config) (*LogAnalyzer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	la := &LogAnalyzer{
+		deduper:        NewBloomFilter(cfg.BloomElements, cfg.BloomErrorRate),
+		pathCounter:    newSizedCountMinSketch(uint(cfg.CMSWidth), uint(cfg.CMSDepth)),
+		userCounter:    New(cfg.HLLPrecision),
+		sessionCounter: New(cfg.HLLPrecision),
+		errorLSH:       NewLSH(20, 5), // 100 hash functions for error similarity, in 20 bands of 5 rows
 		errorMessages:  make(map[int]LogEntry),
 		nextErrorID:    0,
+		topPaths:       newPathHeap(),
+		pathUsers:      make(map[string]*HyperLogLog),
+		errorCounts:    make(map[string]uint64),
+		Classifier:     StatusClassClassifier,
+		SampleRate:     1,
+	}
+	if cfg.ExactMode {
+		la.exact = newExactCounts()
+	}
+	return la, nil
+}
+
+// KeyBuilder joins key parts with '-' into a reusable buffer, so building
+// composite keys in hot paths doesn't allocate the way fmt.Sprintf does.
+// The slice returned by Bytes is only valid until the next Reset.
+type KeyBuilder struct {
+	buf   []byte
+	parts int
+}
+
+// Reset empties the builder, keeping its buffer
+func (kb *KeyBuilder) Reset() {
+	kb.buf = kb.buf[:0]
+	kb.parts = 0
+}
+
+// separate adds the separator before every part but the first
+func (kb *KeyBuilder) separate() {
+	if kb.parts > 0 {
+		kb.buf = append(kb.buf, '-')
 	}
+	kb.parts++
+}
+
+// Add appends a string part
+func (kb *KeyBuilder) Add(s string) {
+	kb.separate()
+	kb.buf = append(kb.buf, s...)
+}
+
+// AddInt appends an integer part in decimal
+func (kb *KeyBuilder) AddInt(n int) {
+	kb.separate()
+	kb.buf = strconv.AppendInt(kb.buf, int64(n), 10)
+}
+
+// AddTime appends a timestamp part in RFC 3339
+func (kb *KeyBuilder) AddTime(t time.Time) {
+	kb.separate()
+	kb.buf = t.AppendFormat(kb.buf, time.RFC3339)
+}
+
+// Bytes returns the key built so far
+func (kb *KeyBuilder) Bytes() []byte {
+	return kb.buf
+}
+
+// String returns a copy of the key built so far
+func (kb *KeyBuilder) String() string {
+	return string(kb.buf)
 }
 
 // Hash generates a hash value for string input
@@ -63,46 +297,91 @@ func hash(s string) uint64 {
 
 // ProcessLogEntry processes a single log entry through all data structures
 func (la *LogAnalyzer) ProcessLogEntry(entry LogEntry) {
-	// Create a unique key for deduplication
-	entryKey := fmt.Sprintf("%s-%s-%s-%s-%d",
-		entry.Timestamp.Format(time.RFC3339),
-		entry.IP,
-		entry.UserID,
-		entry.Path,
-		entry.Status)
+	// Create a unique key for deduplication in the analyzer's reused buffer.
+	// entryKey is only valid until the next entry; nothing below keeps it.
+	la.keys.Reset()
+	la.keys.AddTime(entry.Timestamp)
+	la.keys.Add(entry.IP)
+	la.keys.Add(entry.UserID)
+	la.keys.Add(entry.Path)
+	la.keys.AddInt(entry.Status)
+	entryKey := la.keys.Bytes()
+
+	// Distinct counts see every entry, duplicates and sampled-out ones included:
+	// re-adding a value is free, and a sample's distinct count can't be scaled up
+	la.userCounter.Add([]byte(entry.UserID))
+	la.sessionCounter.Add([]byte(entry.SessionID))
+	if la.exact != nil {
+		la.exact.users[entry.UserID] = struct{}{}
+		la.exact.sessions[entry.SessionID] = struct{}{}
+		if la.exact.pathUsers[entry.Path] == nil {
+			la.exact.pathUsers[entry.Path] = make(map[string]struct{})
+		}
+		la.exact.pathUsers[entry.Path][entry.UserID] = struct{}{}
+	}
+
+	// Count distinct users per path, only while the path is a heavy hitter
+	if la.topPaths.contains(entry.Path) {
+		users, ok := la.pathUsers[entry.Path]
+		if !ok {
+			users = New(pathUsersPrecision)
+			la.pathUsers[entry.Path] = users
+		}
+		users.Add([]byte(entry.UserID))
+	}
+
+	if !la.sampled(entryKey) {
+		return
+	}
 
 	// Check if we've seen this exact entry before
-	if la.deduper.Test([]byte(entryKey)) {
+	if la.exact != nil {
+		if _, dup := la.exact.entries[string(entryKey)]; dup {
+			return // Skip duplicate entries, no false positives in exact mode
+		}
+		la.exact.entries[string(entryKey)] = struct{}{}
+		la.exact.paths[entry.Path]++
+	} else if la.deduper.Contains(entryKey) {
 		return // Skip duplicate entries
 	}
 
 	// Add to Bloom filter to mark as seen
-	la.deduper.Add([]byte(entryKey))
+	la.deduper.Add(entryKey)
 
 	// Increment path counter in Count-Min Sketch
-	la.pathCounter.Add([]byte(entry.Path), 1)
-
-	// Add user and session to HyperLogLog for cardinality estimation
-	la.userCounter.Add([]byte(entry.UserID))
-	la.sessionCounter.Add([]byte(entry.SessionID))
+	la.pathCounter.Increment([]byte(entry.Path), 1)
+	if evicted := la.topPaths.offer(entry.Path, la.pathCount(entry.Path)); evicted != "" {
+		delete(la.pathUsers, evicted)
+	}
 
 	// For error messages (status >= 400), process for similarity analysis
 	if entry.Status >= 400 {
-		// Generate MinHash signature for error message
-		la.errorMinhash.Reset()
-		la.errorMinhash.Update([]byte(entry.Message))
-		signature := la.errorMinhash.Signature()
+		la.errorCounts[la.Classifier(entry)]++
 
 		// Store error in our collection
 		la.errorMessages[la.nextErrorID] = entry
 
-		// Add to LSH for similarity queries
-		la.errorLSH.Insert(la.nextErrorID, signature)
+		// Add its MinHash signature to LSH for similarity queries
+		la.errorLSH.AddDocument(la.nextErrorID, messageShingles(entry.Message))
 
 		la.nextErrorID++
 	}
 }
 
+// messageShingles returns the distinct lowercased words of an error message,
+// the set whose Jaccard similarity MinHash estimates
+func messageShingles(message string) []string {
+	seen := make(map[string]struct{})
+	shingles := make([]string, 0, 8)
+	for _, word := range strings.Fields(strings.ToLower(message)) {
+		if _, ok := seen[word]; !ok {
+			seen[word] = struct{}{}
+			shingles = append(shingles, word)
+		}
+	}
+	return shingles
+}
+
 // ParseLogLine converts a raw log line into a structured LogEntry
 func ParseLogLine(line string) (LogEntry, error) {
 	// This is a simplified parser for demonstration
@@ -142,15 +421,10 @@ func ParseLogLine(line string) (LogEntry, error) {
 
 // GetTopPaths returns the estimated most frequent paths
 func (la *LogAnalyzer) GetTopPaths(paths []string, n int) []string {
-	type PathCount struct {
-		Path  string
-		Count uint64
-	}
-
 	// Get count estimates for all paths
 	pathCounts := make([]PathCount, 0, len(paths))
 	for _, path := range paths {
-		count := la.pathCounter.Estimate([]byte(path))
+		count := la.pathCount(path)
 		pathCounts = append(pathCounts, PathCount{Path: path, Count: count})
 	}
 
@@ -172,68 +446,721 @@ func (la *LogAnalyzer) GetTopPaths(paths []string, n int) []string {
 	return result
 }
 
+// GetTopPathsStreaming returns the estimated most frequent paths discovered
+// while processing entries, without needing a list of candidate paths
+func (la *LogAnalyzer) GetTopPathsStreaming(n int) []PathCount {
+	if n <= 0 {
+		return []PathCount{}
+	}
+	pathCounts := make([]PathCount, len(la.topPaths.items))
+	copy(pathCounts, la.topPaths.items)
+
+	sort.Slice(pathCounts, func(i, j int) bool {
+		return pathCounts[i].Count > pathCounts[j].Count
+	})
+
+	if n < len(pathCounts) {
+		pathCounts = pathCounts[:n]
+	}
+	return pathCounts
+}
+
+// pathCount returns the hit count for a path, exact in exact mode and estimated otherwise
+func (la *LogAnalyzer) pathCount(path string) uint64 {
+	if la.exact != nil {
+		return la.scale(la.exact.paths[path])
+	}
+	return la.scale(uint64(la.pathCounter.Count([]byte(path))))
+}
+
+// sampled reports whether the entry with this key falls in the sample.
+// The choice hashes the key, so a rerun over the same logs samples the same entries
+// and a duplicate is always sampled (or skipped) along with its original.
+func (la *LogAnalyzer) sampled(key []byte) bool {
+	if la.SampleRate <= 0 || la.SampleRate >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write(key)
+	return float64(h.Sum64())/math.MaxUint64 < la.SampleRate
+}
+
+// scale turns a count over sampled entries into an estimate over all entries
+func (la *LogAnalyzer) scale(count uint64) uint64 {
+	if la.SampleRate <= 0 || la.SampleRate >= 1 {
+		return count
+	}
+	return uint64(math.Round(float64(count) / la.SampleRate))
+}
+
 // GetUniqueUserCount returns the estimated number of unique users
 func (la *LogAnalyzer) GetUniqueUserCount() uint64 {
+	if la.exact != nil {
+		return uint64(len(la.exact.users))
+	}
 	return la.userCounter.Estimate()
 }
 
+// DistinctUsersForPath returns the estimated number of distinct users that hit path.
+// Only heavy-hitter paths are tracked, so it's 0 for cold paths, and users seen before
+// a path became hot (or while it dropped out of the top paths) aren't counted.
+func (la *LogAnalyzer) DistinctUsersForPath(path string) uint64 {
+	if la.exact != nil {
+		return uint64(len(la.exact.pathUsers[path]))
+	}
+	if users, ok := la.pathUsers[path]; ok {
+		return users.Estimate()
+	}
+	return 0
+}
+
 // GetUniqueSessionCount returns the estimated number of unique sessions
 func (la *LogAnalyzer) GetUniqueSessionCount() uint64 {
+	if la.exact != nil {
+		return uint64(len(la.exact.sessions))
+	}
 	return la.sessionCounter.Estimate()
 }
 
-// FindSimilarErrors finds errors similar to the given one
+// FindSimilarErrors finds errors whose messages are at least threshold similar
+// to errorMsg, by the estimated Jaccard similarity of their words, in arrival order
 func (la *LogAnalyzer) FindSimilarErrors(errorMsg string, threshold float64) []LogEntry {
-	// Create MinHash signature for the query error
-	la.errorMinhash.Reset()
-	la.errorMinhash.Update([]byte(errorMsg))
-	querySignature := la.errorMinhash.Signature()
+	// LSH finds the candidates and keeps those whose signatures are similar enough
+	matches := la.errorLSH.FindSimilar(messageShingles(errorMsg), threshold)
+	ids := make([]int, 0, len(matches))
+	for id := range matches {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
 
-	// Get candidate matches from LSH
-	candidateIDs := la.errorLSH.Query(querySignature)
+	similarErrors := make([]LogEntry, 0, len(ids))
+	for _, id := range ids {
+		similarErrors = append(similarErrors, la.errorMessages[id])
+	}
+	return similarErrors
+}
 
-	// Refine candidates by calculating actual Jaccard similarity
-	similarErrors := make([]LogEntry, 0)
-	for _, id := range candidateIDs {
-		entry := la.errorMessages[id]
+// ClusterErrors groups all collected errors into families of similar messages.
+// Pairs at or above threshold similarity are linked, and linked errors are merged
+// transitively with union-find, so A~B and B~C put A, B and C together even if A
+// and C aren't similar themselves. LSH supplies the candidate pairs, so not every
+// pair is compared. Errors like no other form clusters of one. Clusters are ordered
+// by their earliest error, and errors within a cluster by arrival.
+func (la *LogAnalyzer) ClusterErrors(threshold float64) [][]LogEntry {
+	ids := make([]int, 0, len(la.errorMessages))
+	for id := range la.errorMessages {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
 
-		// Calculate actual similarity
-		la.errorMinhash.Reset()
-		la.errorMinhash.Update([]byte(entry.Message))
-		candidateSignature := la.errorMinhash.Signature()
+	// Union-find over error IDs
+	parent := make(map[int]int, len(ids))
+	for _, id := range ids {
+		parent[id] = id
+	}
+	var find func(id int) int
+	find = func(id int) int {
+		if parent[id] != id {
+			parent[id] = find(parent[id]) // Path compression
+		}
+		return parent[id]
+	}
 
-		similarity := minhash.JaccardSimilarity(querySignature, candidateSignature)
-		if similarity >= threshold {
-			similarErrors = append(similarErrors, entry)
+	for _, id := range ids {
+		similar := la.errorLSH.FindSimilar(messageShingles(la.errorMessages[id].Message), threshold)
+		for other := range similar {
+			if other <= id {
+				continue // Each pair once
+			}
+			// Keep the smaller ID as root so clusters sort by their earliest error
+			a, b := find(id), find(other)
+			if a > b {
+				a, b = b, a
+			}
+			parent[b] = a
 		}
 	}
 
-	return similarErrors
+	var clusters [][]LogEntry
+	clusterOf := make(map[int]int) // root -> index in clusters
+	for _, id := range ids {
+		root := find(id)
+		i, ok := clusterOf[root]
+		if !ok {
+			i = len(clusters)
+			clusterOf[root] = i
+			clusters = append(clusters, nil)
+		}
+		clusters[i] = append(clusters[i], la.errorMessages[id])
+	}
+	return clusters
 }
 
 // GenerateReport creates a summary report of the log analysis
 func (la *LogAnalyzer) GenerateReport(knownPaths []string) string {
 	var report strings.Builder
+	la.WriteReport(&report, knownPaths) // strings.Builder never fails
+	return report.String()
+}
+
+// WriteReport streams the summary report to w instead of building it in memory.
+// Writes are buffered, and the first write error is returned.
+func (la *LogAnalyzer) WriteReport(w io.Writer, knownPaths []string) error {
+	report := bufio.NewWriter(w)
 
-	report.WriteString("=== Log Analysis Report ===\n\n")
+	fmt.Fprint(report, "=== Log Analysis Report ===\n\n")
 
 	// Unique user and session counts
-	report.WriteString(fmt.Sprintf("Estimated unique users: %d\n", la.GetUniqueUserCount()))
-	report.WriteString(fmt.Sprintf("Estimated unique sessions: %d\n\n", la.GetUniqueSessionCount()))
+	fmt.Fprintf(report, "Estimated unique users: %d\n", la.GetUniqueUserCount())
+	fmt.Fprintf(report, "Estimated unique sessions: %d\n\n", la.GetUniqueSessionCount())
 
 	// Top paths
-	report.WriteString("Top 5 paths:\n")
+	fmt.Fprint(report, "Top 5 paths:\n")
 	topPaths := la.GetTopPaths(knownPaths, 5)
 	for i, path := range topPaths {
-		count := la.pathCounter.Estimate([]byte(path))
-		report.WriteString(fmt.Sprintf("%d. %s (approx %d hits)\n", i+1, path, count))
+		count := la.pathCount(path)
+		fmt.Fprintf(report, "%d. %s (approx %d hits)\n", i+1, path, count)
 	}
-	report.WriteString("\n")
+	fmt.Fprint(report, "\n")
 
 	// Error statistics
-	report.WriteString(fmt.Sprintf("Total unique error types: %d\n\n", len(la.errorMessages)))
+	fmt.Fprintf(report, "Total unique error types: %d\n\n", len(la.errorMessages))
 
-	return report.String()
+	// Errors by category
+	categories := make([]string, 0, len(la.errorCounts))
+	for category := range la.errorCounts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	fmt.Fprint(report, "Errors by category:\n")
+	for _, category := range categories {
+		fmt.Fprintf(report, "  %s: %d\n", category, la.scale(la.errorCounts[category]))
+	}
+	fmt.Fprint(report, "\n")
+
+	// bufio.Writer keeps the first error, so checking Flush covers every write
+	return report.Flush()
+}
+
+// WriteReportGzip streams the report to w gzip-compressed, for shipping large
+// reports. The gzip stream is closed, writing its footer, even if the report
+// fails part way; the first error from either is returned. w itself is not closed.
+func (la *LogAnalyzer) WriteReportGzip(w io.Writer, knownPaths []string) error {
+	zw := gzip.NewWriter(w)
+	if err := la.WriteReport(zw, knownPaths); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// The sketches below are chapter 9's own, cut down to what LogAnalyzer calls:
+// BloomFilter from bloomfilter.go, CountMinSketch from countminsketch.go,
+// HyperLogLog from hyperloglog.go, and MinHash and LSH from minhash.go.
+// Every example here is a separate program, so a fix to one of them lands in
+// its home file first and is carried over here.
+
+// BloomFilter represents a Bloom filter data structure
+type BloomFilter struct {
+	bitset []uint64  // Using uint64 for efficient bit operations
+	size   uint      // Size of the bitset in bits
+	k      uint      // Number of hash functions
+	key    [2]uint64 // SipHash key
+}
+
+// NewBloomFilter creates a new Bloom filter optimized for expectedElements with falsePositiveRate.
+// It hashes with a random key, so an adversary can't craft elements that all land on
+// the same bits. It panics if the system's random source fails.
+func NewBloomFilter(expectedElements int, falsePositiveRate float64) *BloomFilter {
+	size := optimalBitSize(expectedElements, falsePositiveRate)
+	var key [16]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		panic(fmt.Errorf("generating bloom filter key: %w", err))
+	}
+	return &BloomFilter{
+		bitset: make([]uint64, (size+63)/64),
+		size:   size,
+		k:      optimalHashCount(size, expectedElements),
+		key:    [2]uint64{binary.LittleEndian.Uint64(key[:]), binary.LittleEndian.Uint64(key[8:])},
+	}
+}
+
+// optimalBitSize calculates the optimal size of the bitset
+func optimalBitSize(n int, p float64) uint {
+	return uint(math.Ceil(-float64(n) * math.Log(p) / math.Pow(math.Log(2), 2)))
+}
+
+// optimalHashCount calculates the optimal number of hash functions
+func optimalHashCount(size uint, n int) uint {
+	return uint(math.Max(1, math.Round(float64(size)/float64(n)*math.Log(2))))
+}
+
+// Add adds an element to the Bloom filter
+func (bf *BloomFilter) Add(data []byte) {
+	for i := uint(0); i < bf.k; i++ {
+		position := bf.getPosition(data, i)
+		index, bit := position/64, position%64
+		bf.bitset[index] |= 1 << bit
+	}
+}
+
+// Contains checks if an element might be in the Bloom filter
+func (bf *BloomFilter) Contains(data []byte) bool {
+	for i := uint(0); i < bf.k; i++ {
+		position := bf.getPosition(data, i)
+		index, bit := position/64, position%64
+		if bf.bitset[index]&(1<<bit) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// getPosition calculates the bit position for a given element and hash function
+func (bf *BloomFilter) getPosition(data []byte, hashNum uint) uint {
+	// Create different hash functions by offsetting the key
+	hash := sipHash24(bf.key[0], bf.key[1]+uint64(hashNum), data)
+	return uint(hash % uint64(bf.size))
+}
+
+// sipHash24 returns the SipHash-2-4 of data under the 128-bit key k0, k1.
+// It's a keyed pseudorandom function: fast enough for hash tables, but without
+// the key its outputs can't be predicted, so collisions can't be precomputed.
+func sipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13) ^ v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16) ^ v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21) ^ v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17) ^ v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	// Two rounds per 8-byte word, the final word padded with the length in its top byte
+	last := uint64(len(data)) << 56
+	for ; len(data) >= 8; data = data[8:] {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	for i, b := range data {
+		last |= uint64(b) << (8 * i)
+	}
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+
+	// Four finalization rounds
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		round()
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// CountMinSketch represents a Count-Min Sketch data structure
+type CountMinSketch struct {
+	matrix [][]uint32
+	width  uint
+	depth  uint
+	total  uint64 // sum of all increments
+}
+
+// newSizedCountMinSketch creates a sketch of depth rows of width counters.
+// Chapter 9's NewCountMinSketch derives those from error bounds instead:
+// width e/ε and depth ln(1/δ).
+func newSizedCountMinSketch(width, depth uint) *CountMinSketch {
+	matrix := make([][]uint32, depth)
+	for i := uint(0); i < depth; i++ {
+		matrix[i] = make([]uint32, width)
+	}
+	return &CountMinSketch{matrix: matrix, width: width, depth: depth}
+}
+
+// Increment adds a count for the given data
+func (cms *CountMinSketch) Increment(data []byte, count uint32) {
+	for i := uint(0); i < cms.depth; i++ {
+		cms.matrix[i][cms.getPosition(data, i)] += count
+	}
+	cms.total += uint64(count)
+}
+
+// Count estimates the count for the given data
+func (cms *CountMinSketch) Count(data []byte) uint32 {
+	var min uint32 = math.MaxUint32
+
+	for i := uint(0); i < cms.depth; i++ {
+		position := cms.getPosition(data, i)
+		if cms.matrix[i][position] < min {
+			min = cms.matrix[i][position]
+		}
+	}
+
+	return min
+}
+
+// getPosition calculates the array position for a given element and hash function
+func (cms *CountMinSketch) getPosition(data []byte, hashNum uint) uint {
+	hash := murmur3.Sum64WithSeed(data, uint32(hashNum))
+	return uint(hash % uint64(cms.width))
+}
+
+// HyperLogLog estimates the number of distinct elements in a stream
+// using 2^precision small registers instead of remembering the elements.
+// Unlike chapter 9's, it keeps every register in the dense array from the
+// start; the estimates are identical.
+type HyperLogLog struct {
+	registers []uint8 // Longest run of leading zeros seen, plus one, per register
+	precision uint    // Number of hash bits used to pick a register
+	m         uint    // Number of registers, 2^precision
+}
+
+// New creates a HyperLogLog with 2^precision registers.
+// The standard error is about 1.04/sqrt(2^precision): 0.8% at precision 14.
+// precision must be between 4 and 18.
+func New(precision uint) *HyperLogLog {
+	if precision < 4 || precision > 18 {
+		panic(fmt.Sprintf("hyperloglog: precision %d out of range [4, 18]", precision))
+	}
+	return &HyperLogLog{
+		registers: make([]uint8, uint(1)<<precision),
+		precision: precision,
+		m:         uint(1) << precision,
+	}
+}
+
+// Add adds an element to the HyperLogLog
+func (hll *HyperLogLog) Add(data []byte) {
+	hash := murmur3.Sum64(data)
+
+	// The first precision bits pick the register
+	index := hash >> (64 - hll.precision)
+
+	// The rest give the rank: position of the first 1 bit. The sentinel bit
+	// caps the rank when every remaining bit is zero.
+	rest := hash<<hll.precision | 1<<(hll.precision-1)
+	rank := uint8(bits.LeadingZeros64(rest) + 1)
+
+	if rank > hll.registers[index] {
+		hll.registers[index] = rank
+	}
+}
+
+// Estimate returns the estimated number of distinct elements added
+func (hll *HyperLogLog) Estimate() uint64 {
+	m := float64(hll.m)
+
+	// Harmonic mean of 2^register across all registers, tallying ranks first
+	var ranks [66]uint64 // Ranks go up to 64-precision+1
+	for _, rank := range hll.registers {
+		ranks[rank]++
+	}
+	zeros := uint(ranks[0])
+
+	sum := 0.0
+	for rank, count := range ranks {
+		sum += float64(count) * math.Ldexp(1, -rank)
+	}
+	estimate := alpha(hll.m) * m * m / sum
+
+	// HyperLogLog++ corrections. Below 5m the raw estimate runs high, by as much
+	// as 70% of m when empty, so the measured bias is subtracted. With empty
+	// registers left, linear counting is more accurate still up to a threshold.
+	// The 64-bit hash makes a large range correction unnecessary.
+	if estimate <= 5*m {
+		estimate -= m * rawEstimateBias(hll.precision, estimate/m)
+	}
+	if zeros > 0 {
+		if linear := m * math.Log(m/float64(zeros)); linear <= linearCountingThreshold[hll.precision-4] {
+			estimate = linear
+		}
+	}
+
+	return uint64(math.Round(max(estimate, 0)))
+}
+
+// linearCountingThreshold is, by precision from 4, the cardinality below which
+// linear counting beats the bias-corrected estimate (from the HyperLogLog++ paper)
+var linearCountingThreshold = [...]float64{
+	10, 20, 40, 80, 220, 400, 900, 1800, 3100, 6500, 11500, 20000, 50000, 120000, 350000,
+}
+
+// rawEstimateBias returns the average amount by which the raw estimate overshoots
+// the true cardinality, interpolated linearly in the table for precision. Both
+// are divided by m. Measured that way, the bias is the same at every precision
+// from 7 up; below that, the fixed alpha constants shift it.
+func rawEstimateBias(precision uint, estimate float64) float64 {
+	table := rawEstimateBias7
+	switch precision {
+	case 4:
+		table = rawEstimateBias4
+	case 5:
+		table = rawEstimateBias5
+	case 6:
+		table = rawEstimateBias6
+	}
+
+	if estimate <= table[0][0] {
+		return table[0][1]
+	}
+	for i := 1; i < len(table); i++ {
+		if estimate <= table[i][0] {
+			lo, hi := table[i-1], table[i]
+			return lo[1] + (hi[1]-lo[1])*(estimate-lo[0])/(hi[0]-lo[0])
+		}
+	}
+	return table[len(table)-1][1]
+}
+
+// Raw estimate and its bias, both divided by m, at 41 cardinalities from 0 to 5m.
+// Each point averages the raw estimates of many simulated sketches: a million at
+// precisions 4 and 5, half a million at 6, and 16,384 at 14 for the shared table.
+var (
+	rawEstimateBias4 = [][2]float64{
+		{0.6730, 0.6730}, {0.7326, 0.6076}, {0.7962, 0.5462}, {0.8636, 0.4886}, {0.9350, 0.4350},
+		{1.0104, 0.3854}, {1.0897, 0.3397}, {1.1729, 0.2979}, {1.2597, 0.2597}, {1.3503, 0.2253},
+		{1.4444, 0.1944}, {1.5418, 0.1668}, {1.6425, 0.1425}, {1.7461, 0.1211}, {1.8524, 0.1024},
+		{1.9610, 0.0860}, {2.0716, 0.0716}, {2.1847, 0.0597}, {2.2995, 0.0495}, {2.4157, 0.0407},
+		{2.5330, 0.0330}, {2.6520, 0.0270}, {2.7721, 0.0221}, {2.8927, 0.0177}, {3.0142, 0.0142},
+		{3.1366, 0.0116}, {3.2592, 0.0092}, {3.3821, 0.0071}, {3.5055, 0.0055}, {3.6292, 0.0042},
+		{3.7530, 0.0030}, {3.8772, 0.0022}, {4.0016, 0.0016}, {4.1256, 0.0006}, {4.2500, 0.0000},
+		{4.3746, -0.0004}, {4.4994, -0.0006}, {4.6245, -0.0005}, {4.7497, -0.0003}, {4.8750, 0.0000},
+		{4.9998, -0.0002},
+	}
+	rawEstimateBias5 = [][2]float64{
+		{0.6970, 0.6970}, {0.7578, 0.6328}, {0.8223, 0.5723}, {0.8906, 0.5156}, {0.9627, 0.4627},
+		{1.0385, 0.4135}, {1.1180, 0.3680}, {1.2011, 0.3261}, {1.2878, 0.2878}, {1.3778, 0.2528},
+		{1.4712, 0.2212}, {1.5674, 0.1924}, {1.6669, 0.1669}, {1.7689, 0.1439}, {1.8737, 0.1237},
+		{1.9807, 0.1057}, {2.0899, 0.0899}, {2.2012, 0.0762}, {2.3142, 0.0642}, {2.4291, 0.0541},
+		{2.5451, 0.0451}, {2.6624, 0.0374}, {2.7809, 0.0309}, {2.9007, 0.0257}, {3.0212, 0.0212},
+		{3.1423, 0.0173}, {3.2639, 0.0139}, {3.3859, 0.0109}, {3.5086, 0.0086}, {3.6318, 0.0068},
+		{3.7553, 0.0053}, {3.8790, 0.0040}, {4.0029, 0.0029}, {4.1273, 0.0023}, {4.2517, 0.0017},
+		{4.3761, 0.0011}, {4.5012, 0.0012}, {4.6257, 0.0007}, {4.7504, 0.0004}, {4.8750, -0.0000},
+		{5.0000, -0.0000},
+	}
+	rawEstimateBias6 = [][2]float64{
+		{0.7090, 0.7090}, {0.7704, 0.6454}, {0.8354, 0.5854}, {0.9041, 0.5291}, {0.9765, 0.4765},
+		{1.0524, 0.4274}, {1.1320, 0.3820}, {1.2152, 0.3402}, {1.3017, 0.3017}, {1.3915, 0.2665},
+		{1.4845, 0.2345}, {1.5805, 0.2055}, {1.6794, 0.1794}, {1.7811, 0.1561}, {1.8850, 0.1350},
+		{1.9915, 0.1165}, {2.1001, 0.1001}, {2.2106, 0.0856}, {2.3230, 0.0730}, {2.4367, 0.0617},
+		{2.5520, 0.0520}, {2.6688, 0.0438}, {2.7866, 0.0366}, {2.9054, 0.0304}, {3.0250, 0.0250},
+		{3.1452, 0.0202}, {3.2666, 0.0166}, {3.3884, 0.0134}, {3.5104, 0.0104}, {3.6333, 0.0083},
+		{3.7567, 0.0067}, {3.8803, 0.0053}, {4.0039, 0.0039}, {4.1281, 0.0031}, {4.2524, 0.0024},
+		{4.3768, 0.0018}, {4.5012, 0.0012}, {4.6258, 0.0008}, {4.7506, 0.0006}, {4.8751, 0.0001},
+		{4.9996, -0.0004},
+	}
+	rawEstimateBias7 = [][2]float64{
+		{0.7213, 0.7213}, {0.7832, 0.6582}, {0.8487, 0.5987}, {0.9178, 0.5428}, {0.9905, 0.4905},
+		{1.0667, 0.4417}, {1.1465, 0.3965}, {1.2296, 0.3546}, {1.3161, 0.3161}, {1.4058, 0.2808},
+		{1.4985, 0.2485}, {1.5942, 0.2192}, {1.6926, 0.1926}, {1.7937, 0.1687}, {1.8972, 0.1472},
+		{2.0030, 0.1280}, {2.1108, 0.1108}, {2.2206, 0.0956}, {2.3322, 0.0822}, {2.4455, 0.0705},
+		{2.5602, 0.0602}, {2.6763, 0.0513}, {2.7936, 0.0436}, {2.9118, 0.0368}, {3.0310, 0.0310},
+		{3.1511, 0.0261}, {3.2719, 0.0219}, {3.3932, 0.0182}, {3.5152, 0.0152}, {3.6376, 0.0126},
+		{3.7605, 0.0105}, {3.8837, 0.0087}, {4.0072, 0.0072}, {4.1308, 0.0058}, {4.2548, 0.0048},
+		{4.3789, 0.0039}, {4.5032, 0.0032}, {4.6276, 0.0026}, {4.7521, 0.0021}, {4.8768, 0.0018},
+		{5.0015, 0.0015},
+	}
+)
+
+// alpha is the bias correction constant for m registers
+func alpha(m uint) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
+
+// MinHash represents a MinHash signature generator
+type MinHash struct {
+	numHashes int
+	seeds     []uint32
+}
+
+// New creates a new MinHash with the specified number of hash functions
+func NewMinHash(numHashes int) *MinHash {
+	seeds := make([]uint32, numHashes)
+	for i := 0; i < numHashes; i++ {
+		seeds[i] = uint32(i + 1) // Simple seed generation
+	}
+
+	return &MinHash{
+		numHashes: numHashes,
+		seeds:     seeds,
+	}
+}
+
+// Signature generates a MinHash signature for a set of strings
+func (mh *MinHash) Signature(set []string) []uint32 {
+	signature := make([]uint32, mh.numHashes)
+
+	// Initialize with max uint32 values
+	for i := range signature {
+		signature[i] = ^uint32(0) // max uint32
+	}
+
+	// Update signature for each element in the set
+	for _, s := range set {
+		for i, seed := range mh.seeds {
+			hash := murmur3.Sum32WithSeed([]byte(s), seed)
+			if hash < signature[i] {
+				signature[i] = hash
+			}
+		}
+	}
+
+	return signature
+}
+
+// Similarity calculates the estimated Jaccard similarity between two signatures
+func (mh *MinHash) Similarity(sig1, sig2 []uint32) float64 {
+	if len(sig1) != mh.numHashes || len(sig2) != mh.numHashes {
+		return 0.0
+	}
+
+	// Count matching elements
+	matches := 0
+	for i := 0; i < mh.numHashes; i++ {
+		if sig1[i] == sig2[i] {
+			matches++
+		}
+	}
+
+	return float64(matches) / float64(mh.numHashes)
+}
+
+// LSH represents a Locality Sensitive Hashing index
+type LSH struct {
+	bands      int
+	rows       int
+	hashTables []map[string][]int
+	minHash    *MinHash
+	signatures []uint32 // Columnar store: docID's signature starts at docID*numHashes
+}
+
+// New creates a new LSH index
+func NewLSH(bands, rows int) *LSH {
+	hashTables := make([]map[string][]int, bands)
+	for i := range hashTables {
+		hashTables[i] = make(map[string][]int)
+	}
+
+	return &LSH{
+		bands:      bands,
+		rows:       rows,
+		hashTables: hashTables,
+		minHash:    NewMinHash(bands * rows),
+	}
+}
+
+// AddDocument adds a document to the LSH index
+func (lsh *LSH) AddDocument(docID int, shingles []string) {
+	// Generate signature
+	signature := lsh.minHash.Signature(shingles)
+	lsh.storeSignature(docID, signature)
+
+	// Split signature into bands
+	for i := 0; i < lsh.bands; i++ {
+		start := i * lsh.rows
+		end := start + lsh.rows
+		if end > len(signature) {
+			end = len(signature)
+		}
+
+		// Create a band signature
+		bandSig := signature[start:end]
+
+		// Convert band to string representation
+		bandKey := bandToString(bandSig)
+
+		// Add document to the band's bucket
+		lsh.hashTables[i][bandKey] = append(lsh.hashTables[i][bandKey], docID)
+	}
+}
+
+// FindSimilar finds similar documents to the query
+func (lsh *LSH) FindSimilar(shingles []string, threshold float64) map[int]float64 {
+	// Generate signature for query
+	signature := lsh.minHash.Signature(shingles)
+
+	// Track candidates and actual similarities
+	candidates := make(map[int]struct{})
+	similarities := make(map[int]float64)
+
+	// Find candidates from each band
+	for i := 0; i < lsh.bands; i++ {
+		start := i * lsh.rows
+		end := start + lsh.rows
+		if end > len(signature) {
+			end = len(signature)
+		}
+
+		// Get band signature
+		bandSig := signature[start:end]
+		bandKey := bandToString(bandSig)
+
+		// Get documents in the same bucket
+		for _, docID := range lsh.hashTables[i][bandKey] {
+			candidates[docID] = struct{}{}
+		}
+	}
+
+	// Compute actual similarities for candidates
+	for docID := range candidates {
+		similarity := lsh.minHash.Similarity(signature, lsh.signatureOf(docID))
+
+		if similarity >= threshold {
+			similarities[docID] = similarity
+		}
+	}
+
+	return similarities
+}
+
+// storeSignature copies a document's signature into the columnar store.
+// A single contiguous slice avoids a separate allocation per document and keeps
+// signatures next to each other in memory while refining candidates.
+// Document IDs are expected to be dense (0, 1, 2, ...), as DocumentSet assigns them.
+func (lsh *LSH) storeSignature(docID int, signature []uint32) {
+	numHashes := lsh.minHash.numHashes
+	if need := (docID + 1) * numHashes; need > len(lsh.signatures) {
+		lsh.signatures = append(lsh.signatures, make([]uint32, need-len(lsh.signatures))...)
+	}
+	copy(lsh.signatures[docID*numHashes:], signature)
+}
+
+// signatureOf returns a view of docID's stored signature (not a copy)
+func (lsh *LSH) signatureOf(docID int) []uint32 {
+	numHashes := lsh.minHash.numHashes
+	start := docID * numHashes
+	return lsh.signatures[start : start+numHashes : start+numHashes]
+}
+
+// bandToString converts a band signature to a string representation
+func bandToString(band []uint32) string {
+	// Simple hash function for the band
+	h := uint32(math.MaxUint32)
+	for _, v := range band {
+		h ^= v
+		h *= uint32(math.MaxUint32) / 2
+	}
+	return fmt.Sprintf("%d", h)
 }
 
 func main() {
@@ -289,9 +1216,22 @@ func main() {
 		return
 	}
 
-	// Generate and print report
+	// Stream the report straight to stdout
 	fmt.Printf("Processed %d log lines (%d errors)\n\n", linesProcessed, errorLogs)
-	fmt.Println(analyzer.GenerateReport(knownPaths))
+	if err := analyzer.WriteReport(os.Stdout, knownPaths); err != nil {
+		fmt.Printf("Error writing report: %v\n", err)
+		return
+	}
+
+	// And a compressed copy to ship elsewhere
+	if out, err := os.Create("report.txt.gz"); err != nil {
+		fmt.Printf("Error creating report file: %v\n", err)
+	} else {
+		if err := analyzer.WriteReportGzip(out, knownPaths); err != nil {
+			fmt.Printf("Error writing compressed report: %v\n", err)
+		}
+		out.Close()
+	}
 
 	// Demonstrate finding similar errors
 	if errorLogs > 0 {
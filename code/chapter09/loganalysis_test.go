@@ -0,0 +1,461 @@
+package main
+
+// Run with: go test loganalysis.go loganalysis_test.go
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigPrecisionSizing(t *testing.T) {
+	small, large := DefaultConfig(), DefaultConfig()
+	small.HLLPrecision, large.HLLPrecision = 10, 16
+
+	for _, cfg := range []Config{small, large} {
+		if _, err := NewLogAnalyzerWithConfig(cfg); err != nil {
+			t.Fatalf("precision %d: %v", cfg.HLLPrecision, err)
+		}
+	}
+
+	if small.HLLRegisters() != 1024 || large.HLLRegisters() != 65536 {
+		t.Errorf("registers = %d and %d, want 1024 and 65536", small.HLLRegisters(), large.HLLRegisters())
+	}
+	// Only the two HyperLogLogs differ between the configs
+	if diff := large.MemoryFootprint() - small.MemoryFootprint(); diff != 2*(65536-1024) {
+		t.Errorf("footprint difference = %d bytes, want %d", diff, 2*(65536-1024))
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	bad := []func(*Config){
+		func(c *Config) { c.HLLPrecision = 3 },
+		func(c *Config) { c.HLLPrecision = 19 },
+		func(c *Config) { c.BloomElements = 0 },
+		func(c *Config) { c.BloomErrorRate = 1 },
+		func(c *Config) { c.CMSWidth = 0 },
+		func(c *Config) { c.CMSDepth = -1 },
+	}
+	for i, mutate := range bad {
+		cfg := DefaultConfig()
+		mutate(&cfg)
+		if _, err := NewLogAnalyzerWithConfig(cfg); err == nil {
+			t.Errorf("case %d: invalid config %+v accepted", i, cfg)
+		}
+	}
+}
+
+func TestGetTopPathsStreaming(t *testing.T) {
+	la := NewLogAnalyzer()
+	// A skewed stream: /hot/0 gets 200 hits, /hot/1 180, ... and a long tail of one-off paths
+	for rank := 0; rank < 5; rank++ {
+		for i := 0; i < 200-20*rank; i++ {
+			la.ProcessLogEntry(LogEntry{
+				Timestamp: time.Unix(int64(rank*1000+i), 0),
+				UserID:    fmt.Sprint("user", i),
+				Path:      fmt.Sprint("/hot/", rank),
+				Status:    200,
+			})
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		la.ProcessLogEntry(LogEntry{
+			Timestamp: time.Unix(int64(10000+i), 0),
+			Path:      fmt.Sprint("/tail/", i),
+			Status:    200,
+		})
+	}
+
+	top := la.GetTopPathsStreaming(5)
+	if len(top) != 5 {
+		t.Fatalf("got %d paths, want 5", len(top))
+	}
+	for rank, pc := range top {
+		if want := fmt.Sprint("/hot/", rank); pc.Path != want {
+			t.Errorf("top[%d] = %s, want %s", rank, pc.Path, want)
+		}
+		if want := uint64(200 - 20*rank); pc.Count < want {
+			t.Errorf("%s count = %d, want at least %d", pc.Path, pc.Count, want)
+		}
+	}
+
+	for _, n := range []int{0, -1} {
+		if got := la.GetTopPathsStreaming(n); len(got) != 0 {
+			t.Errorf("GetTopPathsStreaming(%d) returned %d paths, want none", n, len(got))
+		}
+	}
+}
+
+func TestApproximateWithinErrorOfExact(t *testing.T) {
+	exactCfg := DefaultConfig()
+	exactCfg.ExactMode = true
+	exact, err := NewLogAnalyzerWithConfig(exactCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approx := NewLogAnalyzer()
+
+	const entries = 20000
+	paths := []string{"/", "/login", "/cart", "/search", "/checkout"}
+	for i := 0; i < entries; i++ {
+		entry := LogEntry{
+			Timestamp: time.Unix(int64(i), 0),
+			UserID:    fmt.Sprint("user", i%3000),
+			SessionID: fmt.Sprint("session", i%7000),
+			Path:      paths[i*i%len(paths)],
+			Status:    200,
+		}
+		exact.ProcessLogEntry(entry)
+		approx.ProcessLogEntry(entry)
+	}
+
+	if got := exact.GetUniqueUserCount(); got != 3000 {
+		t.Fatalf("exact users = %d, want 3000", got)
+	}
+	// HyperLogLog at precision 14: standard error 1.04/sqrt(2^14) ≈ 0.8%, allow 3 of them
+	hllBound := 3 * 1.04 / math.Sqrt(float64(DefaultConfig().HLLRegisters()))
+	for _, c := range []struct {
+		name          string
+		exact, approx uint64
+	}{
+		{"users", exact.GetUniqueUserCount(), approx.GetUniqueUserCount()},
+		{"sessions", exact.GetUniqueSessionCount(), approx.GetUniqueSessionCount()},
+	} {
+		if rel := math.Abs(float64(c.approx)-float64(c.exact)) / float64(c.exact); rel > hllBound {
+			t.Errorf("%s: approx %d vs exact %d, relative error %.4f > %.4f", c.name, c.approx, c.exact, rel, hllBound)
+		}
+	}
+
+	// CMS overcounts by at most e/width of the stream (with high probability);
+	// Bloom false positives in dedup can only drop a few entries
+	cmsBound := math.E / float64(DefaultConfig().CMSWidth) * entries
+	for _, path := range paths {
+		e, a := float64(exact.pathCount(path)), float64(approx.pathCount(path))
+		if a > e+cmsBound || a < e*0.99 {
+			t.Errorf("%s: approx %v vs exact %v, outside [%.0f, %.0f]", path, a, e, e*0.99, e+cmsBound)
+		}
+	}
+}
+
+func TestDistinctUsersForPath(t *testing.T) {
+	la := NewLogAnalyzer()
+	want := map[string]int{"/a": 800, "/b": 50, "/c": 5}
+	ts := int64(0)
+	for path, users := range want {
+		// Every user hits the path 3 times; repeats must not count twice
+		for round := 0; round < 3; round++ {
+			for u := 0; u < users; u++ {
+				ts++
+				la.ProcessLogEntry(LogEntry{
+					Timestamp: time.Unix(ts, 0),
+					UserID:    fmt.Sprint("user", u),
+					Path:      path,
+					Status:    200,
+				})
+			}
+		}
+	}
+
+	// Per-path HLLs use 2^pathUsersPrecision registers; allow 3 standard errors
+	bound := 3 * 1.04 / math.Sqrt(float64(uint(1)<<pathUsersPrecision))
+	for path, users := range want {
+		got := la.DistinctUsersForPath(path)
+		if rel := math.Abs(float64(got)-float64(users)) / float64(users); rel > bound {
+			t.Errorf("%s: %d distinct users, want %d within %.1f%%", path, got, users, bound*100)
+		}
+	}
+	if got := la.DistinctUsersForPath("/never-seen"); got != 0 {
+		t.Errorf("unseen path has %d distinct users, want 0", got)
+	}
+}
+
+func TestCustomErrorClassifier(t *testing.T) {
+	categories := []struct {
+		name string
+		re   *regexp.Regexp
+	}{
+		{"timeout", regexp.MustCompile(`(?i)timed? ?out`)},
+		{"auth", regexp.MustCompile(`(?i)unauthori[sz]ed|forbidden|token`)},
+		{"not-found", regexp.MustCompile(`(?i)not found`)},
+	}
+	la := NewLogAnalyzer()
+	la.Classifier = func(e LogEntry) string {
+		for _, c := range categories {
+			if c.re.MatchString(e.Message) {
+				return c.name
+			}
+		}
+		return "other"
+	}
+
+	entries := []struct {
+		status  int
+		message string
+	}{
+		{504, "upstream timed out"},
+		{500, "database timeout after 30s"},
+		{401, "Unauthorized: missing token"},
+		{403, "forbidden"},
+		{404, "page not found"},
+		{500, "nil pointer dereference"},
+		{200, "request timeout recovered"}, // Not an error, never classified
+	}
+	for i, e := range entries {
+		la.ProcessLogEntry(LogEntry{Timestamp: time.Unix(int64(i), 0), Path: "/", Status: e.status, Message: e.message})
+	}
+
+	want := map[string]uint64{"timeout": 2, "auth": 2, "not-found": 1, "other": 1}
+	if len(la.errorCounts) != len(want) {
+		t.Errorf("categories = %v, want %v", la.errorCounts, want)
+	}
+	for category, n := range want {
+		if la.errorCounts[category] != n {
+			t.Errorf("%s = %d, want %d", category, la.errorCounts[category], n)
+		}
+	}
+
+	report := la.GenerateReport(nil)
+	for _, line := range []string{"  auth: 2\n", "  not-found: 1\n", "  other: 1\n", "  timeout: 2\n"} {
+		if !strings.Contains(report, line) {
+			t.Errorf("report is missing %q:\n%s", line, report)
+		}
+	}
+}
+
+func TestStatusClassClassifier(t *testing.T) {
+	la := NewLogAnalyzer()
+	for i, status := range []int{404, 401, 500, 503, 302} {
+		la.ProcessLogEntry(LogEntry{Timestamp: time.Unix(int64(i), 0), Path: "/", Status: status})
+	}
+	if la.errorCounts["4xx"] != 2 || la.errorCounts["5xx"] != 2 || len(la.errorCounts) != 2 {
+		t.Errorf("status classes = %v, want 2 4xx and 2 5xx", la.errorCounts)
+	}
+}
+
+func TestKeyBuilderMatchesSprintf(t *testing.T) {
+	e := LogEntry{Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), IP: "10.0.0.1", UserID: "u1", Path: "/cart", Status: 404}
+	var kb KeyBuilder
+	for i := 0; i < 2; i++ { // The second round reuses the buffer
+		kb.Reset()
+		kb.AddTime(e.Timestamp)
+		kb.Add(e.IP)
+		kb.Add(e.UserID)
+		kb.Add(e.Path)
+		kb.AddInt(e.Status)
+		want := fmt.Sprintf("%s-%s-%s-%s-%d", e.Timestamp.Format(time.RFC3339), e.IP, e.UserID, e.Path, e.Status)
+		if kb.String() != want || string(kb.Bytes()) != want {
+			t.Errorf("round %d: key = %q, want %q", i, kb.String(), want)
+		}
+	}
+}
+
+// BenchmarkDedupKey compares building the dedup key with a reused KeyBuilder and with fmt.Sprintf
+func BenchmarkDedupKey(b *testing.B) {
+	e := LogEntry{Timestamp: time.Now(), IP: "10.0.0.1", UserID: "user-42", Path: "/checkout", Status: 200}
+
+	b.Run("KeyBuilder", func(b *testing.B) {
+		b.ReportAllocs()
+		var kb KeyBuilder
+		for i := 0; i < b.N; i++ {
+			kb.Reset()
+			kb.AddTime(e.Timestamp)
+			kb.Add(e.IP)
+			kb.Add(e.UserID)
+			kb.Add(e.Path)
+			kb.AddInt(e.Status)
+			_ = kb.Bytes()
+		}
+	})
+	b.Run("Sprintf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = []byte(fmt.Sprintf("%s-%s-%s-%s-%d", e.Timestamp.Format(time.RFC3339), e.IP, e.UserID, e.Path, e.Status))
+		}
+	})
+}
+
+// BenchmarkProcessLogEntry reports the allocations per entry with the dedup key
+// built in the analyzer's reused buffer
+func BenchmarkProcessLogEntry(b *testing.B) {
+	la := NewLogAnalyzer()
+	e := LogEntry{IP: "10.0.0.1", UserID: "user-42", Path: "/checkout", Status: 200, Message: "ok"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Timestamp = time.Unix(int64(i), 0)
+		la.ProcessLogEntry(e)
+	}
+}
+
+func TestSampledEstimatesMatchFull(t *testing.T) {
+	full := NewLogAnalyzer()
+	newSampled := func() *LogAnalyzer {
+		la := NewLogAnalyzer()
+		la.SampleRate = 0.2
+		return la
+	}
+	sampled, rerun := newSampled(), newSampled()
+
+	const entries = 50000
+	paths := []string{"/", "/login", "/cart", "/search", "/checkout"}
+	for i := 0; i < entries; i++ {
+		entry := LogEntry{
+			Timestamp: time.Unix(int64(i), 0),
+			UserID:    fmt.Sprint("user", i%3000),
+			SessionID: fmt.Sprint("session", i%7000),
+			Path:      paths[i%len(paths)],
+			Status:    200,
+		}
+		full.ProcessLogEntry(entry)
+		sampled.ProcessLogEntry(entry)
+		rerun.ProcessLogEntry(entry)
+	}
+
+	// Each path sees 10000 entries, so about 2000 are sampled; a binomial standard
+	// deviation of 40 is 2% of that, and 10% leaves five deviations of headroom
+	for _, path := range paths {
+		f, s := float64(full.pathCount(path)), float64(sampled.pathCount(path))
+		if rel := math.Abs(s-f) / f; rel > 0.10 {
+			t.Errorf("%s: sampled estimate %v vs full %v, relative error %.3f", path, s, f, rel)
+		}
+		if r := rerun.pathCount(path); r != sampled.pathCount(path) {
+			t.Errorf("%s: rerun estimate %d differs from %d, sampling is not deterministic", path, r, sampled.pathCount(path))
+		}
+	}
+
+	// Distinct counts see every entry, so sampling leaves them untouched
+	if s, f := sampled.GetUniqueUserCount(), full.GetUniqueUserCount(); s != f {
+		t.Errorf("sampled users = %d, want %d as in the full run", s, f)
+	}
+	if s, f := sampled.GetUniqueSessionCount(), full.GetUniqueSessionCount(); s != f {
+		t.Errorf("sampled sessions = %d, want %d as in the full run", s, f)
+	}
+}
+
+// failingWriter rejects every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestWriteReportMatchesGenerateReport(t *testing.T) {
+	la := NewLogAnalyzer()
+	paths := []string{"/", "/login", "/cart"}
+	for i := 0; i < 500; i++ {
+		la.ProcessLogEntry(LogEntry{
+			Timestamp: time.Unix(int64(i), 0),
+			UserID:    fmt.Sprint("user", i%40),
+			SessionID: fmt.Sprint("session", i%90),
+			Path:      paths[i%len(paths)],
+			Status:    []int{200, 404, 500}[i%7%3],
+			Message:   fmt.Sprint("failure in handler ", i%5),
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := la.WriteReport(&buf, paths); err != nil {
+		t.Fatal(err)
+	}
+	if want := la.GenerateReport(paths); buf.String() != want {
+		t.Errorf("WriteReport wrote\n%s\nGenerateReport returned\n%s", buf.String(), want)
+	}
+	if !strings.Contains(buf.String(), "Errors by category:") {
+		t.Errorf("report is missing its sections:\n%s", buf.String())
+	}
+
+	if err := la.WriteReport(failingWriter{}, paths); err == nil {
+		t.Error("WriteReport to a failing writer returned nil")
+	}
+}
+
+func TestClusterErrorsFamilies(t *testing.T) {
+	la := NewLogAnalyzer()
+	// Messages are compared by their words, so a family is one message whose
+	// replica number varies: any two share 8 of 10 words. The families arrive interleaved.
+	families := []string{
+		"database connection timeout after 30 seconds on replica %d",
+		"permission denied for user admin on resource bucket %d",
+		"upstream service returned malformed JSON body for request %d",
+	}
+	familyOf := make(map[string]int)
+	for i := 0; i < 12; i++ {
+		message := fmt.Sprintf(families[i%len(families)], i)
+		familyOf[message] = i % len(families)
+		la.ProcessLogEntry(LogEntry{
+			Timestamp: time.Unix(int64(i), 0),
+			UserID:    fmt.Sprint("user", i),
+			Path:      "/api",
+			Status:    500,
+			Message:   message,
+		})
+	}
+	la.ProcessLogEntry(LogEntry{Timestamp: time.Unix(99, 0), Path: "/", Status: 200, Message: "ok"})
+
+	clusters := la.ClusterErrors(0.6)
+	if len(clusters) != 3 {
+		t.Fatalf("got %d clusters, want 3", len(clusters))
+	}
+	for i, cluster := range clusters {
+		if len(cluster) != 4 {
+			t.Errorf("cluster %d has %d errors, want 4", i, len(cluster))
+		}
+		// Ordered by earliest error, so cluster i is family i
+		for _, entry := range cluster {
+			if familyOf[entry.Message] != i {
+				t.Errorf("cluster %d mixes %q into family %q", i, entry.Message, families[i])
+			}
+		}
+	}
+
+	// A new variant finds its whole family, in arrival order, and nothing else
+	similar := la.FindSimilarErrors(fmt.Sprintf(families[1], 99), 0.6)
+	if len(similar) != 4 {
+		t.Fatalf("FindSimilarErrors found %d errors, want the 4 in its family", len(similar))
+	}
+	for i, entry := range similar {
+		if familyOf[entry.Message] != 1 || (i > 0 && !similar[i-1].Timestamp.Before(entry.Timestamp)) {
+			t.Errorf("FindSimilarErrors[%d] = %q at %v, want family 1 in arrival order", i, entry.Message, entry.Timestamp)
+		}
+	}
+}
+
+func TestWriteReportGzipRoundTrip(t *testing.T) {
+	la := NewLogAnalyzer()
+	paths := []string{"/", "/login"}
+	for i := 0; i < 200; i++ {
+		la.ProcessLogEntry(LogEntry{
+			Timestamp: time.Unix(int64(i), 0),
+			UserID:    fmt.Sprint("user", i%25),
+			Path:      paths[i%2],
+			Status:    []int{200, 503}[i%9/8],
+			Message:   "backend unavailable",
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := la.WriteReportGzip(&buf, paths); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := io.ReadAll(zr) // Fails on a truncated stream, so the footer was written
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := la.GenerateReport(paths); string(report) != want {
+		t.Errorf("decompressed report\n%s\nwant\n%s", report, want)
+	}
+
+	if err := la.WriteReportGzip(failingWriter{}, paths); err == nil {
+		t.Error("WriteReportGzip to a failing writer returned nil")
+	}
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/spaolacci/murmur3"
 )
@@ -55,6 +61,62 @@ func (mh *MinHash) Signature(set []string) []uint32 {
 	return signature
 }
 
+// WeightedSignature generates a signature for a set whose elements carry weights,
+// such as shingle counts, using Ioffe's improved consistent weighted sampling.
+// Matching positions between two such signatures estimate the weighted Jaccard
+// similarity, sum(min(a, b)) / sum(max(a, b)), so Similarity compares them as usual,
+// and elements with a larger weight have a proportionally larger say.
+// Elements with a weight of zero or less are ignored.
+func (mh *MinHash) WeightedSignature(set map[string]int) []uint32 {
+	signature := make([]uint32, mh.numHashes)
+
+	for i, seed := range mh.seeds {
+		best := math.Inf(1)
+		var bestKey string
+		var bestT float64
+
+		for key, weight := range set {
+			if weight <= 0 {
+				continue
+			}
+
+			// The same key and seed always draw the same samples, which is what
+			// makes the sampling consistent across sets
+			rng := splitMix64(murmur3.Sum64WithSeed([]byte(key), seed))
+			r := -math.Log(rng.float() * rng.float()) // Gamma(2, 1)
+			c := -math.Log(rng.float() * rng.float()) // Gamma(2, 1)
+			beta := rng.float()
+
+			t := math.Floor(math.Log(float64(weight))/r + beta)
+			y := math.Exp(r * (t - beta))
+			if a := c / (y * math.Exp(r)); a < best {
+				best, bestKey, bestT = a, key, t
+			}
+		}
+
+		if !math.IsInf(best, 1) {
+			// The sample is the element together with its quantized weight
+			buf := binary.LittleEndian.AppendUint64([]byte(bestKey), uint64(int64(bestT)))
+			signature[i] = murmur3.Sum32WithSeed(buf, seed)
+		}
+	}
+
+	return signature
+}
+
+// splitMix64 is a tiny deterministic random source for WeightedSignature
+type splitMix64 uint64
+
+// float returns a uniform value in (0, 1), never 0 so it's safe to take the log of
+func (s *splitMix64) float() float64 {
+	*s += 0x9e3779b97f4a7c15
+	z := uint64(*s)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return (float64(z>>11) + 0.5) / (1 << 53)
+}
+
 // Similarity calculates the estimated Jaccard similarity between two signatures
 func (mh *MinHash) Similarity(sig1, sig2 []uint32) float64 {
 	if len(sig1) != mh.numHashes || len(sig2) != mh.numHashes {
@@ -72,10 +134,11 @@ func (mh *MinHash) Similarity(sig1, sig2 []uint32) float64 {
 	return float64(matches) / float64(mh.numHashes)
 }
 
-// DocumentToSet converts a document to a set of k-shingles
-func DocumentToSet(r io.Reader, k int) []string {
-	result := make(map[string]struct{}) // Use map as a set
+// Tokenizer splits a document into the tokens that get shingled
+type Tokenizer func(io.Reader) []string
 
+// WordTokenizer splits on whitespace and lowercases, the default for DocumentToSet
+func WordTokenizer(r io.Reader) []string {
 	scanner := bufio.NewScanner(r)
 	scanner.Split(bufio.ScanWords)
 
@@ -84,6 +147,68 @@ func DocumentToSet(r io.Reader, k int) []string {
 	for scanner.Scan() {
 		words = append(words, strings.ToLower(scanner.Text()))
 	}
+	return words
+}
+
+// RuneTokenizer emits every non-space character as a token, which suits
+// scripts like Chinese or Japanese that don't separate words with spaces
+func RuneTokenizer(r io.Reader) []string {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanRunes)
+
+	runes := []string{}
+	for scanner.Scan() {
+		if tok := strings.TrimSpace(scanner.Text()); tok != "" {
+			runes = append(runes, strings.ToLower(tok))
+		}
+	}
+	return runes
+}
+
+// DocumentToSet converts a document to a set of k-shingles
+func DocumentToSet(r io.Reader, k int) []string {
+	return DocumentToSetWith(r, k, WordTokenizer)
+}
+
+// ShingleMode picks what a shingle is made of
+type ShingleMode int
+
+const (
+	WordShingles ShingleMode = iota // k consecutive words, the default
+	CharShingles                    // k consecutive characters, for short texts like log lines
+)
+
+// DocumentToSetMode converts a document to a set of k-shingles of the given mode.
+// Character shingles are taken over the lowercased text with each run of
+// whitespace collapsed to a single space, so they also span word boundaries.
+func DocumentToSetMode(r io.Reader, k int, mode ShingleMode) []string {
+	if mode == WordShingles {
+		return DocumentToSet(r, k)
+	}
+
+	// Rejoin the words so spacing differences don't change the shingles
+	text := []rune(strings.Join(WordTokenizer(r), " "))
+	if len(text) < k {
+		return []string{}
+	}
+
+	result := make(map[string]struct{})
+	for i := 0; i <= len(text)-k; i++ {
+		result[string(text[i:i+k])] = struct{}{}
+	}
+
+	shingles := make([]string, 0, len(result))
+	for shingle := range result {
+		shingles = append(shingles, shingle)
+	}
+	return shingles
+}
+
+// DocumentToSetWith converts a document to a set of k-shingles using a custom tokenizer
+func DocumentToSetWith(r io.Reader, k int, tokenize Tokenizer) []string {
+	result := make(map[string]struct{}) // Use map as a set
+
+	words := tokenize(r)
 
 	// Generate k-shingles
 	if len(words) < k {
@@ -112,6 +237,7 @@ type LSH struct {
 	hashTables   []map[string][]int
 	minHash      *MinHash
 	numDocuments int
+	signatures   []uint32 // Columnar store: docID's signature starts at docID*numHashes
 }
 
 // New creates a new LSH index
@@ -129,10 +255,30 @@ func NewLSH(bands, rows int) *LSH {
 	}
 }
 
+// OptimalBandsRows picks how to split numHashes into bands of rows so LSH's
+// S-curve, the chance 1-(1-s^r)^b that two documents of similarity s share a band,
+// rises steepest near threshold. It tries every exact factorization and returns the
+// one whose inflection point (1/b)^(1/r) is closest to threshold.
+func OptimalBandsRows(numHashes int, threshold float64) (bands, rows int) {
+	best := math.Inf(1)
+	for b := 1; b <= numHashes; b++ {
+		if numHashes%b != 0 {
+			continue
+		}
+		r := numHashes / b
+		gap := math.Abs(math.Pow(1/float64(b), 1/float64(r)) - threshold)
+		if gap < best {
+			best, bands, rows = gap, b, r
+		}
+	}
+	return bands, rows
+}
+
 // AddDocument adds a document to the LSH index
 func (lsh *LSH) AddDocument(docID int, shingles []string) {
 	// Generate signature
 	signature := lsh.minHash.Signature(shingles)
+	lsh.storeSignature(docID, signature)
 
 	// Split signature into bands
 	for i := 0; i < lsh.bands; i++ {
@@ -184,15 +330,7 @@ func (lsh *LSH) FindSimilar(shingles []string, threshold float64) map[int]float6
 
 	// Compute actual similarities for candidates
 	for docID := range candidates {
-		// In a real implementation, we would store and retrieve the
-		// document signatures rather than recomputing them
-
-		// For this example, we'll just assume we can access signatures
-		// otherSignature := getStoredSignature(docID)
-		// similarity := lsh.minHash.Similarity(signature, otherSignature)
-
-		// Placeholder for actual similarity computation
-		similarity := 0.0
+		similarity := lsh.minHash.Similarity(signature, lsh.signatureOf(docID))
 
 		if similarity >= threshold {
 			similarities[docID] = similarity
@@ -202,6 +340,25 @@ func (lsh *LSH) FindSimilar(shingles []string, threshold float64) map[int]float6
 	return similarities
 }
 
+// storeSignature copies a document's signature into the columnar store.
+// A single contiguous slice avoids a separate allocation per document and keeps
+// signatures next to each other in memory while refining candidates.
+// Document IDs are expected to be dense (0, 1, 2, ...), as DocumentSet assigns them.
+func (lsh *LSH) storeSignature(docID int, signature []uint32) {
+	numHashes := lsh.minHash.numHashes
+	if need := (docID + 1) * numHashes; need > len(lsh.signatures) {
+		lsh.signatures = append(lsh.signatures, make([]uint32, need-len(lsh.signatures))...)
+	}
+	copy(lsh.signatures[docID*numHashes:], signature)
+}
+
+// signatureOf returns a view of docID's stored signature (not a copy)
+func (lsh *LSH) signatureOf(docID int) []uint32 {
+	numHashes := lsh.minHash.numHashes
+	start := docID * numHashes
+	return lsh.signatures[start : start+numHashes : start+numHashes]
+}
+
 // bandToString converts a band signature to a string representation
 func bandToString(band []uint32) string {
 	// Simple hash function for the band
@@ -224,35 +381,65 @@ type Document struct {
 
 // DocumentSet manages a collection of documents
 type DocumentSet struct {
-	docs    map[int]*Document
-	minHash *MinHash
-	lsh     *LSH
-	nextID  int
+	docs        map[int]*Document
+	paths       map[string]int // path -> document ID
+	minHash     *MinHash
+	lsh         *LSH
+	nextID      int
+	mode        ShingleMode
+	shingleSize int
+
+	// OnLSHMiss, when set, turns on a debug check of LSH recall: FindSimilar also
+	// scans every document with exact Jaccard similarity and calls it for each one
+	// at or above the threshold that it didn't return. That's O(n) per query, so
+	// leave it nil outside debugging. FindDuplicatesParallel may call it from
+	// several goroutines at once.
+	OnLSHMiss func(docID, missedID int, jaccard float64)
 }
 
-// NewDocumentSet creates a new document set
+// NewDocumentSet creates a new document set of 3-word shingles
 func NewDocumentSet(hashFunctions, bands int) *DocumentSet {
+	return NewDocumentSetWithShingles(hashFunctions, bands, WordShingles, 3)
+}
+
+// NewDocumentSetWithShingles creates a document set that shingles documents into
+// shingleSize words or characters. For short texts like log lines, character
+// shingles (4 or 5 characters) tell near-duplicates apart much better than words.
+func NewDocumentSetWithShingles(hashFunctions, bands int, mode ShingleMode, shingleSize int) *DocumentSet {
 	rows := hashFunctions / bands
 	return &DocumentSet{
-		docs:    make(map[int]*Document),
-		minHash: NewMinHash(hashFunctions),
-		lsh:     NewLSH(bands, rows),
-		nextID:  0,
+		docs:        make(map[int]*Document),
+		paths:       make(map[string]int),
+		minHash:     NewMinHash(hashFunctions),
+		lsh:         NewLSH(bands, rows),
+		nextID:      0,
+		mode:        mode,
+		shingleSize: shingleSize,
 	}
 }
 
 // AddDocument adds a document to the set
 func (ds *DocumentSet) AddDocument(path string) (*Document, error) {
-	// Read file
+	shingles, err := ds.readShingles(path)
+	if err != nil {
+		return nil, err
+	}
+	return ds.insert(path, shingles, ds.minHash.Signature(shingles)), nil
+}
+
+// readShingles reads the file at path as a set of shingles, as configured for the set
+func (ds *DocumentSet) readShingles(path string) ([]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	// Convert to shingles
-	shingles := DocumentToSet(file, 3) // 3-word shingles
+	return DocumentToSetMode(file, ds.shingleSize, ds.mode), nil
+}
 
+// insert adds an already shingled and signed document to the set and the LSH index
+func (ds *DocumentSet) insert(path string, shingles []string, signature []uint32) *Document {
 	// Create document
 	docID := ds.nextID
 	ds.nextID++
@@ -261,16 +448,178 @@ func (ds *DocumentSet) AddDocument(path string) (*Document, error) {
 		ID:        docID,
 		Path:      path,
 		Shingles:  shingles,
-		Signature: ds.minHash.Signature(shingles),
+		Signature: signature,
 	}
 
 	// Add to collection
 	ds.docs[docID] = doc
+	ds.paths[path] = docID
 
 	// Add to LSH index
 	ds.lsh.AddDocument(docID, shingles)
 
-	return doc, nil
+	return doc
+}
+
+// Merge copies other's documents into ds, e.g. to combine sets built by parallel
+// ingestion shards. They get new IDs after ds's own, in their original order, and
+// are added to ds's LSH index. Paths ds already has are skipped. Both sets must
+// use the same number of hash functions, bands and shingling, or signatures wouldn't compare.
+func (ds *DocumentSet) Merge(other *DocumentSet) error {
+	if ds.minHash.numHashes != other.minHash.numHashes || ds.lsh.bands != other.lsh.bands || ds.lsh.rows != other.lsh.rows {
+		return fmt.Errorf("cannot merge document sets: %d hashes in %d bands of %d vs %d hashes in %d bands of %d",
+			ds.minHash.numHashes, ds.lsh.bands, ds.lsh.rows, other.minHash.numHashes, other.lsh.bands, other.lsh.rows)
+	}
+	if ds.mode != other.mode || ds.shingleSize != other.shingleSize {
+		return fmt.Errorf("cannot merge document sets with different shingling")
+	}
+
+	ids := make([]int, 0, len(other.docs))
+	for id := range other.docs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		doc := other.docs[id]
+		if _, indexed := ds.paths[doc.Path]; indexed {
+			continue
+		}
+		ds.insert(doc.Path, doc.Shingles, doc.Signature)
+	}
+	return nil
+}
+
+// AddDirectory indexes the .txt files under dir not already in the set. Reading and
+// signing files runs on a pool of workers; documents are inserted on the calling
+// goroutine in path order, so their IDs don't depend on scheduling. onProgress, if
+// set, is called after each file with the count done so far. Files that fail are
+// reported in the returned error without stopping the rest. Canceling ctx stops
+// ingestion promptly, keeping what was indexed so far, and ctx's error is returned.
+func (ds *DocumentSet) AddDirectory(ctx context.Context, dir string, workers int, onProgress func(done, total int)) error {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(strings.ToLower(path), ".txt") {
+			if _, indexed := ds.paths[path]; !indexed {
+				paths = append(paths, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	type parsed struct {
+		index     int
+		shingles  []string
+		signature []uint32
+		err       error
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	results := make(chan parsed)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				p := parsed{index: i}
+				if p.shingles, p.err = ds.readShingles(paths[i]); p.err == nil {
+					p.signature = ds.minHash.Signature(p.shingles)
+				}
+				select {
+				case results <- p:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range paths {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var errs []error
+	pending := make(map[int]parsed)
+	next, done := 0, 0
+	for p := range results {
+		done++
+		if onProgress != nil {
+			onProgress(done, len(paths))
+		}
+
+		// Hold results back until every earlier path is in
+		pending[p.index] = p
+		for q, ok := pending[next]; ok; q, ok = pending[next] {
+			delete(pending, next)
+			next++
+			if q.err != nil {
+				errs = append(errs, fmt.Errorf("adding %s: %w", paths[q.index], q.err))
+				continue
+			}
+			ds.insert(paths[q.index], q.shingles, q.signature)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		errs = append([]error{err}, errs...)
+	}
+	return errors.Join(errs...)
+}
+
+// AddDocumentsResumable indexes paths in order, calling checkpoint after each
+// one so the caller can persist how far it got. Paths already in the set are
+// skipped, and a failing checkpoint stops ingestion so progress isn't lost silently.
+// On restart, feed PathsAfter(paths, lastPath) to pick up where it stopped.
+func (ds *DocumentSet) AddDocumentsResumable(paths []string, checkpoint func(lastPath string) error) error {
+	for _, path := range paths {
+		if _, indexed := ds.paths[path]; !indexed {
+			if _, err := ds.AddDocument(path); err != nil {
+				return fmt.Errorf("adding %s: %w", path, err)
+			}
+		}
+
+		if err := checkpoint(path); err != nil {
+			return fmt.Errorf("checkpoint after %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// PathsAfter returns the paths that follow lastPath, or all of them if
+// lastPath is empty or not in the list
+func PathsAfter(paths []string, lastPath string) []string {
+	for i, path := range paths {
+		if path == lastPath {
+			return paths[i+1:]
+		}
+	}
+	return paths
 }
 
 // FindSimilar finds documents similar to the specified one
@@ -302,22 +651,102 @@ func (ds *DocumentSet) FindSimilar(docID int, threshold float64) []*Document {
 		}
 	}
 
+	if ds.OnLSHMiss != nil {
+		ds.reportMisses(doc, similar, threshold)
+	}
+
 	return similar
 }
 
+// reportMisses calls OnLSHMiss for every document at least threshold similar
+// to doc by exact Jaccard that isn't in found, in ID order
+func (ds *DocumentSet) reportMisses(doc *Document, found []*Document, threshold float64) {
+	returned := make(map[int]bool, len(found))
+	for _, d := range found {
+		returned[d.ID] = true
+	}
+
+	ids := make([]int, 0, len(ds.docs))
+	for id := range ds.docs {
+		if id != doc.ID && !returned[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		if j := jaccard(doc.Shingles, ds.docs[id].Shingles); j >= threshold {
+			ds.OnLSHMiss(doc.ID, id, j)
+		}
+	}
+}
+
+// jaccard returns the exact Jaccard similarity of two shingle sets
+func jaccard(a, b []string) float64 {
+	set := make(map[string]struct{}, len(a))
+	for _, s := range a {
+		set[s] = struct{}{}
+	}
+
+	intersection := 0
+	for _, s := range b {
+		if _, ok := set[s]; ok {
+			intersection++
+		}
+	}
+	union := len(set) + len(b) - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
+
 // FindDuplicates finds all groups of similar documents
 func (ds *DocumentSet) FindDuplicates(threshold float64) [][]int {
-	seen := make(map[int]bool)
-	groups := [][]int{}
+	return ds.FindDuplicatesParallel(threshold, 1)
+}
 
+// FindDuplicatesParallel finds all groups of similar documents, running the
+// FindSimilar queries on a bounded pool of workers. Documents are visited in ID
+// order and group members are sorted, so the result is the same for any worker count.
+// Fewer than one worker is treated as one.
+func (ds *DocumentSet) FindDuplicatesParallel(threshold float64, workers int) [][]int {
+	if workers < 1 {
+		workers = 1
+	}
+	ids := make([]int, 0, len(ds.docs))
 	for id := range ds.docs {
-		if seen[id] {
-			continue
-		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	// Fan out the queries; each worker writes only its own index
+	similar := make([][]int, len(ids))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				for _, doc := range ds.FindSimilar(ids[i], threshold) {
+					similar[i] = append(similar[i], doc.ID)
+				}
+			}
+		}()
+	}
+	for i := range ids {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
 
-		// Find similar documents
-		similarDocs := ds.FindSimilar(id, threshold)
-		if len(similarDocs) == 0 {
+	// Group serially so the seen bookkeeping needs no locking
+	seen := make(map[int]bool)
+	groups := [][]int{}
+
+	for i, id := range ids {
+		if seen[id] || len(similar[i]) == 0 {
 			continue
 		}
 
@@ -325,14 +754,18 @@ func (ds *DocumentSet) FindDuplicates(threshold float64) [][]int {
 		group := []int{id}
 		seen[id] = true
 
-		for _, doc := range similarDocs {
-			group = append(group, doc.ID)
-			seen[doc.ID] = true
+		for _, other := range similar[i] {
+			group = append(group, other)
+			seen[other] = true
 		}
 
+		sort.Ints(group)
 		groups = append(groups, group)
 	}
 
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
 	return groups
 }
 
@@ -340,29 +773,20 @@ func main() {
 	// Create a document set
 	docSet := NewDocumentSet(100, 20) // 100 hash functions, 20 bands
 
+	// Report pairs LSH misses, to check the bands/rows choice against real data
+	docSet.OnLSHMiss = func(docID, missedID int, jaccard float64) {
+		fmt.Printf("LSH missed documents %d and %d (Jaccard %.2f)\n", docID, missedID, jaccard)
+	}
+
 	// Sample documents directory
 	docsDir := "./sample_docs"
 
 	// Add all text files
-	err := filepath.Walk(docsDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() && strings.HasSuffix(strings.ToLower(path), ".txt") {
-			fmt.Printf("Adding document: %s\n", path)
-			_, err := docSet.AddDocument(path)
-			if err != nil {
-				fmt.Printf("Error adding document %s: %v\n", path, err)
-			}
-		}
-
-		return nil
+	err := docSet.AddDirectory(context.Background(), docsDir, 4, func(done, total int) {
+		fmt.Printf("Indexed %d/%d documents\n", done, total)
 	})
-
 	if err != nil {
-		fmt.Printf("Error walking directory: %v\n", err)
-		return
+		fmt.Printf("Error adding documents: %v\n", err)
 	}
 
 	// Find duplicate groups with similarity threshold of 0.8
@@ -0,0 +1,497 @@
+package main
+
+// Run with: go test minhash.go minhash_test.go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeDocs writes n small distinct documents into dir and returns their paths
+func writeDocs(t *testing.T, dir string, n int) []string {
+	t.Helper()
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("doc%02d.txt", i))
+		text := fmt.Sprintf("document number %d talks about topic %d in some detail", i, i*7)
+		if err := os.WriteFile(paths[i], []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestAddDocumentsResumable(t *testing.T) {
+	paths := writeDocs(t, t.TempDir(), 8)
+	ds := NewDocumentSet(100, 20)
+
+	// The first run is interrupted: saving the checkpoint after the 4th file fails,
+	// so the 4th file is indexed but the saved progress stops at the 3rd
+	var saved string
+	interrupted := errors.New("disk full")
+	err := ds.AddDocumentsResumable(paths, func(lastPath string) error {
+		if lastPath == paths[3] {
+			return interrupted
+		}
+		saved = lastPath
+		return nil
+	})
+	if !errors.Is(err, interrupted) {
+		t.Fatalf("err = %v, want the checkpoint error", err)
+	}
+	if saved != paths[2] {
+		t.Fatalf("saved checkpoint = %s, want %s", saved, paths[2])
+	}
+
+	// Resume from the saved checkpoint
+	var checkpoints []string
+	err = ds.AddDocumentsResumable(PathsAfter(paths, saved), func(lastPath string) error {
+		checkpoints = append(checkpoints, lastPath)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 5 || checkpoints[4] != paths[7] {
+		t.Errorf("resumed checkpoints = %v, want the last 5 paths", checkpoints)
+	}
+
+	if len(ds.docs) != len(paths) {
+		t.Errorf("indexed %d documents, want %d", len(ds.docs), len(paths))
+	}
+	for _, path := range paths {
+		if _, ok := ds.paths[path]; !ok {
+			t.Errorf("%s was skipped", path)
+		}
+	}
+}
+
+func TestSignatureOfColumnar(t *testing.T) {
+	lsh := NewLSH(20, 5)
+	sigs := map[int][]uint32{}
+	for id := 0; id < 50; id++ {
+		sig := lsh.minHash.Signature([]string{fmt.Sprint("shingle", id), "common"})
+		lsh.storeSignature(id, sig)
+		sigs[id] = sig
+	}
+	for id, want := range sigs {
+		got := lsh.signatureOf(id)
+		if len(got) != len(want) || cap(got) != len(want) {
+			t.Fatalf("doc %d: view has len %d cap %d, want %d", id, len(got), cap(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("doc %d: stored signature differs at %d", id, i)
+			}
+		}
+	}
+}
+
+// benchSignatures are the signatures the store benchmarks load and scan
+func benchSignatures(mh *MinHash, docs int) [][]uint32 {
+	sigs := make([][]uint32, docs)
+	for i := range sigs {
+		sigs[i] = mh.Signature([]string{fmt.Sprint("a", i), fmt.Sprint("b", i%100), "c"})
+	}
+	return sigs
+}
+
+// retainedBytes returns how much heap build's result keeps alive after a GC
+func retainedBytes(build func() any) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	kept := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(kept)
+	return after.HeapAlloc - before.HeapAlloc
+}
+
+// BenchmarkSignatureStore compares the columnar store with a map[int][]uint32,
+// loading 10,000 signatures and then refining every document against a query.
+// The load benchmarks report the heap each layout keeps as retained-B/op.
+func BenchmarkSignatureStore(b *testing.B) {
+	const docs = 10000
+	mh := NewMinHash(100)
+	sigs := benchSignatures(mh, docs)
+	query := sigs[0]
+
+	loadColumnar := func() *LSH {
+		lsh := &LSH{minHash: mh}
+		for id, sig := range sigs {
+			lsh.storeSignature(id, sig)
+		}
+		return lsh
+	}
+	loadMap := func() map[int][]uint32 {
+		store := make(map[int][]uint32)
+		for id, sig := range sigs {
+			store[id] = append([]uint32(nil), sig...)
+		}
+		return store
+	}
+
+	b.Run("columnar/load", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			loadColumnar()
+		}
+		b.ReportMetric(float64(retainedBytes(func() any { return loadColumnar() })), "retained-B/op")
+	})
+	b.Run("map/load", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			loadMap()
+		}
+		b.ReportMetric(float64(retainedBytes(func() any { return loadMap() })), "retained-B/op")
+	})
+
+	lsh, store := loadColumnar(), loadMap()
+	b.Run("columnar/refine", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for id := 0; id < docs; id++ {
+				mh.Similarity(query, lsh.signatureOf(id))
+			}
+		}
+	})
+	b.Run("map/refine", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for id := 0; id < docs; id++ {
+				mh.Similarity(query, store[id])
+			}
+		}
+	})
+}
+
+func TestDocumentToSetWithRuneTokenizer(t *testing.T) {
+	const text = "東京都に住んでいます"
+
+	// Without spaces the default sees one word, too few for 2-word shingles
+	if got := DocumentToSet(strings.NewReader(text), 2); len(got) != 0 {
+		t.Errorf("default tokenizer shingles = %v, want none", got)
+	}
+
+	got := DocumentToSetWith(strings.NewReader(text), 2, RuneTokenizer)
+	sort.Strings(got)
+	if len(got) != len([]rune(text))-1 {
+		t.Fatalf("rune shingles = %v, want %d of them", got, len([]rune(text))-1)
+	}
+	for _, shingle := range []string{"東 京", "京 都", "ま す"} {
+		if i := sort.SearchStrings(got, shingle); i == len(got) || got[i] != shingle {
+			t.Errorf("rune shingles %v are missing %q", got, shingle)
+		}
+	}
+
+	// Both tokenizers agree on whitespace-separated text
+	words := DocumentToSetWith(strings.NewReader("The quick brown fox"), 2, WordTokenizer)
+	if def := DocumentToSet(strings.NewReader("The quick brown fox"), 2); len(def) != len(words) || len(def) != 3 {
+		t.Errorf("default = %v, WordTokenizer = %v, want the same 3 shingles", def, words)
+	}
+}
+
+// duplicateCorpus builds a set of families documents, each with copies near-duplicate
+// variants that differ in their last word, plus as many unrelated documents
+func duplicateCorpus(families, copies int) *DocumentSet {
+	ds := NewDocumentSet(100, 20)
+	add := func(path, text string) {
+		shingles := DocumentToSet(strings.NewReader(text), 3)
+		ds.insert(path, shingles, ds.minHash.Signature(shingles))
+	}
+	for f := 0; f < families; f++ {
+		var base strings.Builder
+		for w := 0; w < 60; w++ {
+			fmt.Fprintf(&base, "family%d word%d ", f, w)
+		}
+		for c := 0; c < copies; c++ {
+			add(fmt.Sprintf("f%d-c%d", f, c), fmt.Sprintf("%s tail%d", base.String(), c))
+		}
+		add(fmt.Sprintf("unrelated%d", f), fmt.Sprintf("nothing alike %d here at all, just some words %d", f, f*31))
+	}
+	return ds
+}
+
+func TestFindDuplicatesParallelMatchesSerial(t *testing.T) {
+	ds := duplicateCorpus(10, 3)
+	serial := ds.FindDuplicates(0.8)
+	if len(serial) != 10 {
+		t.Fatalf("serial found %d groups, want 10: %v", len(serial), serial)
+	}
+	for _, workers := range []int{-1, 0, 2, 8} {
+		if got := ds.FindDuplicatesParallel(0.8, workers); fmt.Sprint(got) != fmt.Sprint(serial) {
+			t.Errorf("workers=%d: %v, want %v", workers, got, serial)
+		}
+	}
+}
+
+func BenchmarkFindDuplicates(b *testing.B) {
+	ds := duplicateCorpus(200, 4)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprint("workers=", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ds.FindDuplicatesParallel(0.8, workers)
+			}
+		})
+	}
+}
+
+func TestAddDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeDocs(t, dir, 30)
+	// A dangling link is listed as a .txt file but can't be read
+	if err := os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "broken.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := NewDocumentSet(100, 20)
+	var calls, lastDone, lastTotal int
+	err := ds.AddDirectory(context.Background(), dir, 4, func(done, total int) {
+		calls++
+		lastDone, lastTotal = done, total
+	})
+	if err == nil || !strings.Contains(err.Error(), "broken.txt") {
+		t.Errorf("err = %v, want the unreadable file reported", err)
+	}
+	if len(ds.docs) != 30 {
+		t.Errorf("indexed %d documents, want 30 despite the bad file", len(ds.docs))
+	}
+	if calls != 31 || lastDone != 31 || lastTotal != 31 {
+		t.Errorf("progress called %d times, last %d/%d; want 31 calls ending at 31/31", calls, lastDone, lastTotal)
+	}
+}
+
+func TestAddDirectoryCanceled(t *testing.T) {
+	dir := t.TempDir()
+	writeDocs(t, dir, 50)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := NewDocumentSet(100, 20)
+	err := ds.AddDirectory(ctx, dir, 2, func(done, total int) {
+		if done == 5 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	// Results are inserted in path order, so a few done files may still be held back
+	if n := len(ds.docs); n > 5+2 {
+		t.Errorf("indexed %d documents, want ingestion stopped soon after 5", n)
+	}
+}
+
+func TestMergeFindsCrossSetDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	text := "the quick brown fox jumps over the lazy dog near the quiet river bank at dawn"
+	original := write("a.txt", text)
+	copied := write("b.txt", text+" today")
+	unrelated := write("c.txt", "completely different words about databases indexes and query planners")
+
+	shardA, shardB := NewDocumentSet(100, 20), NewDocumentSet(100, 20)
+	if _, err := shardA.AddDocument(original); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{copied, unrelated} {
+		if _, err := shardB.AddDocument(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := shardA.Merge(shardB); err != nil {
+		t.Fatal(err)
+	}
+	if len(shardA.docs) != 3 {
+		t.Fatalf("merged set has %d documents, want 3", len(shardA.docs))
+	}
+	// Shard B's IDs started at 0 too, so they must have been rebased
+	var similar []string
+	for _, doc := range shardA.FindSimilar(shardA.paths[original], 0.5) {
+		similar = append(similar, doc.Path)
+	}
+	if len(similar) != 1 || similar[0] != copied {
+		t.Errorf("FindSimilar(a.txt) = %v, want only b.txt from the other shard", similar)
+	}
+
+	if err := shardA.Merge(NewDocumentSet(50, 10)); err == nil {
+		t.Error("merging sets with different MinHash configurations succeeded")
+	}
+}
+
+func TestCharShinglesTolerateOneWordChange(t *testing.T) {
+	a := "connection refused while dialing upstream database replica"
+	b := "connection refused when dialing upstream database replica"
+
+	shingles := func(text string, k int, mode ShingleMode) []string {
+		return DocumentToSetMode(strings.NewReader(text), k, mode)
+	}
+	words := jaccard(shingles(a, 3, WordShingles), shingles(b, 3, WordShingles))
+	chars := jaccard(shingles(a, 4, CharShingles), shingles(b, 4, CharShingles))
+	t.Logf("word 3-shingles %.2f, char 4-shingles %.2f", words, chars)
+	// The changed word breaks every word shingle that spans it
+	if chars < 0.7 || words > 0.5 {
+		t.Errorf("char similarity %.2f, word %.2f; want char shingles above 0.7 and word shingles below 0.5", chars, words)
+	}
+
+	// Word mode stays the default behavior
+	if got, want := shingles(a, 3, WordShingles), DocumentToSet(strings.NewReader(a), 3); len(got) != len(want) {
+		t.Errorf("word mode gives %d shingles, DocumentToSet %d", len(got), len(want))
+	}
+	// Character shingles span word boundaries, with whitespace runs collapsed
+	if got := shingles("Ab  c", 3, CharShingles); fmt.Sprint(sortedCopy(got)) != "[ab  b c]" {
+		t.Errorf("char shingles of %q = %v", "Ab  c", got)
+	}
+}
+
+// sortedCopy returns s sorted, leaving s as it was
+func sortedCopy(s []string) []string {
+	c := append([]string(nil), s...)
+	sort.Strings(c)
+	return c
+}
+
+func TestOptimalBandsRows(t *testing.T) {
+	// The factorizations of 100 put the inflection point at 0.01, 0.14, 0.45,
+	// 0.55, 0.79, 0.92, 0.95...; each threshold gets the nearest one
+	tests := []struct {
+		threshold   float64
+		bands, rows int
+	}{
+		{0.3, 25, 4},
+		{0.5, 20, 5},
+		{0.8, 10, 10},
+		{0.9, 5, 20},
+	}
+	prevRows := 0
+	for _, tt := range tests {
+		bands, rows := OptimalBandsRows(100, tt.threshold)
+		if bands != tt.bands || rows != tt.rows {
+			t.Errorf("OptimalBandsRows(100, %v) = %d bands of %d rows, want %d of %d", tt.threshold, bands, rows, tt.bands, tt.rows)
+		}
+		if bands*rows != 100 || rows < prevRows {
+			t.Errorf("threshold %v: %d x %d; want a factorization of 100, rows rising with the threshold", tt.threshold, bands, rows)
+		}
+		prevRows = rows
+
+		// Pairs at the threshold should have middling odds of sharing a band,
+		// not near-certainty either way
+		if p := 1 - math.Pow(1-math.Pow(tt.threshold, float64(rows)), float64(bands)); p < 0.1 || p > 0.9 {
+			t.Errorf("threshold %v: candidate probability at the threshold is %.2f", tt.threshold, p)
+		}
+	}
+
+	// A prime count only splits one way besides a single band
+	if bands, rows := OptimalBandsRows(7, 0.5); bands*rows != 7 {
+		t.Errorf("OptimalBandsRows(7, 0.5) = %d x %d", bands, rows)
+	}
+}
+
+func TestWeightedSignatureBoostsSharedShingles(t *testing.T) {
+	mh := NewMinHash(400)
+	// Two related documents share five shingles and each has five of its own
+	docs := func(sharedWeight int) (a, b map[string]int) {
+		a, b = map[string]int{}, map[string]int{}
+		for i := 0; i < 5; i++ {
+			a[fmt.Sprint("shared", i)] = sharedWeight
+			b[fmt.Sprint("shared", i)] = sharedWeight
+			a[fmt.Sprint("onlyA", i)] = 1
+			b[fmt.Sprint("onlyB", i)] = 1
+		}
+		return a, b
+	}
+
+	// Weighted Jaccard: 5w / (5w + 10)
+	for _, c := range []struct {
+		weight int
+		want   float64
+	}{
+		{1, 5.0 / 15},
+		{10, 50.0 / 60},
+	} {
+		a, b := docs(c.weight)
+		got := mh.Similarity(mh.WeightedSignature(a), mh.WeightedSignature(b))
+		if math.Abs(got-c.want) > 0.08 {
+			t.Errorf("shared weight %d: estimated similarity %.3f, want about %.3f", c.weight, got, c.want)
+		}
+	}
+
+	// With unit weights it agrees with the plain signature's estimate
+	a, b := docs(1)
+	plain := mh.Similarity(mh.Signature(keysOfSet(a)), mh.Signature(keysOfSet(b)))
+	weighted := mh.Similarity(mh.WeightedSignature(a), mh.WeightedSignature(b))
+	if math.Abs(plain-weighted) > 0.1 {
+		t.Errorf("unit weights: weighted %.3f vs plain %.3f", weighted, plain)
+	}
+}
+
+// keysOfSet returns the elements of a weighted set, ignoring the weights
+func keysOfSet(set map[string]int) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestOnLSHMissReportsMissedPair(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	text := "one two three four five six seven eight nine ten eleven twelve thirteen fourteen fifteen sixteen"
+	query := write("a.txt", text)
+	copied := write("b.txt", text)
+	near := write("c.txt", text+" seventeen eighteen nineteen twenty")
+	write("d.txt", "completely different words about databases indexes and query planners")
+
+	// One band of 100 rows: two documents only collide if all 100 minimums
+	// agree, and J^100 for the near copy's J of 14/18 is about 1e-11
+	ds := NewDocumentSet(100, 1)
+	if err := ds.AddDirectory(context.Background(), dir, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	type miss struct {
+		id      int
+		jaccard float64
+	}
+	var misses []miss
+	ds.OnLSHMiss = func(docID, missedID int, j float64) {
+		if docID != ds.paths[query] {
+			t.Errorf("miss reported for document %d, want the query %d", docID, ds.paths[query])
+		}
+		misses = append(misses, miss{missedID, j})
+	}
+
+	found := ds.FindSimilar(ds.paths[query], 0.5)
+	if len(found) != 1 || found[0].Path != copied {
+		t.Fatalf("FindSimilar found %d documents, want only the exact copy", len(found))
+	}
+
+	nearDoc := ds.docs[ds.paths[near]]
+	want := jaccard(ds.docs[ds.paths[query]].Shingles, nearDoc.Shingles)
+	if want < 0.5 {
+		t.Fatalf("near copy has Jaccard %.2f, the test needs it above the threshold", want)
+	}
+	if len(misses) != 1 || misses[0].id != nearDoc.ID || misses[0].jaccard != want {
+		t.Errorf("OnLSHMiss reported %v, want only the near copy %d at %.2f", misses, nearDoc.ID, want)
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"cmp"
+	"container/heap"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -11,21 +14,45 @@ const (
 	p        = 0.25 // Probability of inserting at higher level
 )
 
-// Initialize the random number generator
-var rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+// Each skip list draws node levels from its own generator, since a rand.Rand
+// isn't safe for concurrent use and lists may be filled from different goroutines.
+// levelSeeds seeds those generators as lists are created, guarded by levelSeedsMu.
+var (
+	levelSeedsMu sync.Mutex
+	levelSeeds   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// SeedRandom reseeds the generator that seeds each new list's levels, so node
+// levels, and therefore the shape of every skip list built afterwards (in the
+// same order), are reproducible. Handy for tests.
+func SeedRandom(seed int64) {
+	levelSeedsMu.Lock()
+	defer levelSeedsMu.Unlock()
+	levelSeeds = rand.New(rand.NewSource(seed))
+}
+
+// newLevelSource returns the level generator for a new skip list
+func newLevelSource() *rand.Rand {
+	levelSeedsMu.Lock()
+	defer levelSeedsMu.Unlock()
+	return rand.New(rand.NewSource(levelSeeds.Int63()))
+}
 
 // Node represents a node in the skip list
 type Node[K comparable, V any] struct {
 	key     K
 	value   V
 	forward []*Node[K, V] // Array of pointers for each level
+	span    []int         // Bottom-level steps each forward pointer covers, to the end if nil
 }
 
 // SkipList is a generic skip list implementation
 type SkipList[K comparable, V any] struct {
-	head  *Node[K, V]     // Head node (sentinel)
-	level int             // Current maximum level
-	less  func(K, K) bool // Comparison function
+	head   *Node[K, V]     // Head node (sentinel)
+	level  int             // Current maximum level
+	length int             // Number of entries
+	less   func(K, K) bool // Comparison function
+	rnd    *rand.Rand      // Level generator, used by this list only
 }
 
 // New creates a new skip list with the specified comparison function
@@ -37,19 +64,37 @@ func NewSkipList[K comparable, V any](less func(K, K) bool) *SkipList[K, V] {
 		key:     zeroK,
 		value:   zeroV,
 		forward: make([]*Node[K, V], maxLevel),
+		span:    make([]int, maxLevel),
 	}
 
 	return &SkipList[K, V]{
 		head:  head,
 		level: 1,
 		less:  less,
+		rnd:   newLevelSource(),
 	}
 }
 
+// NewIntSkipList creates a skip list ordered by ascending int keys
+func NewIntSkipList[V any]() *SkipList[int, V] {
+	return NewSkipList[int, V](func(a, b int) bool { return a < b })
+}
+
+// NewStringSkipList creates a skip list ordered by ascending string keys
+func NewStringSkipList[V any]() *SkipList[string, V] {
+	return NewSkipList[string, V](func(a, b string) bool { return a < b })
+}
+
+// NewFloat64SkipList creates a skip list ordered by ascending float64 keys
+// (cmp.Less places NaN before every other value, keeping the order total)
+func NewFloat64SkipList[V any]() *SkipList[float64, V] {
+	return NewSkipList[float64, V](cmp.Less[float64])
+}
+
 // randomLevel determines a random level for a new node
-func randomLevel() int {
+func (sl *SkipList[K, V]) randomLevel() int {
 	lvl := 1
-	for rnd.Float64() < p && lvl < maxLevel {
+	for sl.rnd.Float64() < p && lvl < maxLevel {
 		lvl++
 	}
 	return lvl
@@ -59,11 +104,16 @@ func randomLevel() int {
 func (sl *SkipList[K, V]) Insert(key K, value V) {
 	// Create update array and initialize it
 	update := make([]*Node[K, V], maxLevel)
+	rank := make([]int, maxLevel) // Position of update[i], the head being 0
 	current := sl.head
 
 	// Find position to insert
 	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
 		for current.forward[i] != nil && sl.less(current.forward[i].key, key) {
+			rank[i] += current.span[i]
 			current = current.forward[i]
 		}
 		update[i] = current
@@ -79,12 +129,13 @@ func (sl *SkipList[K, V]) Insert(key K, value V) {
 	}
 
 	// Otherwise, create new node with random level
-	level := randomLevel()
+	level := sl.randomLevel()
 
 	// Update the skip list level if necessary
 	if level > sl.level {
 		for i := sl.level; i < level; i++ {
 			update[i] = sl.head
+			sl.head.span[i] = sl.length
 		}
 		sl.level = level
 	}
@@ -94,13 +145,28 @@ func (sl *SkipList[K, V]) Insert(key K, value V) {
 		key:     key,
 		value:   value,
 		forward: make([]*Node[K, V], level),
+		span:    make([]int, level),
 	}
 
-	// Insert the node at all levels
+	// Insert the node at all levels, splitting each span it lands in
 	for i := 0; i < level; i++ {
 		newNode.forward[i] = update[i].forward[i]
 		update[i].forward[i] = newNode
+
+		newNode.span[i] = update[i].span[i] - (rank[0] - rank[i])
+		update[i].span[i] = rank[0] - rank[i] + 1
+	}
+
+	// Spans passing over the new node got one step longer
+	for i := level; i < sl.level; i++ {
+		update[i].span[i]++
 	}
+	sl.length++
+}
+
+// Len returns the number of entries. Updating an existing key doesn't change it.
+func (sl *SkipList[K, V]) Len() int {
+	return sl.length
 }
 
 // Search looks for a key and returns its value and success flag
@@ -126,6 +192,214 @@ func (sl *SkipList[K, V]) Search(key K) (V, bool) {
 	return zeroV, false
 }
 
+// findLess returns the last node whose key is less than key (the head if none)
+func (sl *SkipList[K, V]) findLess(key K) *Node[K, V] {
+	current := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for current.forward[i] != nil && sl.less(current.forward[i].key, key) {
+			current = current.forward[i]
+		}
+	}
+	return current
+}
+
+// Floor returns the entry with the largest key less than or equal to key
+func (sl *SkipList[K, V]) Floor(key K) (K, V, bool) {
+	prev := sl.findLess(key)
+
+	// An exact match is the next node
+	if next := prev.forward[0]; next != nil && !sl.less(key, next.key) {
+		return next.key, next.value, true
+	}
+	if prev != sl.head {
+		return prev.key, prev.value, true
+	}
+
+	var zeroK K
+	var zeroV V
+	return zeroK, zeroV, false
+}
+
+// Ceiling returns the entry with the smallest key greater than or equal to key
+func (sl *SkipList[K, V]) Ceiling(key K) (K, V, bool) {
+	if next := sl.findLess(key).forward[0]; next != nil {
+		return next.key, next.value, true
+	}
+
+	var zeroK K
+	var zeroV V
+	return zeroK, zeroV, false
+}
+
+// Min returns the entry with the smallest key, in O(1)
+func (sl *SkipList[K, V]) Min() (K, V, bool) {
+	first := sl.head.forward[0]
+	if first == nil {
+		var zeroK K
+		var zeroV V
+		return zeroK, zeroV, false
+	}
+	return first.key, first.value, true
+}
+
+// Max returns the entry with the largest key. It runs along each level as far as
+// it goes before dropping down, so it takes the express lanes: O(log n).
+func (sl *SkipList[K, V]) Max() (K, V, bool) {
+	current := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for current.forward[i] != nil {
+			current = current.forward[i]
+		}
+	}
+	if current == sl.head {
+		var zeroK K
+		var zeroV V
+		return zeroK, zeroV, false
+	}
+	return current.key, current.value, true
+}
+
+// PopMin removes and returns the entry with the smallest key. The first node
+// follows the head on every level it's on, so it's unlinked without a search.
+func (sl *SkipList[K, V]) PopMin() (K, V, bool) {
+	first := sl.head.forward[0]
+	if first == nil {
+		var zeroK K
+		var zeroV V
+		return zeroK, zeroV, false
+	}
+
+	for i := 0; i < sl.level; i++ {
+		if i < len(first.forward) {
+			sl.head.span[i] += first.span[i] - 1
+			sl.head.forward[i] = first.forward[i]
+		} else {
+			sl.head.span[i]--
+		}
+	}
+	for sl.level > 1 && sl.head.forward[sl.level-1] == nil {
+		sl.level--
+	}
+	sl.length--
+
+	return first.key, first.value, true
+}
+
+// ForEachInRange calls fn for each entry with from <= key < to, in order, stopping
+// early if fn returns false. fn gets a pointer to the stored value so it can update
+// it in place. Inserting or deleting keys during the walk is not safe.
+func (sl *SkipList[K, V]) ForEachInRange(from, to K, fn func(K, *V) bool) {
+	for node := sl.findLess(from).forward[0]; node != nil && sl.less(node.key, to); node = node.forward[0] {
+		if !fn(node.key, &node.value) {
+			return
+		}
+	}
+}
+
+// RangeFunc calls fn for each entry with lo <= key <= hi, in order, stopping early
+// if fn returns false. It descends the express lanes to lo, then walks the bottom
+// level until a key passes hi. Nothing is visited if hi is less than lo.
+func (sl *SkipList[K, V]) RangeFunc(lo, hi K, fn func(K, V) bool) {
+	for node := sl.findLess(lo).forward[0]; node != nil && !sl.less(hi, node.key); node = node.forward[0] {
+		if !fn(node.key, node.value) {
+			return
+		}
+	}
+}
+
+// Range returns the entries with lo <= key <= hi, in order
+func (sl *SkipList[K, V]) Range(lo, hi K) []KV[K, V] {
+	items := []KV[K, V]{}
+	sl.RangeFunc(lo, hi, func(key K, value V) bool {
+		items = append(items, KV[K, V]{Key: key, Value: value})
+		return true
+	})
+	return items
+}
+
+// Iterator walks a skip list's bottom level in key order. Like
+// ForEachInRange, it reads the live list, so it must not be modified meanwhile.
+type Iterator[K comparable, V any] struct {
+	node *Node[K, V] // Current position; the head before the first Next
+}
+
+// Iterator returns an iterator positioned before the smallest key
+func (sl *SkipList[K, V]) Iterator() *Iterator[K, V] {
+	return &Iterator[K, V]{node: sl.head}
+}
+
+// Next advances to the next entry, returning false when the list is exhausted
+func (it *Iterator[K, V]) Next() bool {
+	if it.node == nil {
+		return false
+	}
+	it.node = it.node.forward[0]
+	return it.node != nil
+}
+
+// Key returns the key at the current position
+func (it *Iterator[K, V]) Key() K {
+	return it.node.key
+}
+
+// Value returns the value at the current position
+func (it *Iterator[K, V]) Value() V {
+	return it.node.value
+}
+
+// ForEach calls fn for every entry in key order, stopping early if fn returns false
+func (sl *SkipList[K, V]) ForEach(fn func(K, V) bool) {
+	for node := sl.head.forward[0]; node != nil; node = node.forward[0] {
+		if !fn(node.key, node.value) {
+			return
+		}
+	}
+}
+
+// Select returns the entry at 0-based position rank in key order, in O(log n):
+// the spans say how far each express lane jumps, so it skips whole runs of
+// nodes without visiting them
+func (sl *SkipList[K, V]) Select(rank int) (K, V, bool) {
+	if rank < 0 || rank >= sl.length {
+		var zeroK K
+		var zeroV V
+		return zeroK, zeroV, false
+	}
+
+	target := rank + 1 // Positions count the head as 0
+	pos := 0
+	current := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for current.forward[i] != nil && pos+current.span[i] <= target {
+			pos += current.span[i]
+			current = current.forward[i]
+		}
+		if pos == target {
+			break
+		}
+	}
+	return current.key, current.value, true
+}
+
+// Rank returns the 0-based position of key in key order, or -1 if it isn't
+// present, in O(log n)
+func (sl *SkipList[K, V]) Rank(key K) int {
+	pos := 0
+	current := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for current.forward[i] != nil && sl.less(current.forward[i].key, key) {
+			pos += current.span[i]
+			current = current.forward[i]
+		}
+	}
+
+	next := current.forward[0]
+	if next == nil || sl.less(key, next.key) {
+		return -1
+	}
+	return pos // next sits at pos+1, counting the head as 0
+}
+
 // Delete removes a key from the skip list
 func (sl *SkipList[K, V]) Delete(key K) bool {
 	update := make([]*Node[K, V], maxLevel)
@@ -145,8 +419,10 @@ func (sl *SkipList[K, V]) Delete(key K) bool {
 	if current != nil && !sl.less(current.key, key) && !sl.less(key, current.key) {
 		for i := 0; i < sl.level; i++ {
 			if update[i].forward[i] != current {
-				break
+				update[i].span[i]-- // Passes over the node, one step shorter now
+				continue
 			}
+			update[i].span[i] += current.span[i] - 1
 			update[i].forward[i] = current.forward[i]
 		}
 
@@ -155,36 +431,366 @@ func (sl *SkipList[K, V]) Delete(key K) bool {
 			sl.level--
 		}
 
+		sl.length--
 		return true
 	}
 
 	return false
 }
 
+// Rebalance rebuilds the list from its current contents with freshly drawn
+// levels. Long runs of inserts and deletes can leave the levels skewed (a few
+// tall nodes deleted, express lanes gone), and searches drift towards O(n);
+// rebuilding restores the expected O(log n). It costs O(n) time and allocates
+// every node anew, and it is not safe for concurrent use: hold
+// ConcurrentSkipList's write lock or otherwise keep other goroutines out.
+func (sl *SkipList[K, V]) Rebalance() {
+	items := []KV[K, V]{}
+	for node := sl.head.forward[0]; node != nil; node = node.forward[0] {
+		items = append(items, KV[K, V]{Key: node.key, Value: node.value})
+	}
+	sl.bulkLoad(items)
+}
+
+// bulkLoad replaces the list's contents with items, which must already be sorted
+// by less with no duplicate keys. Each node is appended behind the last node seen
+// at each of its levels, so no searching is needed: O(n) instead of O(n log n).
+func (sl *SkipList[K, V]) bulkLoad(items []KV[K, V]) {
+	sl.head.forward = make([]*Node[K, V], maxLevel)
+	sl.head.span = make([]int, maxLevel)
+	sl.level = 1
+	sl.length = len(items)
+
+	tails := make([]*Node[K, V], maxLevel) // Last node linked at each level
+	tailPos := make([]int, maxLevel)       // and its position, the head being 0
+	for i := range tails {
+		tails[i] = sl.head
+	}
+
+	for n, item := range items {
+		pos := n + 1
+		level := sl.randomLevel()
+		if level > sl.level {
+			sl.level = level
+		}
+		node := &Node[K, V]{
+			key:     item.Key,
+			value:   item.Value,
+			forward: make([]*Node[K, V], level),
+			span:    make([]int, level),
+		}
+		for i := 0; i < level; i++ {
+			tails[i].forward[i] = node
+			tails[i].span[i] = pos - tailPos[i]
+			tails[i], tailPos[i] = node, pos
+		}
+	}
+
+	// The last node at each level spans to the end
+	for i := range tails {
+		tails[i].span[i] = len(items) - tailPos[i]
+	}
+}
+
+// MergeSorted performs a k-way merge of several skip lists, returning an iterator
+// that yields every entry in globally sorted order. It keeps one cursor per list
+// in a min-heap, so each step costs O(log k). Keys present in several lists are
+// yielded once per list. The lists must not be modified while merging.
+func MergeSorted[K comparable, V any](less func(K, K) bool, lists ...*SkipList[K, V]) func() (K, V, bool) {
+	h := &cursorHeap[K, V]{less: less}
+	for _, sl := range lists {
+		if first := sl.head.forward[0]; first != nil {
+			h.nodes = append(h.nodes, first)
+		}
+	}
+	heap.Init(h)
+
+	return func() (K, V, bool) {
+		if h.Len() == 0 {
+			var zeroK K
+			var zeroV V
+			return zeroK, zeroV, false
+		}
+
+		node := h.nodes[0]
+		if next := node.forward[0]; next != nil {
+			h.nodes[0] = next // Advance this list's cursor in place
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+		return node.key, node.value, true
+	}
+}
+
+// cursorHeap orders per-list cursors by their current key
+type cursorHeap[K comparable, V any] struct {
+	nodes []*Node[K, V]
+	less  func(K, K) bool
+}
+
+func (h *cursorHeap[K, V]) Len() int           { return len(h.nodes) }
+func (h *cursorHeap[K, V]) Less(i, j int) bool { return h.less(h.nodes[i].key, h.nodes[j].key) }
+func (h *cursorHeap[K, V]) Swap(i, j int)      { h.nodes[i], h.nodes[j] = h.nodes[j], h.nodes[i] }
+func (h *cursorHeap[K, V]) Push(x any)         { h.nodes = append(h.nodes, x.(*Node[K, V])) }
+func (h *cursorHeap[K, V]) Pop() any {
+	last := h.nodes[len(h.nodes)-1]
+	h.nodes = h.nodes[:len(h.nodes)-1]
+	return last
+}
+
+// KV is a key-value pair copied out of a skip list
+type KV[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// ConcurrentSkipList guards a SkipList with a RWMutex so it can be shared across goroutines.
+// The lock is coarse: one per list, not per node. Searches run in parallel with
+// each other, but every Insert or Delete stops all readers and other writers, so
+// write-heavy workloads serialize on it. Lock-free skip lists avoid that with
+// atomic pointer updates, at the cost of much subtler code; this one trades
+// write throughput for being obviously correct.
+type ConcurrentSkipList[K comparable, V any] struct {
+	mu   sync.RWMutex
+	list *SkipList[K, V]
+}
+
+// NewConcurrentSkipList creates a goroutine-safe skip list
+func NewConcurrentSkipList[K comparable, V any](less func(K, K) bool) *ConcurrentSkipList[K, V] {
+	return &ConcurrentSkipList[K, V]{list: NewSkipList[K, V](less)}
+}
+
+// Insert adds or updates a key-value pair
+func (c *ConcurrentSkipList[K, V]) Insert(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.list.Insert(key, value)
+}
+
+// Search looks for a key and returns its value and success flag
+func (c *ConcurrentSkipList[K, V]) Search(key K) (V, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.list.Search(key)
+}
+
+// Delete removes a key from the skip list
+func (c *ConcurrentSkipList[K, V]) Delete(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.list.Delete(key)
+}
+
+// Len returns the number of entries
+func (c *ConcurrentSkipList[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.list.Len()
+}
+
+// Range returns the entries with lo <= key <= hi, in order, copied under a read lock
+func (c *ConcurrentSkipList[K, V]) Range(lo, hi K) []KV[K, V] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.list.Range(lo, hi)
+}
+
+// Rank returns the 0-based position of key in key order, or -1 if it isn't present
+func (c *ConcurrentSkipList[K, V]) Rank(key K) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.list.Rank(key)
+}
+
+// Rebalance redraws the levels under the write lock, blocking everyone for O(n)
+func (c *ConcurrentSkipList[K, V]) Rebalance() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.list.Rebalance()
+}
+
+// Snapshot copies the bottom level under a short read lock and returns an
+// iterator over the copy. The iterator sees a point-in-time view: writes made
+// after Snapshot returns are not visible, and writers aren't blocked while it's scanned.
+func (c *ConcurrentSkipList[K, V]) Snapshot() *SnapshotIterator[K, V] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	items := []KV[K, V]{}
+	for node := c.list.head.forward[0]; node != nil; node = node.forward[0] {
+		items = append(items, KV[K, V]{Key: node.key, Value: node.value})
+	}
+	return &SnapshotIterator[K, V]{items: items, pos: -1}
+}
+
+// SnapshotIterator walks a copied, ordered view of a skip list
+type SnapshotIterator[K comparable, V any] struct {
+	items []KV[K, V]
+	pos   int
+}
+
+// Next advances to the next pair, returning false when the snapshot is exhausted
+func (it *SnapshotIterator[K, V]) Next() bool {
+	it.pos++
+	return it.pos < len(it.items)
+}
+
+// Key returns the key at the current position
+func (it *SnapshotIterator[K, V]) Key() K {
+	return it.items[it.pos].Key
+}
+
+// Value returns the value at the current position
+func (it *SnapshotIterator[K, V]) Value() V {
+	return it.items[it.pos].Value
+}
+
 // Example usage
 // This example demonstrates a simple time-to-live (TTL) cache using a skip list
 // with a cleanup mechanism to remove expired items.
 
+// pqKey orders queue entries by priority, then by insertion so equal priorities
+// get distinct skip list keys and pop first-in, first-out
+type pqKey[P cmp.Ordered] struct {
+	priority P
+	seq      uint64
+}
+
+// PriorityQueue is a min-priority queue on a skip list keyed by priority.
+// Unlike a binary heap it can change an item's priority in O(log n) without
+// knowing its position: the item index gives its key, so it's deleted and reinserted.
+// Each item can be queued once.
+type PriorityQueue[T comparable, P cmp.Ordered] struct {
+	list  *SkipList[pqKey[P], T]
+	index map[T]pqKey[P]
+	seq   uint64
+}
+
+func NewPriorityQueue[T comparable, P cmp.Ordered]() *PriorityQueue[T, P] {
+	return &PriorityQueue[T, P]{
+		list: NewSkipList[pqKey[P], T](func(a, b pqKey[P]) bool {
+			if a.priority != b.priority {
+				return a.priority < b.priority
+			}
+			return a.seq < b.seq
+		}),
+		index: make(map[T]pqKey[P]),
+	}
+}
+
+// Push queues item with priority, updating its priority if it's already queued
+func (pq *PriorityQueue[T, P]) Push(item T, priority P) {
+	if old, ok := pq.index[item]; ok {
+		pq.list.Delete(old)
+	}
+	key := pqKey[P]{priority: priority, seq: pq.seq}
+	pq.seq++
+	pq.list.Insert(key, item)
+	pq.index[item] = key
+}
+
+// Pop removes and returns the item with the lowest priority
+func (pq *PriorityQueue[T, P]) Pop() (T, P, bool) {
+	key, item, ok := pq.list.PopMin()
+	if !ok {
+		var zeroT T
+		var zeroP P
+		return zeroT, zeroP, false
+	}
+	delete(pq.index, item)
+	return item, key.priority, true
+}
+
+// Update moves a queued item to newPriority, reporting false if it isn't queued
+func (pq *PriorityQueue[T, P]) Update(item T, newPriority P) bool {
+	if _, ok := pq.index[item]; !ok {
+		return false
+	}
+	pq.Push(item, newPriority)
+	return true
+}
+
+// Len returns the number of queued items
+func (pq *PriorityQueue[T, P]) Len() int {
+	return len(pq.index)
+}
+
+// ShortestPaths runs Dijkstra's algorithm over a weighted graph, returning the
+// distance from source to every reachable node. A shorter path to a queued node
+// lowers its priority in place (decrease-key) instead of queueing it twice.
+func ShortestPaths(graph map[string]map[string]float64, source string) map[string]float64 {
+	dist := map[string]float64{source: 0}
+	done := make(map[string]bool)
+	pq := NewPriorityQueue[string, float64]()
+	pq.Push(source, 0)
+
+	for pq.Len() > 0 {
+		node, d, _ := pq.Pop()
+		done[node] = true
+		for next, weight := range graph[node] {
+			if done[next] {
+				continue
+			}
+			if best, seen := dist[next]; !seen || d+weight < best {
+				dist[next] = d + weight
+				pq.Push(next, d+weight)
+			}
+		}
+	}
+	return dist
+}
+
+// Cache is the common surface shared by the book's caches (this TTLCache and
+// the LRUCache in chapter08), so callers can swap eviction strategies
+// without touching the code that uses them.
+// Each example builds on its own, so Cache and testCacheContract in
+// skiplists_test.go repeat the canonical ones in chapter08/lrusqlite.go and
+// lrusqlite_test.go word for word; change them there first, then here.
+type Cache[K comparable, V any] interface {
+	Get(key K) (V, bool)
+	Set(key K, val V)
+	SetWithTTL(key K, val V, ttl time.Duration)
+	Delete(key K)
+	Len() int
+}
+
+var _ Cache[string, interface{}] = (*TTLCache)(nil)
+
 // CacheItem represents a value in the cache with expiration time
 type CacheItem struct {
 	value      interface{}
 	expiration time.Time
 }
 
-// TTLCache is a time-to-live cache using a skip list for efficient access
+// TTLCache is a time-to-live cache using a skip list for efficient access.
+// The cleanup goroutine runs alongside callers, so mu guards items.
 type TTLCache struct {
+	mu          sync.Mutex
 	items       *SkipList[string, CacheItem]
 	defaultTTL  time.Duration
 	cleanupFreq time.Duration
+	maxEntries  int // 0 for no limit
 	stopCleanup chan struct{}
 }
 
 // NewTTLCache creates a new cache with default TTL and cleanup frequency
 func NewTTLCache(defaultTTL, cleanupFreq time.Duration) *TTLCache {
+	return NewTTLCacheWithCap(defaultTTL, cleanupFreq, 0)
+}
+
+// NewTTLCacheWithCap creates a cache holding at most maxEntries items, so long
+// TTLs can't grow it without bound between cleanups. Once a Set goes over the
+// cap, the item closest to expiring is evicted, already expired ones first; with
+// equal TTLs that's the least recently set. The key just set is never the one
+// evicted. Finding the victim scans every item, O(n) per eviction.
+// A maxEntries of 0 or less means no limit.
+func NewTTLCacheWithCap(defaultTTL, cleanupFreq time.Duration, maxEntries int) *TTLCache {
 	cache := &TTLCache{
-		items:       NewSkipList[string, CacheItem](func(a, b string) bool { return a < b }),
+		items:       NewStringSkipList[CacheItem](),
 		defaultTTL:  defaultTTL,
 		cleanupFreq: cleanupFreq,
+		maxEntries:  maxEntries,
 		stopCleanup: make(chan struct{}),
 	}
 
@@ -206,11 +812,39 @@ func (c *TTLCache) SetWithTTL(key string, value interface{}, ttl time.Duration)
 		value:      value,
 		expiration: expiration,
 	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.items.Insert(key, item)
+
+	for c.maxEntries > 0 && c.items.Len() > c.maxEntries {
+		c.evictSoonest(key)
+	}
+}
+
+// evictSoonest deletes the item expiring first, other than keep
+func (c *TTLCache) evictSoonest(keep string) {
+	var victim string
+	var soonest time.Time
+	found := false
+	for it := c.items.Iterator(); it.Next(); {
+		if it.Key() == keep {
+			continue
+		}
+		if exp := it.Value().expiration; !found || exp.Before(soonest) {
+			victim, soonest, found = it.Key(), exp, true
+		}
+	}
+	if found {
+		c.items.Delete(victim)
+	}
 }
 
 // Get retrieves a value from the cache
 func (c *TTLCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	item, found := c.items.Search(key)
 	if !found {
 		return nil, false
@@ -227,9 +861,19 @@ func (c *TTLCache) Get(key string) (interface{}, bool) {
 
 // Delete removes a key from the cache
 func (c *TTLCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.items.Delete(key)
 }
 
+// Len returns the number of stored items, including expired ones
+// that haven't been cleaned up yet
+func (c *TTLCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.items.Len()
+}
+
 // cleanupLoop periodically removes expired items
 func (c *TTLCache) cleanupLoop() {
 	ticker := time.NewTicker(c.cleanupFreq)
@@ -247,14 +891,18 @@ func (c *TTLCache) cleanupLoop() {
 
 // cleanup removes all expired items
 func (c *TTLCache) cleanup() {
-	//now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	// This is a simplified approach - in a real implementation,
-	// we would use the skip list more efficiently
-	keysToDelete := []string{}
+	now := time.Now()
 
-	// Iterate through all keys to find expired ones
-	// This would be implemented with a proper iterator in a real skiplist
+	// Collect first: deleting while iterating would unlink the iterator's node
+	keysToDelete := []string{}
+	for it := c.items.Iterator(); it.Next(); {
+		if now.After(it.Value().expiration) {
+			keysToDelete = append(keysToDelete, it.Key())
+		}
+	}
 
 	for _, key := range keysToDelete {
 		c.items.Delete(key)
@@ -267,6 +915,79 @@ func (c *TTLCache) Close() {
 }
 
 func main() {
+	// Shortest paths with the skip list priority queue
+	graph := map[string]map[string]float64{
+		"A": {"B": 4, "C": 1},
+		"C": {"B": 2, "D": 5},
+		"B": {"D": 1},
+	}
+	fmt.Println("Shortest paths from A:", ShortestPaths(graph, "A"))
+
+	// Walk a skip list in key order
+	scores := NewStringSkipList[int]()
+	scores.Insert("carol", 72)
+	scores.Insert("alice", 90)
+	scores.Insert("bob", 85)
+	for it := scores.Iterator(); it.Next(); {
+		fmt.Printf("%s: %d\n", it.Key(), it.Value())
+	}
+	fmt.Println("Names from b to c:", scores.Range("b", "c"))
+	if name, _, ok := scores.Max(); ok {
+		fmt.Println("Last name:", name)
+	}
+	if name, score, ok := scores.Select(1); ok {
+		fmt.Printf("Second place alphabetically: %s (%d), rank of carol: %d\n", name, score, scores.Rank("carol"))
+	}
+	scores.ForEach(func(name string, score int) bool {
+		fmt.Println("First name:", name)
+		return false
+	})
+
+	// Heavy churn can leave the levels skewed; Rebalance redraws them
+	ids := NewIntSkipList[string]()
+	for i := 0; i < 10000; i++ {
+		ids.Insert(i, fmt.Sprintf("id-%d", i))
+	}
+	for i := 0; i < 10000; i++ {
+		if i%100 != 0 {
+			ids.Delete(i)
+		}
+	}
+	ids.Rebalance()
+	if v, ok := ids.Search(500); ok {
+		fmt.Printf("After rebalance: found %s, %d levels in use\n", v, ids.level)
+	}
+
+	// Writers and readers sharing one list
+	index := NewConcurrentSkipList[int, string](cmp.Less[int])
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 1000; i += 4 {
+				index.Insert(i, fmt.Sprintf("doc-%d", i))
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				index.Search(i)
+			}
+		}()
+	}
+	wg.Wait()
+	fmt.Printf("Concurrent index: %d entries, 500 at rank %d\n", index.Len(), index.Rank(500))
+
+	// A capped cache evicts the entry closest to expiring
+	recent := NewTTLCacheWithCap(time.Minute, 10*time.Second, 2)
+	recent.Set("page:1", "first")
+	recent.Set("page:2", "second")
+	recent.Set("page:3", "third")
+	_, kept := recent.Get("page:1")
+	fmt.Printf("Capped cache holds %d items, page:1 kept: %v\n", recent.Len(), kept)
+	recent.Close()
+
 	// Create a cache with 1 minute default TTL, cleanup every 10 seconds
 	cache := NewTTLCache(1*time.Minute, 10*time.Second)
 	defer cache.Close()
@@ -0,0 +1,760 @@
+package main
+
+// Run with: go test skiplists.go skiplists_test.go
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCacheContract checks the behavior every Cache must share;
+// value makes the i-th test value in the cache's value type.
+func testCacheContract[V comparable](t *testing.T, c Cache[string, V], value func(i int) V) {
+	t.Helper()
+
+	if _, ok := c.Get("missing"); ok {
+		t.Error("Get found a key that was never set")
+	}
+
+	c.Set("a", value(1))
+	c.SetWithTTL("b", value(2), time.Hour)
+	if got, ok := c.Get("a"); !ok || got != value(1) {
+		t.Errorf("Get(a) = %v, %v; want %v, true", got, ok, value(1))
+	}
+	if got, ok := c.Get("b"); !ok || got != value(2) {
+		t.Errorf("Get(b) = %v, %v; want %v, true", got, ok, value(2))
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+
+	c.Set("a", value(3))
+	if got, _ := c.Get("a"); got != value(3) {
+		t.Errorf("Get(a) after overwrite = %v, want %v", got, value(3))
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() after overwrite = %d, want 2", c.Len())
+	}
+
+	c.Delete("a")
+	c.Delete("missing") // Deleting an absent key is a no-op
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get found a deleted key")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len() after delete = %d, want 1", c.Len())
+	}
+}
+
+func TestTTLCacheContract(t *testing.T) {
+	c := NewTTLCache(time.Minute, time.Minute)
+	defer c.Close()
+	testCacheContract[interface{}](t, c, func(i int) interface{} { return i })
+}
+
+func TestTTLCacheExpiry(t *testing.T) {
+	c := NewTTLCache(time.Minute, time.Minute)
+	defer c.Close()
+
+	c.SetWithTTL("short", "gone soon", 10*time.Millisecond)
+	c.Set("long", "stays")
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := c.Get("short"); ok {
+		t.Error("Get returned an expired entry")
+	}
+	if _, ok := c.Get("long"); !ok {
+		t.Error("Get lost an entry that hasn't expired")
+	}
+}
+
+func TestSnapshotDuringInserts(t *testing.T) {
+	list := NewConcurrentSkipList[int, int](func(a, b int) bool { return a < b })
+	const n = 2000
+
+	// One writer inserts keys in ascending order, so every point-in-time view is 0..k-1
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			list.Insert(i, i*i)
+		}
+	}()
+
+	scans := 0
+	for finished := false; !finished; scans++ {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+
+		expected := 0
+		for it := list.Snapshot(); it.Next(); expected++ {
+			if it.Key() != expected || it.Value() != expected*expected {
+				t.Fatalf("scan %d: got %d=%d at position %d, not a consistent prefix", scans, it.Key(), it.Value(), expected)
+			}
+		}
+		if finished && expected != n {
+			t.Fatalf("final scan saw %d keys, want %d", expected, n)
+		}
+	}
+}
+
+func TestMergeSorted(t *testing.T) {
+	less := func(a, b int) bool { return a < b }
+	shards := [][]int{
+		{1, 4, 7, 10, 13},
+		{2, 3, 5, 8, 21, 34},
+		{0, 6, 9, 55},
+		{}, // An empty shard contributes nothing
+	}
+	lists := make([]*SkipList[int, string], len(shards))
+	want := map[int]bool{}
+	for i, keys := range shards {
+		lists[i] = NewSkipList[int, string](less)
+		for _, k := range keys {
+			lists[i].Insert(k, fmt.Sprint("shard", i))
+			want[k] = true
+		}
+	}
+
+	next := MergeSorted(less, lists...)
+	seen := map[int]bool{}
+	prev := -1
+	for k, v, ok := next(); ok; k, v, ok = next() {
+		if k <= prev {
+			t.Fatalf("key %d after %d, output not sorted", k, prev)
+		}
+		if seen[k] {
+			t.Fatalf("key %d produced twice", k)
+		}
+		if v == "" {
+			t.Errorf("key %d has no value", k)
+		}
+		seen[k] = true
+		prev = k
+	}
+	if len(seen) != len(want) {
+		t.Errorf("merged %d keys, want %d", len(seen), len(want))
+	}
+	if _, _, ok := next(); ok {
+		t.Error("exhausted iterator returned another entry")
+	}
+}
+
+func TestSeedRandomReproducible(t *testing.T) {
+	// levels builds a list from the same insert sequence and returns each node's height
+	levels := func(seed int64) []int {
+		SeedRandom(seed)
+		sl := NewSkipList[int, int](func(a, b int) bool { return a < b })
+		for i := 0; i < 500; i++ {
+			sl.Insert(i*7919%500, i)
+		}
+		var out []int
+		for n := sl.head.forward[0]; n != nil; n = n.forward[0] {
+			out = append(out, len(n.forward))
+		}
+		return out
+	}
+
+	a, b := levels(42), levels(42)
+	if len(a) != 500 || len(b) != 500 {
+		t.Fatalf("lists have %d and %d nodes, want 500", len(a), len(b))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("node %d has level %d in one list and %d in the other", i, a[i], b[i])
+		}
+	}
+
+	// A different seed should give a different shape
+	c := levels(43)
+	same := true
+	for i := range a {
+		if a[i] != c[i] {
+			same = false
+			break
+		}
+	}
+	if same {
+		t.Error("seeds 42 and 43 built identical level structures")
+	}
+}
+
+// Run with -race: each list draws levels from its own generator, so separate
+// lists can be filled at once, even while SeedRandom runs
+func TestSkipListsInsertConcurrently(t *testing.T) {
+	const perList = 2000
+	lists := []*SkipList[int, int]{NewIntSkipList[int](), NewIntSkipList[int]()}
+
+	var wg sync.WaitGroup
+	for _, sl := range lists {
+		wg.Add(1)
+		go func(sl *SkipList[int, int]) {
+			defer wg.Done()
+			for i := 0; i < perList; i++ {
+				sl.Insert(i*7919%perList, i)
+			}
+		}(sl)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			SeedRandom(int64(i))
+			NewIntSkipList[int]()
+		}
+	}()
+	wg.Wait()
+
+	for n, sl := range lists {
+		keys := keysOf(sl)
+		if sl.Len() != perList || len(keys) != perList {
+			t.Fatalf("list %d: Len() = %d with %d keys, want %d", n, sl.Len(), len(keys), perList)
+		}
+		for i, k := range keys {
+			if k != i {
+				t.Fatalf("list %d: key %d at position %d", n, k, i)
+			}
+		}
+	}
+}
+
+// keysOf returns sl's keys in iteration order
+func keysOf[K comparable, V any](sl *SkipList[K, V]) []K {
+	var keys []K
+	for it := sl.Iterator(); it.Next(); {
+		keys = append(keys, it.Key())
+	}
+	return keys
+}
+
+func TestNaturalOrderSkipLists(t *testing.T) {
+	ints := NewIntSkipList[bool]()
+	for _, k := range []int{5, -3, 42, 0, 7} {
+		ints.Insert(k, true)
+	}
+	if got := fmt.Sprint(keysOf(ints)); got != "[-3 0 5 7 42]" {
+		t.Errorf("int keys = %s", got)
+	}
+
+	strs := NewStringSkipList[bool]()
+	for _, k := range []string{"pear", "Apple", "apple", "banana", ""} {
+		strs.Insert(k, true)
+	}
+	if got := fmt.Sprintf("%q", keysOf(strs)); got != `["" "Apple" "apple" "banana" "pear"]` {
+		t.Errorf("string keys = %s", got)
+	}
+
+	floats := NewFloat64SkipList[bool]()
+	for _, k := range []float64{2.5, -1e9, 0.1, 3, -0.5} {
+		floats.Insert(k, true)
+	}
+	if got := fmt.Sprint(keysOf(floats)); got != "[-1e+09 -0.5 0.1 2.5 3]" {
+		t.Errorf("float64 keys = %s", got)
+	}
+}
+
+func TestFloorCeiling(t *testing.T) {
+	sl := NewIntSkipList[string]()
+	for _, k := range []int{10, 20, 30, 40} {
+		sl.Insert(k, fmt.Sprint("v", k))
+	}
+
+	cases := []struct {
+		key                  int
+		floor, ceiling       int
+		hasFloor, hasCeiling bool
+	}{
+		{20, 20, 20, true, true}, // Present
+		{25, 20, 30, true, true}, // Between entries
+		{5, 0, 10, false, true},  // Below every key
+		{45, 40, 0, true, false}, // Above every key
+		{10, 10, 10, true, true}, // Smallest key
+		{40, 40, 40, true, true}, // Largest key
+	}
+	for _, c := range cases {
+		k, v, ok := sl.Floor(c.key)
+		if ok != c.hasFloor || (ok && (k != c.floor || v != fmt.Sprint("v", c.floor))) {
+			t.Errorf("Floor(%d) = %d, %q, %v; want %d, %v", c.key, k, v, ok, c.floor, c.hasFloor)
+		}
+		k, v, ok = sl.Ceiling(c.key)
+		if ok != c.hasCeiling || (ok && (k != c.ceiling || v != fmt.Sprint("v", c.ceiling))) {
+			t.Errorf("Ceiling(%d) = %d, %q, %v; want %d, %v", c.key, k, v, ok, c.ceiling, c.hasCeiling)
+		}
+	}
+
+	empty := NewIntSkipList[string]()
+	if _, _, ok := empty.Floor(1); ok {
+		t.Error("Floor found a key in an empty list")
+	}
+	if _, _, ok := empty.Ceiling(1); ok {
+		t.Error("Ceiling found a key in an empty list")
+	}
+}
+
+func TestForEachInRangeDoublesValues(t *testing.T) {
+	sl := NewIntSkipList[int]()
+	for k := 0; k < 20; k++ {
+		sl.Insert(k, k*10)
+	}
+
+	var visited []int
+	sl.ForEachInRange(5, 12, func(k int, v *int) bool {
+		visited = append(visited, k)
+		*v *= 2
+		return true
+	})
+	if len(visited) != 7 || visited[0] != 5 || visited[6] != 11 {
+		t.Errorf("visited %v, want 5..11", visited)
+	}
+	for k := 0; k < 20; k++ {
+		want := k * 10
+		if k >= 5 && k < 12 {
+			want *= 2
+		}
+		if v, _ := sl.Search(k); v != want {
+			t.Errorf("key %d = %d, want %d", k, v, want)
+		}
+	}
+
+	// Returning false stops the walk after that entry
+	calls := 0
+	sl.ForEachInRange(0, 20, func(k int, v *int) bool {
+		calls++
+		return k < 2
+	})
+	if calls != 3 {
+		t.Errorf("fn called %d times, want 3 (keys 0, 1, 2)", calls)
+	}
+}
+
+func TestShortestPaths(t *testing.T) {
+	// B is first reached directly at 4, then through C at 3: a decrease-key.
+	// D is first reached through C at 9, then lowered to 8 through B.
+	graph := map[string]map[string]float64{
+		"A": {"B": 4, "C": 1},
+		"C": {"B": 2, "D": 8},
+		"B": {"D": 5},
+		"D": {"E": 3},
+		"F": {"A": 1}, // Unreachable from A
+	}
+	dist := ShortestPaths(graph, "A")
+	want := map[string]float64{"A": 0, "C": 1, "B": 3, "D": 8, "E": 11}
+	if fmt.Sprint(dist) != fmt.Sprint(want) {
+		t.Errorf("ShortestPaths = %v, want %v", dist, want)
+	}
+}
+
+func TestPriorityQueueUpdate(t *testing.T) {
+	pq := NewPriorityQueue[string, int]()
+	pq.Push("a", 5)
+	pq.Push("b", 3)
+	pq.Push("c", 7)
+	if !pq.Update("c", 1) || pq.Update("missing", 0) {
+		t.Fatal("Update reported the wrong items as queued")
+	}
+	pq.Push("b", 9) // Pushing a queued item moves it too
+
+	var order []string
+	for pq.Len() > 0 {
+		item, priority, _ := pq.Pop()
+		order = append(order, fmt.Sprint(item, priority))
+	}
+	if fmt.Sprint(order) != "[c1 a5 b9]" {
+		t.Errorf("popped %v, want [c1 a5 b9]", order)
+	}
+	if _, _, ok := pq.Pop(); ok {
+		t.Error("Pop on an empty queue reported an item")
+	}
+}
+
+func TestIteratorAscending(t *testing.T) {
+	// A descending comparator: iteration follows less, not the natural order
+	sl := NewSkipList[int, string](func(a, b int) bool { return a > b })
+	for _, k := range []int{3, 9, 1, 7, 5} {
+		sl.Insert(k, fmt.Sprint("v", k))
+	}
+
+	var got []string
+	for it := sl.Iterator(); it.Next(); {
+		got = append(got, fmt.Sprint(it.Key(), "=", it.Value()))
+	}
+	if fmt.Sprint(got) != "[9=v9 7=v7 5=v5 3=v3 1=v1]" {
+		t.Errorf("iterated %v, want keys in comparator order 9..1", got)
+	}
+	if NewIntSkipList[int]().Iterator().Next() {
+		t.Error("iterator over an empty list returned an entry")
+	}
+}
+
+func TestForEachStopsEarly(t *testing.T) {
+	sl := NewIntSkipList[int]()
+	for k := 10; k > 0; k-- {
+		sl.Insert(k, k*k)
+	}
+
+	var visited []int
+	sl.ForEach(func(k, v int) bool {
+		visited = append(visited, k)
+		return v < 9
+	})
+	if fmt.Sprint(visited) != "[1 2 3]" {
+		t.Errorf("visited %v, want [1 2 3]: stop after the first value of 9 or more", visited)
+	}
+}
+
+func TestRebalanceRestoresLevels(t *testing.T) {
+	SeedRandom(7)
+	sl := NewIntSkipList[int]()
+	for k := 0; k < 20000; k++ {
+		sl.Insert(k, k)
+	}
+	// Pathological deletes: remove every node with an express lane, leaving a
+	// plain linked list where every search is a linear scan
+	var tall []int
+	for node := sl.head.forward[0]; node != nil; node = node.forward[0] {
+		if len(node.forward) > 1 {
+			tall = append(tall, node.key)
+		}
+	}
+	for _, k := range tall {
+		sl.Delete(k)
+	}
+	if sl.level != 1 {
+		t.Fatalf("level %d after deleting every tall node, want 1", sl.level)
+	}
+	remaining := sl.Len()
+
+	sl.Rebalance()
+
+	// log4 of ~15000 entries is about 7; a couple more is normal variation
+	if sl.level < 4 || sl.level > 10 {
+		t.Errorf("level %d after rebalancing %d entries", sl.level, remaining)
+	}
+	tallNow := 0
+	for node := sl.head.forward[0]; node != nil; node = node.forward[0] {
+		if len(node.forward) > 1 {
+			tallNow++
+		}
+	}
+	if share := float64(tallNow) / float64(remaining); share < 0.2 || share > 0.3 {
+		t.Errorf("%.2f of nodes have an express lane, want about p = %v", share, p)
+	}
+
+	if sl.Len() != remaining {
+		t.Errorf("Len() = %d after rebalancing, want %d", sl.Len(), remaining)
+	}
+	deleted := make(map[int]bool, len(tall))
+	for _, k := range tall {
+		deleted[k] = true
+	}
+	for k := 0; k < 20000; k += 7 {
+		v, ok := sl.Search(k)
+		if ok == deleted[k] || (ok && v != k) {
+			t.Fatalf("Search(%d) = %d, %v after rebalancing; deleted: %v", k, v, ok, deleted[k])
+		}
+	}
+	// Spans were rebuilt too, so ranks still line up
+	if k, _, ok := sl.Select(remaining - 1); !ok || sl.Rank(k) != remaining-1 {
+		t.Errorf("Select/Rank disagree after rebalancing: last key %d, rank %d", k, sl.Rank(k))
+	}
+}
+
+func TestRangeBoundaries(t *testing.T) {
+	ints := NewIntSkipList[string]()
+	for _, k := range []int{10, 20, 30, 40, 50} {
+		ints.Insert(k, fmt.Sprint("v", k))
+	}
+	keys := func(items []KV[int, string]) []int {
+		ks := []int{}
+		for _, kv := range items {
+			ks = append(ks, kv.Key)
+		}
+		return ks
+	}
+	for _, c := range []struct {
+		lo, hi int
+		want   string
+	}{
+		{20, 40, "[20 30 40]"},       // Inclusive on both ends
+		{15, 45, "[20 30 40]"},       // Bounds between keys
+		{30, 30, "[30]"},             // Single element
+		{31, 39, "[]"},               // Empty, between keys
+		{40, 20, "[]"},               // hi below lo
+		{0, 100, "[10 20 30 40 50]"}, // Covers everything
+		{60, 70, "[]"},               // Past the end
+	} {
+		if got := fmt.Sprint(keys(ints.Range(c.lo, c.hi))); got != c.want {
+			t.Errorf("Range(%d, %d) = %s, want %s", c.lo, c.hi, got, c.want)
+		}
+	}
+	if got := ints.Range(30, 30); len(got) != 1 || got[0].Value != "v30" {
+		t.Errorf("Range(30, 30) = %v, want the value too", got)
+	}
+
+	strs := NewStringSkipList[int]()
+	for i, k := range []string{"apple", "banana", "cherry", "date"} {
+		strs.Insert(k, i)
+	}
+	var got []string
+	strs.RangeFunc("b", "cz", func(k string, _ int) bool {
+		got = append(got, k)
+		return true
+	})
+	if fmt.Sprint(got) != "[banana cherry]" {
+		t.Errorf("RangeFunc(b, cz) = %v, want [banana cherry]", got)
+	}
+	if r := strs.Range("banana", "banana"); len(r) != 1 {
+		t.Errorf("Range(banana, banana) = %v, want one entry", r)
+	}
+}
+
+func TestTTLCacheCleanupEvicts(t *testing.T) {
+	c := NewTTLCache(time.Minute, 10*time.Millisecond)
+	defer c.Close()
+	for i := 0; i < 20; i++ {
+		c.SetWithTTL(fmt.Sprint("short", i), i, 5*time.Millisecond)
+	}
+	c.Set("long", "stays")
+
+	// No Get: only the cleanup loop can remove the expired entries
+	deadline := time.Now().Add(time.Second)
+	for c.Len() > 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len() = %d after cleanup ticks, want 1", c.Len())
+	}
+	if _, ok := c.Get("long"); !ok {
+		t.Error("cleanup removed an entry that hasn't expired")
+	}
+}
+
+func TestSkipListLen(t *testing.T) {
+	sl := NewStringSkipList[int]()
+	steps := []struct {
+		op   string
+		key  string
+		want int
+	}{
+		{"insert", "a", 1},
+		{"insert", "b", 2},
+		{"insert", "a", 2}, // Update, not a new key
+		{"delete", "missing", 2},
+		{"delete", "a", 1},
+		{"delete", "a", 1}, // Already gone
+		{"delete", "b", 0},
+	}
+	for i, s := range steps {
+		if s.op == "insert" {
+			sl.Insert(s.key, i)
+		} else {
+			sl.Delete(s.key)
+		}
+		if got := sl.Len(); got != s.want {
+			t.Errorf("after %s %q: Len() = %d, want %d", s.op, s.key, got, s.want)
+		}
+	}
+}
+
+func TestSelectRankMatchSorted(t *testing.T) {
+	SeedRandom(7)
+	r := rand.New(rand.NewSource(7))
+	sl := NewIntSkipList[int]()
+	present := make(map[int]bool)
+	for i := 0; i < 400; i++ {
+		k := r.Intn(1000) // Some repeats, so a few inserts are updates
+		sl.Insert(k, -k)
+		present[k] = true
+	}
+	// Deletes have to keep the spans right too
+	for k := range present {
+		if r.Intn(4) == 0 {
+			sl.Delete(k)
+			delete(present, k)
+		}
+	}
+
+	sorted := make([]int, 0, len(present))
+	for k := range present {
+		sorted = append(sorted, k)
+	}
+	sort.Ints(sorted)
+	if sl.Len() != len(sorted) {
+		t.Fatalf("Len() = %d, want %d", sl.Len(), len(sorted))
+	}
+
+	for i, want := range sorted {
+		k, v, ok := sl.Select(i)
+		if !ok || k != want || v != -want {
+			t.Fatalf("Select(%d) = %d, %d, %v, want %d, %d, true", i, k, v, ok, want, -want)
+		}
+		if got := sl.Rank(want); got != i {
+			t.Fatalf("Rank(%d) = %d, want %d", want, got, i)
+		}
+	}
+	for _, rank := range []int{-1, len(sorted)} {
+		if _, _, ok := sl.Select(rank); ok {
+			t.Errorf("Select(%d) found an entry out of range", rank)
+		}
+	}
+	for k := 0; k < 1000; k++ {
+		if !present[k] && sl.Rank(k) != -1 {
+			t.Fatalf("Rank(%d) = %d for a missing key, want -1", k, sl.Rank(k))
+		}
+	}
+}
+
+// Run with -race to check the locking too
+func TestConcurrentSkipListMixedAccess(t *testing.T) {
+	list := NewConcurrentSkipList[int, int](func(a, b int) bool { return a < b })
+	const writers, readers, perWriter = 4, 4, 500
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; ; k = (k + 7) % (writers * perWriter) {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Whatever a reader sees must be a value some writer stored for that key
+				if v, ok := list.Search(k); ok && v != k*10 {
+					t.Errorf("Search(%d) = %d, want %d", k, v, k*10)
+					return
+				}
+			}
+		}()
+	}
+
+	// Each writer owns a slice of the keys: it inserts them all, then deletes the odd ones
+	var writing sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writing.Add(1)
+		go func(base int) {
+			defer writing.Done()
+			for k := base; k < base+perWriter; k++ {
+				list.Insert(k, k*10)
+			}
+			for k := base + 1; k < base+perWriter; k += 2 {
+				if !list.Delete(k) {
+					t.Errorf("Delete(%d) found nothing", k)
+				}
+			}
+		}(w * perWriter)
+	}
+	writing.Wait()
+	close(stop)
+	wg.Wait()
+
+	if list.Len() != writers*perWriter/2 {
+		t.Fatalf("Len() = %d, want %d", list.Len(), writers*perWriter/2)
+	}
+	for k := 0; k < writers*perWriter; k++ {
+		v, ok := list.Search(k)
+		if want := k%2 == 0; ok != want || (ok && v != k*10) {
+			t.Errorf("Search(%d) = %d, %v, want only even keys with value %d", k, v, ok, k*10)
+		}
+	}
+}
+
+func TestMinMaxPopMin(t *testing.T) {
+	sl := NewIntSkipList[string]()
+	if _, _, ok := sl.Min(); ok {
+		t.Error("Min found an entry in an empty list")
+	}
+	if _, _, ok := sl.Max(); ok {
+		t.Error("Max found an entry in an empty list")
+	}
+	if _, _, ok := sl.PopMin(); ok {
+		t.Error("PopMin found an entry in an empty list")
+	}
+
+	const n = 200
+	for _, k := range rand.New(rand.NewSource(3)).Perm(n) {
+		sl.Insert(k, fmt.Sprint("v", k))
+	}
+	if k, v, ok := sl.Min(); !ok || k != 0 || v != "v0" {
+		t.Errorf("Min() = %d, %q, %v, want 0, \"v0\", true", k, v, ok)
+	}
+	if k, v, ok := sl.Max(); !ok || k != n-1 || v != fmt.Sprint("v", n-1) {
+		t.Errorf("Max() = %d, %q, %v, want %d, \"v%d\", true", k, v, ok, n-1, n-1)
+	}
+
+	for want := 0; want < n; want++ {
+		k, v, ok := sl.PopMin()
+		if !ok || k != want || v != fmt.Sprint("v", want) {
+			t.Fatalf("PopMin() = %d, %q, %v, want %d", k, v, ok, want)
+		}
+		if sl.Len() != n-want-1 {
+			t.Fatalf("Len() = %d after popping %d, want %d", sl.Len(), want, n-want-1)
+		}
+		// The spans must still add up, or Select would be off
+		if want < n-1 {
+			if k, _, _ := sl.Select(0); k != want+1 {
+				t.Fatalf("Select(0) = %d after popping %d, want %d", k, want, want+1)
+			}
+			if k, _, _ := sl.Select(sl.Len() - 1); k != n-1 {
+				t.Fatalf("Select(%d) = %d after popping %d, want %d", sl.Len()-1, k, want, n-1)
+			}
+		}
+	}
+	if _, _, ok := sl.PopMin(); ok {
+		t.Error("PopMin found an entry after draining the list")
+	}
+	if _, _, ok := sl.Max(); ok {
+		t.Error("Max found an entry after draining the list")
+	}
+}
+
+func TestTTLCacheCap(t *testing.T) {
+	const maxEntries, n = 10, 50
+	c := NewTTLCacheWithCap(time.Hour, time.Hour, maxEntries)
+	defer c.Close()
+
+	for i := 0; i < n; i++ {
+		c.Set(fmt.Sprintf("key%02d", i), i)
+		if c.Len() > maxEntries {
+			t.Fatalf("Len() = %d after %d sets, over the cap of %d", c.Len(), i+1, maxEntries)
+		}
+	}
+	// With equal TTLs the most recently set keys expire last, so they survive
+	for i := 0; i < n; i++ {
+		_, ok := c.Get(fmt.Sprintf("key%02d", i))
+		if want := i >= n-maxEntries; ok != want {
+			t.Errorf("key%02d present = %v, want %v", i, ok, want)
+		}
+	}
+
+	// Setting an old key again makes it the newest, so the next oldest goes instead
+	oldest, next := fmt.Sprintf("key%02d", n-maxEntries), fmt.Sprintf("key%02d", n-maxEntries+1)
+	c.Set(oldest, "again")
+	c.SetWithTTL("stale", 0, -time.Second)
+	if _, ok := c.Get(next); ok {
+		t.Errorf("%s survived, though it was the oldest after %s was set again", next, oldest)
+	}
+	if v, ok := c.Get(oldest); !ok || v != "again" {
+		t.Errorf("%s = %v, %v, want \"again\", true", oldest, v, ok)
+	}
+	// An already expired item goes before any live one
+	c.Set("new", n)
+	if _, ok := c.Get("stale"); ok {
+		t.Error("the expired item survived eviction")
+	}
+	if _, ok := c.Get(fmt.Sprintf("key%02d", n-maxEntries+2)); !ok {
+		t.Error("a live key was evicted while an expired one was in the cache")
+	}
+	if c.Len() != maxEntries {
+		t.Errorf("Len() = %d, want the cap %d", c.Len(), maxEntries)
+	}
+}